@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder keeps a copy of what a handler writes
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Idempotency makes a request sent again with the same Idempotency-Key header get the
+// response of the first one instead of being processed twice. Reusing a key for another
+// request is refused. Requests without the header are processed as usual.
+func Idempotency() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get("Idempotency-Key")
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Idempotency-Key is too long"})
+			}
+
+			user := c.Get("user").(entities.UserBasicStruct)
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			hash.Write([]byte(c.Request().Method + " " + c.Request().URL.Path + "\n"))
+			hash.Write(body)
+			fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+			record, reserved, err := models.ReserveIdempotencyKey(user.Id, key, fingerprint)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to check Idempotency-Key: " + err.Error()})
+			}
+
+			if !reserved {
+				switch {
+				case record.Fingerprint != fingerprint:
+					return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Idempotency-Key was already used for another request"})
+				case record.Status != "completed":
+					// Until its lock runs out, the request may then be retried
+					return c.JSON(http.StatusConflict, map[string]string{"error": "a request with this Idempotency-Key is still being processed"})
+				}
+				c.Response().Header().Set("Idempotent-Replayed", "true")
+				return c.Blob(record.ResponseStatus, record.ContentType, record.ResponseBody)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+
+			err = next(c)
+
+			// Server errors are not stored, the request may succeed when retried
+			status := c.Response().Status
+			if err != nil || status >= http.StatusInternalServerError || !c.Response().Committed {
+				if releaseErr := models.ReleaseIdempotencyKey(record); releaseErr != nil {
+					log.Printf("Failed to release Idempotency-Key %s: %v", record.Id, releaseErr)
+				}
+				return err
+			}
+
+			contentType := c.Response().Header().Get(echo.HeaderContentType)
+			if err := models.CompleteIdempotencyKey(record, status, contentType, recorder.body.Bytes()); err != nil {
+				log.Printf("Failed to store the response of Idempotency-Key %s: %v", record.Id, err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultCacheTTL = 7 * 24 * time.Hour
+
+// CacheEntry is a raw OpenFoodFacts payload, as it was fetched
+type CacheEntry struct {
+	Reference string    `bson:"_id"`
+	Source    string    `bson:"source"`
+	Payload   string    `bson:"payload"`
+	FetchedAt time.Time `bson:"fetchedAt"`
+}
+
+// CacheStore keeps the entries of a CachedSource. Get returns mongo.ErrNoDocuments for an
+// unknown reference.
+type CacheStore interface {
+	Get(ctx context.Context, reference string) (CacheEntry, error)
+	Put(ctx context.Context, entry CacheEntry) error
+}
+
+// CachedSource keeps the raw OpenFoodFacts payloads in the catalog_cache collection.
+// Fresh entries are served without calling OpenFoodFacts, and stale entries are
+// still served when OpenFoodFacts cannot be reached.
+type CachedSource struct {
+	Upstream *OpenFoodFactsClient
+	TTL      time.Duration
+	Store    CacheStore
+}
+
+// NewCachedSource reads the cache lifetime from CATALOG_CACHE_TTL (e.g. "168h")
+func NewCachedSource(upstream *OpenFoodFactsClient) *CachedSource {
+	ttl := defaultCacheTTL
+	if value := os.Getenv("CATALOG_CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid CATALOG_CACHE_TTL %q, using %v: %v", value, defaultCacheTTL, err)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	return &CachedSource{Upstream: upstream, TTL: ttl, Store: mongoCacheStore{}}
+}
+
+func (cs *CachedSource) Name() string {
+	return cs.Upstream.Name()
+}
+
+func (cs *CachedSource) Lookup(ctx context.Context, reference string) (entities.ProductStruct, error) {
+	entry, err := cs.Store.Get(ctx, reference)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Catalog cache unavailable for %s: %v", reference, err)
+	}
+
+	if err == nil && time.Since(entry.FetchedAt) < cs.TTL {
+		return ParseOpenFoodFacts(reference, []byte(entry.Payload))
+	}
+
+	product, fetchErr := cs.Refresh(ctx, reference)
+	if fetchErr == nil {
+		return product, nil
+	}
+
+	// Offline fallback: a stale payload is better than no product at all
+	if err == nil && !errors.Is(fetchErr, ErrNotFound) {
+		log.Printf("Serving stale catalog entry for %s: %v", reference, fetchErr)
+		return ParseOpenFoodFacts(reference, []byte(entry.Payload))
+	}
+
+	return entities.ProductStruct{}, fetchErr
+}
+
+// Refresh fetches the reference upstream and overwrites the cached payload
+func (cs *CachedSource) Refresh(ctx context.Context, reference string) (entities.ProductStruct, error) {
+	payload, err := cs.Upstream.Fetch(ctx, reference)
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+
+	product, err := ParseOpenFoodFacts(reference, payload)
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+
+	entry := CacheEntry{
+		Reference: reference,
+		Source:    cs.Upstream.Name(),
+		Payload:   string(payload),
+		FetchedAt: time.Now(),
+	}
+	if err := cs.Store.Put(ctx, entry); err != nil {
+		log.Printf("Failed to cache catalog entry for %s: %v", reference, err)
+	}
+
+	return product, nil
+}
+
+// mongoCacheStore is the CacheStore of the catalog_cache collection
+type mongoCacheStore struct{}
+
+func (mongoCacheStore) Get(ctx context.Context, reference string) (CacheEntry, error) {
+	collection := db.GetDatabase().Collection("catalog_cache")
+
+	var entry CacheEntry
+	err := collection.FindOne(ctx, bson.M{"_id": reference}).Decode(&entry)
+	return entry, err
+}
+
+func (mongoCacheStore) Put(ctx context.Context, entry CacheEntry) error {
+	collection := db.GetDatabase().Collection("catalog_cache")
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": entry.Reference}, entry, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to store catalog entry: %v", err)
+	}
+	return nil
+}
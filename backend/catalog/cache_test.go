@@ -0,0 +1,138 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryCacheStore keeps the cache entries of the tests in memory
+type memoryCacheStore map[string]CacheEntry
+
+func (m memoryCacheStore) Get(ctx context.Context, reference string) (CacheEntry, error) {
+	entry, ok := m[reference]
+	if !ok {
+		return CacheEntry{}, mongo.ErrNoDocuments
+	}
+	return entry, nil
+}
+
+func (m memoryCacheStore) Put(ctx context.Context, entry CacheEntry) error {
+	m[entry.Reference] = entry
+	return nil
+}
+
+// newTestCache returns a cache of a fake OpenFoodFacts answering with status, or the test
+// payload when it is 200, and counts the calls it gets
+func newTestCache(t *testing.T, status *atomic.Int32, calls *atomic.Int32) (*CachedSource, memoryCacheStore) {
+	payload := readTestPayload(t)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if code := int(status.Load()); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write(payload)
+	})
+	client.MaxRetries = 1
+
+	store := memoryCacheStore{}
+	return &CachedSource{Upstream: client, TTL: time.Hour, Store: store}, store
+}
+
+func TestCachedSourceServesFreshEntries(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusOK)
+	cache, store := newTestCache(t, &status, &calls)
+
+	for i := 0; i < 2; i++ {
+		product, err := cache.Lookup(context.Background(), "3017620422003")
+		if err != nil {
+			t.Fatalf("Lookup %d: %v", i, err)
+		}
+		if product.Name != "Nutella" {
+			t.Errorf("Lookup %d: got name %q", i, product.Name)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d upstream calls, want 1", calls.Load())
+	}
+	if entry := store["3017620422003"]; entry.Source != "openfoodfacts" || entry.Payload == "" {
+		t.Errorf("got cache entry %+v", entry)
+	}
+}
+
+func TestCachedSourceRefreshesExpiredEntries(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusOK)
+	cache, store := newTestCache(t, &status, &calls)
+
+	expired := time.Now().Add(-2 * time.Hour)
+	store["3017620422003"] = CacheEntry{
+		Reference: "3017620422003",
+		Payload:   `{"status":1,"product":{"product_name":"Old name"}}`,
+		FetchedAt: expired,
+	}
+
+	product, err := cache.Lookup(context.Background(), "3017620422003")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if product.Name != "Nutella" || calls.Load() != 1 {
+		t.Errorf("got name %q after %d upstream calls, want the refreshed product", product.Name, calls.Load())
+	}
+	if !store["3017620422003"].FetchedAt.After(expired) {
+		t.Errorf("the expired entry was not replaced")
+	}
+}
+
+func TestCachedSourceFallsBackToStaleEntries(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusBadGateway)
+	cache, store := newTestCache(t, &status, &calls)
+
+	store["3017620422003"] = CacheEntry{
+		Reference: "3017620422003",
+		Payload:   `{"status":1,"product":{"product_name":"Stale name"}}`,
+		FetchedAt: time.Now().Add(-2 * time.Hour),
+	}
+
+	product, err := cache.Lookup(context.Background(), "3017620422003")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if product.Name != "Stale name" || calls.Load() != 1 {
+		t.Errorf("got name %q after %d upstream calls, want the stale product", product.Name, calls.Load())
+	}
+}
+
+func TestCachedSourceDoesNotServeRemovedProducts(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusNotFound)
+	cache, store := newTestCache(t, &status, &calls)
+
+	store["3017620422003"] = CacheEntry{
+		Reference: "3017620422003",
+		Payload:   `{"status":1,"product":{"product_name":"Stale name"}}`,
+		FetchedAt: time.Now().Add(-2 * time.Hour),
+	}
+
+	if _, err := cache.Lookup(context.Background(), "3017620422003"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestCachedSourceWithoutEntry(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusBadGateway)
+	cache, _ := newTestCache(t, &status, &calls)
+
+	if _, err := cache.Lookup(context.Background(), "3017620422003"); err == nil {
+		t.Error("Lookup succeeded without upstream nor cache entry")
+	}
+}
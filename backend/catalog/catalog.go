@@ -0,0 +1,53 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"trinity/backend/items/entities"
+)
+
+// ErrNotFound is returned when a source does not know the requested reference
+var ErrNotFound = errors.New("product not found in catalog")
+
+// CatalogSource looks up product details (name, brand, categories, images, nutrition)
+// from a barcode reference. Prices and stock are never provided by a source.
+type CatalogSource interface {
+	Name() string
+	Lookup(ctx context.Context, reference string) (entities.ProductStruct, error)
+}
+
+// Refresher is implemented by sources able to bypass their cache on demand
+type Refresher interface {
+	Refresh(ctx context.Context, reference string) (entities.ProductStruct, error)
+}
+
+// Chain tries each source in order and returns the first successful lookup
+type Chain []CatalogSource
+
+func (ch Chain) Name() string {
+	return "chain"
+}
+
+func (ch Chain) Lookup(ctx context.Context, reference string) (entities.ProductStruct, error) {
+	product, _, err := ch.LookupWithSource(ctx, reference)
+	return product, err
+}
+
+// LookupWithSource also returns the name of the source that answered
+func (ch Chain) LookupWithSource(ctx context.Context, reference string) (entities.ProductStruct, string, error) {
+	var errs []error
+	for _, source := range ch {
+		product, err := source.Lookup(ctx, reference)
+		if err == nil {
+			return product, source.Name(), nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source.Name(), err))
+	}
+
+	if len(errs) == 0 {
+		return entities.ProductStruct{}, "", ErrNotFound
+	}
+	return entities.ProductStruct{}, "", errors.Join(errs...)
+}
@@ -0,0 +1,35 @@
+package catalog
+
+import (
+	"context"
+
+	"trinity/backend/items/entities"
+)
+
+// ManualSource answers with the details typed in by an employee when no
+// external catalog knows the product
+type ManualSource struct {
+	Product entities.ProductStruct
+}
+
+func NewManualSource(name string, brand string, category string) ManualSource {
+	return ManualSource{Product: entities.ProductStruct{
+		Name:     name,
+		Brand:    brand,
+		Category: category,
+	}}
+}
+
+func (m ManualSource) Name() string {
+	return "manual"
+}
+
+func (m ManualSource) Lookup(ctx context.Context, reference string) (entities.ProductStruct, error) {
+	if m.Product.Name == "" {
+		return entities.ProductStruct{}, ErrNotFound
+	}
+
+	product := m.Product
+	product.Reference = reference
+	return product, nil
+}
@@ -0,0 +1,290 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"trinity/backend/items/entities"
+)
+
+const (
+	defaultOpenFoodFactsURL = "https://world.openfoodfacts.org"
+	openFoodFactsTimeout    = 10 * time.Second
+	openFoodFactsRetries    = 3
+	openFoodFactsBackoff    = 500 * time.Millisecond
+)
+
+// OpenFoodFactsClient fetches products from the OpenFoodFacts API.
+// BaseURL can point to a fake server in tests (OFF_BASE_URL).
+type OpenFoodFactsClient struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+func NewOpenFoodFactsClient() *OpenFoodFactsClient {
+	baseURL := strings.TrimSpace(os.Getenv("OFF_BASE_URL"))
+	if baseURL == "" {
+		baseURL = defaultOpenFoodFactsURL
+	}
+
+	return &OpenFoodFactsClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: openFoodFactsTimeout},
+		MaxRetries: openFoodFactsRetries,
+		Backoff:    openFoodFactsBackoff,
+	}
+}
+
+func (o *OpenFoodFactsClient) Name() string {
+	return "openfoodfacts"
+}
+
+func (o *OpenFoodFactsClient) Lookup(ctx context.Context, reference string) (entities.ProductStruct, error) {
+	payload, err := o.Fetch(ctx, reference)
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+	return ParseOpenFoodFacts(reference, payload)
+}
+
+// Fetch returns the raw OpenFoodFacts payload for a reference, retrying on
+// network errors, rate limiting and server errors
+func (o *OpenFoodFactsClient) Fetch(ctx context.Context, reference string) ([]byte, error) {
+	url := fmt.Sprintf("%s/api/v0/product/%s.json", o.BaseURL, reference)
+
+	var lastErr error
+	backoff := o.Backoff
+	for attempt := 1; attempt <= o.MaxRetries; attempt++ {
+		payload, retry, err := o.fetchOnce(ctx, url)
+		if err == nil {
+			return payload, nil
+		}
+		lastErr = err
+		if !retry || attempt == o.MaxRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	return nil, lastErr
+}
+
+func (o *OpenFoodFactsClient) fetchOnce(ctx context.Context, url string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("error building request: %v", err)
+	}
+	req.Header.Set("User-Agent", "Trinity/1.0")
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("error fetching product data: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, ErrNotFound
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return nil, true, fmt.Errorf("Openfood API returned non-200 status code: %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("Openfood API returned non-200 status code: %d", resp.StatusCode)
+	}
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("error reading response: %v", err)
+	}
+
+	return payload, false, nil
+}
+
+type openFoodFactsNutriments struct {
+	EnergyKcal100g      float64 `json:"energy-kcal_100g"`
+	EnergyKj100g        float64 `json:"energy-kj_100g"`
+	Fat100g             float64 `json:"fat_100g"`
+	SaturatedFat100g    float64 `json:"saturated-fat_100g"`
+	Carbs100g           float64 `json:"carbohydrates_100g"`
+	Sugars100g          float64 `json:"sugars_100g"`
+	Fiber100g           float64 `json:"fiber_100g"`
+	Proteins100g        float64 `json:"proteins_100g"`
+	Salt100g            float64 `json:"salt_100g"`
+	EnergyKcalServing   float64 `json:"energy-kcal_serving"`
+	EnergyKjServing     float64 `json:"energy-kj_serving"`
+	FatServing          float64 `json:"fat_serving"`
+	SaturatedFatServing float64 `json:"saturated-fat_serving"`
+	CarbsServing        float64 `json:"carbohydrates_serving"`
+	SugarsServing       float64 `json:"sugars_serving"`
+	FiberServing        float64 `json:"fiber_serving"`
+	ProteinsServing     float64 `json:"proteins_serving"`
+	SaltServing         float64 `json:"salt_serving"`
+}
+
+type openFoodFactsResponse struct {
+	Status  int `json:"status"`
+	Product struct {
+		ProductName             string                  `json:"product_name"`
+		Brands                  string                  `json:"brands"`
+		Categories              string                  `json:"categories"`
+		ImageURL                string                  `json:"image_url"`
+		ImageThumbURL           string                  `json:"image_thumb_url"`
+		ServingSize             string                  `json:"serving_size"`
+		Nutriments              openFoodFactsNutriments `json:"nutriments"`
+		AllergensTags           []string                `json:"allergens_tags"`
+		NutriscoreGrade         string                  `json:"nutriscore_grade"`
+		NovaGroup               any                     `json:"nova_group"` // number or string
+		IngredientsText         string                  `json:"ingredients_text"`
+		LabelsTags              []string                `json:"labels_tags"`
+		IngredientsAnalysisTags []string                `json:"ingredients_analysis_tags"`
+	} `json:"product"`
+}
+
+// stripTagLanguage turns an OpenFoodFacts tag ("en:gluten") into its bare value ("gluten")
+func stripTagLanguage(tag string) string {
+	if i := strings.Index(tag, ":"); i >= 0 {
+		return tag[i+1:]
+	}
+	return tag
+}
+
+func stripTagsLanguage(tags []string) []string {
+	values := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if value := stripTagLanguage(tag); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseLabels keeps the OpenFoodFacts labels and adds the diet labels deduced from
+// the ingredients analysis (OpenFoodFacts reports "en:vegan" there rather than as a label)
+func parseLabels(labelsTags []string, analysisTags []string) []string {
+	labels := stripTagsLanguage(labelsTags)
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		seen[label] = true
+	}
+
+	for _, tag := range stripTagsLanguage(analysisTags) {
+		if (tag == "vegan" || tag == "vegetarian") && !seen[tag] {
+			labels = append(labels, tag)
+			seen[tag] = true
+		}
+	}
+
+	return labels
+}
+
+func parseNutrition(servingSize string, n openFoodFactsNutriments) entities.NutritionStruct {
+	nutrition := entities.NutritionStruct{
+		ServingSize: servingSize,
+		Per100g: entities.NutrientsStruct{
+			EnergyKcal:    n.EnergyKcal100g,
+			EnergyKj:      n.EnergyKj100g,
+			Fat:           n.Fat100g,
+			SaturatedFat:  n.SaturatedFat100g,
+			Carbohydrates: n.Carbs100g,
+			Sugars:        n.Sugars100g,
+			Fibre:         n.Fiber100g,
+			Proteins:      n.Proteins100g,
+			Salt:          n.Salt100g,
+		},
+	}
+
+	perServing := entities.NutrientsStruct{
+		EnergyKcal:    n.EnergyKcalServing,
+		EnergyKj:      n.EnergyKjServing,
+		Fat:           n.FatServing,
+		SaturatedFat:  n.SaturatedFatServing,
+		Carbohydrates: n.CarbsServing,
+		Sugars:        n.SugarsServing,
+		Fibre:         n.FiberServing,
+		Proteins:      n.ProteinsServing,
+		Salt:          n.SaltServing,
+	}
+	if servingSize != "" && perServing != (entities.NutrientsStruct{}) {
+		nutrition.PerServing = &perServing
+	}
+
+	return nutrition
+}
+
+// ParseOpenFoodFacts converts a raw OpenFoodFacts payload into a product
+func ParseOpenFoodFacts(reference string, payload []byte) (entities.ProductStruct, error) {
+	var openFoodFactsResp openFoodFactsResponse
+	if err := json.Unmarshal(payload, &openFoodFactsResp); err != nil {
+		return entities.ProductStruct{}, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	if openFoodFactsResp.Status != 1 {
+		return entities.ProductStruct{}, ErrNotFound
+	}
+
+	off := openFoodFactsResp.Product
+
+	nutritionalInfo := fmt.Sprintf(
+		"Energy: %.1f kcal/100g, Proteins: %.1fg/100g, Fat: %.1fg/100g, Carbohydrates: %.1fg/100g",
+		off.Nutriments.EnergyKcal100g,
+		off.Nutriments.Proteins100g,
+		off.Nutriments.Fat100g,
+		off.Nutriments.Carbs100g,
+	)
+
+	var novaGroup int
+	switch nova := off.NovaGroup.(type) {
+	case float64:
+		novaGroup = int(nova)
+	case string:
+		novaGroup, _ = strconv.Atoi(nova)
+	}
+
+	nutriScore := strings.ToLower(off.NutriscoreGrade)
+	if len(nutriScore) != 1 || nutriScore < "a" || nutriScore > "e" {
+		nutriScore = ""
+	}
+
+	// Products without allergen tags were never checked, unlike those with an empty list
+	var allergens []string
+	if off.AllergensTags != nil {
+		allergens = stripTagsLanguage(off.AllergensTags)
+	}
+
+	product := entities.ProductStruct{
+		Reference: reference,
+		Name:      off.ProductName,
+		Brand:     off.Brands,
+		Category:  off.Categories,
+		Images: entities.ImagesStruct{
+			S:      off.ImageThumbURL,
+			XL:     off.ImageURL,
+			Source: "openfoodfacts",
+		},
+		NutritionalInformation: nutritionalInfo,
+		Nutrition:              parseNutrition(off.ServingSize, off.Nutriments),
+		Allergens:              allergens,
+		NutriScore:             nutriScore,
+		NovaGroup:              novaGroup,
+		Ingredients:            off.IngredientsText,
+		Labels:                 parseLabels(off.LabelsTags, off.IngredientsAnalysisTags),
+		Archived:               false,
+	}
+
+	return product, nil
+}
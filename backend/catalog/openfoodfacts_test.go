@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient points an OpenFoodFacts client at a fake server answering with handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *OpenFoodFactsClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &OpenFoodFactsClient{
+		BaseURL:    server.URL,
+		HTTPClient: server.Client(),
+		MaxRetries: 3,
+		Backoff:    time.Millisecond,
+	}
+}
+
+func readTestPayload(t *testing.T) []byte {
+	t.Helper()
+	payload, err := os.ReadFile("testdata/3017620422003.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestFetchRetriesServerErrors(t *testing.T) {
+	payload := readTestPayload(t)
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/product/3017620422003.json" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write(payload)
+		}
+	})
+
+	got, err := client.Fetch(context.Background(), "3017620422003")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Errorf("Fetch returned another payload")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("got %d calls, want 3", calls.Load())
+	}
+}
+
+func TestFetchGivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	if _, err := client.Fetch(context.Background(), "3017620422003"); err == nil {
+		t.Fatal("Fetch succeeded, want an error")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("got %d calls, want 3", calls.Load())
+	}
+}
+
+func TestFetchBacksOffBetweenAttempts(t *testing.T) {
+	var attempts []time.Time
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, time.Now())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	client.Backoff = 20 * time.Millisecond
+
+	client.Fetch(context.Background(), "3017620422003")
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(attempts))
+	}
+	if wait := attempts[1].Sub(attempts[0]); wait < 20*time.Millisecond {
+		t.Errorf("first retry after %v, want at least 20ms", wait)
+	}
+	if wait := attempts[2].Sub(attempts[1]); wait < 40*time.Millisecond {
+		t.Errorf("second retry after %v, want at least 40ms", wait)
+	}
+}
+
+func TestFetchDoesNotRetryClientErrors(t *testing.T) {
+	for status, want := range map[int]error{http.StatusNotFound: ErrNotFound, http.StatusBadRequest: nil} {
+		var calls atomic.Int32
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(status)
+		})
+
+		_, err := client.Fetch(context.Background(), "0000000000000")
+		if err == nil || (want != nil && !errors.Is(err, want)) {
+			t.Errorf("status %d: got error %v, want %v", status, err, want)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("status %d: got %d calls, want 1", status, calls.Load())
+		}
+	}
+}
+
+func TestLookupUnknownProduct(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":"0000000000000","status":0,"status_verbose":"product not found"}`))
+	})
+
+	if _, err := client.Lookup(context.Background(), "0000000000000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestParseOpenFoodFacts(t *testing.T) {
+	product, err := ParseOpenFoodFacts("3017620422003", readTestPayload(t))
+	if err != nil {
+		t.Fatalf("ParseOpenFoodFacts: %v", err)
+	}
+
+	if product.Reference != "3017620422003" || product.Name != "Nutella" || product.Brand != "Nutella,Ferrero" {
+		t.Errorf("got reference %q, name %q, brand %q", product.Reference, product.Name, product.Brand)
+	}
+	if product.Images.S == "" || product.Images.XL == "" || product.Images.Source != "openfoodfacts" {
+		t.Errorf("got images %+v", product.Images)
+	}
+	if want := []string{"milk", "nuts", "soybeans"}; !reflect.DeepEqual(product.Allergens, want) {
+		t.Errorf("got allergens %v, want %v", product.Allergens, want)
+	}
+	if want := []string{"gluten-free", "nutriscore", "green-dot", "vegetarian"}; !reflect.DeepEqual(product.Labels, want) {
+		t.Errorf("got labels %v, want %v", product.Labels, want)
+	}
+	if product.NutriScore != "e" || product.NovaGroup != 4 {
+		t.Errorf("got nutri-score %q and NOVA group %d", product.NutriScore, product.NovaGroup)
+	}
+
+	nutrition := product.Nutrition
+	if nutrition.ServingSize != "15 g" || nutrition.Per100g.EnergyKcal != 539 || nutrition.Per100g.Sugars != 56.3 || nutrition.Per100g.Salt != 0.107 {
+		t.Errorf("got nutrition %+v", nutrition)
+	}
+	if nutrition.PerServing == nil || nutrition.PerServing.EnergyKj != 338 {
+		t.Errorf("got nutrition per serving %+v", nutrition.PerServing)
+	}
+}
+
+func TestParseOpenFoodFactsNovaGroupAsString(t *testing.T) {
+	product, err := ParseOpenFoodFacts("1", []byte(`{"status":1,"product":{"product_name":"Test","nova_group":"3","nutriscore_grade":"unknown"}}`))
+	if err != nil {
+		t.Fatalf("ParseOpenFoodFacts: %v", err)
+	}
+	if product.NovaGroup != 3 || product.NutriScore != "" {
+		t.Errorf("got NOVA group %d and nutri-score %q", product.NovaGroup, product.NutriScore)
+	}
+	if product.Nutrition.PerServing != nil {
+		t.Errorf("got nutrition per serving without a serving size")
+	}
+}
+
+func TestParseOpenFoodFactsUnknownAllergens(t *testing.T) {
+	unknown, err := ParseOpenFoodFacts("1", []byte(`{"status":1,"product":{"product_name":"Test"}}`))
+	if err != nil {
+		t.Fatalf("ParseOpenFoodFacts: %v", err)
+	}
+	if unknown.Allergens != nil {
+		t.Errorf("got allergens %v without allergen tags, want nil", unknown.Allergens)
+	}
+
+	none, err := ParseOpenFoodFacts("1", []byte(`{"status":1,"product":{"product_name":"Test","allergens_tags":[]}}`))
+	if err != nil {
+		t.Fatalf("ParseOpenFoodFacts: %v", err)
+	}
+	if none.Allergens == nil || len(none.Allergens) != 0 {
+		t.Errorf("got allergens %v with empty allergen tags, want an empty list", none.Allergens)
+	}
+}
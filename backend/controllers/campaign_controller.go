@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// campaignError answers with the status matching an error of the campaign models
+func campaignError(c echo.Context, err error) error {
+	switch {
+	case strings.HasPrefix(err.Error(), "no "):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case strings.Contains(err.Error(), " is already "):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+}
+
+// GetCampaigns lists the notification campaigns, ?status= keeps those with one status
+func GetCampaigns(c echo.Context) error {
+	campaigns, err := models.GetCampaigns(c.QueryParam("status"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, campaigns)
+}
+
+// GetCampaign returns a campaign with its delivery and open stats
+func GetCampaign(c echo.Context) error {
+	campaign, err := models.GetCampaignById(c.Param("id"))
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, campaign)
+}
+
+// CreateCampaign saves a campaign, scheduled when it has a scheduledAt and a draft otherwise
+func CreateCampaign(c echo.Context) error {
+	var req entities.CampaignInputStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	campaign, err := models.CreateCampaign(req)
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, campaign)
+}
+
+// UpdateCampaign replaces a campaign that was not sent yet
+func UpdateCampaign(c echo.Context) error {
+	var req entities.CampaignInputStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	campaign, err := models.UpdateCampaign(c.Param("id"), req)
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, campaign)
+}
+
+// SendCampaign sends a campaign now instead of at its scheduled time
+func SendCampaign(c echo.Context) error {
+	campaign, err := models.SendCampaign(c.Param("id"))
+	if err != nil {
+		if campaign.Status == "failed" {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return campaignError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, campaign)
+}
+
+// CancelCampaign keeps a scheduled campaign from being sent
+func CancelCampaign(c echo.Context) error {
+	campaign, err := models.CancelCampaign(c.Param("id"))
+	if err != nil {
+		return campaignError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, campaign)
+}
+
+func DeleteCampaign(c echo.Context) error {
+	if err := models.DeleteCampaign(c.Param("id")); err != nil {
+		return campaignError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Campaign deleted successfully"})
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// GetSelfCart returns the cart of the logged in user with the current prices, stock and
+// promotions
+func GetSelfCart(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	cart, err := models.GetCartDetails(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, cart)
+}
+
+func AddSelfCartItem(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req entities.CartItemStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	cart, err := models.AddCartItem(user.Id, req)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, cart)
+}
+
+func UpdateSelfCartItem(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req entities.CartQuantityStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	cart, err := models.SetCartItemQuantity(user.Id, c.Param("productId"), req.Quantity)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, cart)
+}
+
+func RemoveSelfCartItem(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	cart, err := models.RemoveCartItem(user.Id, c.Param("productId"))
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, cart)
+}
+
+// UpdateSelfCartOptions sets the currency, promo codes and loyalty points used at checkout
+func UpdateSelfCartOptions(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req entities.CartOptionsStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	cart, err := models.SetCartOptions(user.Id, req)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, cart)
+}
+
+func ClearSelfCart(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	if err := models.ClearCart(user.Id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Cart emptied successfully"})
+}
+
+// CheckoutSelfCart creates the order of the cart, the cart is emptied once the order is paid
+func CheckoutSelfCart(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	checkout, err := models.GetCartCheckout(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	return createOrder(c, user, checkout, true)
+}
+
+func cartError(c echo.Context, err error) error {
+	switch {
+	case strings.HasPrefix(err.Error(), "no "):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case strings.HasPrefix(err.Error(), "unsupported"):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+}
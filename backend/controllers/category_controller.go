@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// GetCategories handles GET requests to browse categories, optionally only the roots
+func GetCategories(c echo.Context) error {
+	var categories []entities.CategoryStruct
+	var err error
+	if c.QueryParam("root") == "true" {
+		categories, err = models.GetRootCategories()
+	} else {
+		categories, err = models.GetCategories()
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting categories"})
+	}
+
+	return c.JSON(http.StatusOK, categories)
+}
+
+func GetCategory(c echo.Context) error {
+	id := c.Param("id")
+	category, err := models.GetCategoryById(id)
+	if err != nil {
+		if err.Error() == fmt.Sprintf("no category found with id: %s", id) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Category %s not found", id)})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting category"})
+	}
+
+	return c.JSON(http.StatusOK, category)
+}
+
+func GetCategoryBySlug(c echo.Context) error {
+	slug := c.Param("slug")
+	category, err := models.GetCategoryBySlug(slug)
+	if err != nil {
+		if err.Error() == fmt.Sprintf("no category found with slug: %s", slug) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Category %s not found", slug)})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting category"})
+	}
+
+	return c.JSON(http.StatusOK, category)
+}
+
+func GetCategoryChildren(c echo.Context) error {
+	categories, err := models.GetCategoryChildren(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting sub-categories"})
+	}
+
+	return c.JSON(http.StatusOK, categories)
+}
+
+func GetCategoryProducts(c echo.Context) error {
+	products, err := models.GetProductsByCategory(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting category products"})
+	}
+
+	products, err = convertProductPrices(c, products)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, products)
+}
+
+// ImportCategories maps an OpenFoodFacts category string onto the categories collection
+func ImportCategories(c echo.Context) error {
+	var req entities.CategoryImportStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	categoryIds, err := models.ImportOpenFoodFactsCategories(req.Categories)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, map[string][]string{"category_ids": categoryIds})
+}
+
+// SyncCategories links the products that have no category IDs yet
+func SyncCategories(c echo.Context) error {
+	updated, err := models.SyncProductCategories()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"products_updated": updated})
+}
+
+// MoveCategory puts a category, with its sub-categories, under another one or at the root
+func MoveCategory(c echo.Context) error {
+	var req entities.CategoryMoveStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+
+	category, err := models.MoveCategory(c.Param("id"), req.ParentId)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "no "):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "invalid "):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, category)
+}
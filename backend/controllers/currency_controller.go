@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+	"trinity/backend/pricing"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// GetCurrencies lists the base currency, the currencies customers can pay in and the exchange rates
+func GetCurrencies(c echo.Context) error {
+	rates, err := models.GetExchangeRates()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting exchange rates"})
+	}
+
+	return c.JSON(http.StatusOK, entities.CurrenciesStruct{
+		Base:  entities.DefaultCurrency,
+		Store: pricing.StoreCurrencies(),
+		Rates: rates,
+	})
+}
+
+func SetExchangeRate(c echo.Context) error {
+	var input entities.ExchangeRateInputStruct
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid exchange rate on bind"})
+	}
+
+	if err := c.Validate(input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid exchange rate data: %v", err)})
+	}
+
+	rate, err := models.SetExchangeRate(strings.ToUpper(c.Param("code")), input.Rate.String(), "admin")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, rate)
+}
+
+// ReloadExchangeRates imports EXCHANGE_RATES_FILE again, e.g. after it was refreshed
+func ReloadExchangeRates(c echo.Context) error {
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "EXCHANGE_RATES_FILE is not configured"})
+	}
+
+	loaded, err := models.LoadExchangeRatesFile(path)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"rates_loaded": loaded})
+}
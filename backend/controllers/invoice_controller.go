@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
 
@@ -72,13 +74,139 @@ func CreateInvoice(c echo.Context) error {
 // 	return c.JSON(http.StatusAccepted, "Les invoices de Baptiste sont mis a jour")
 // }
 
+// ArchiveInvoice hides an invoice that was never issued
 func ArchiveInvoice(c echo.Context) error {
 	invoice_id := c.Param("id")
 
 	err := models.ArchiveInvoiceById(invoice_id)
 	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "no invoice found"):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		case strings.HasSuffix(err.Error(), "cancel or refund it instead"):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error archiving invoice"})
 	}
 
 	return c.JSON(http.StatusNoContent, nil)
 }
+
+// RefundInvoice records the refund of a paid order, taking back the loyalty points it earned
+// and issuing its credit note
+func RefundInvoice(c echo.Context) error {
+	invoice, err := models.RefundInvoice(c.Param("id"))
+	return creditedInvoiceResponse(c, invoice, err)
+}
+
+// CancelInvoice cancels a paid order that will not be delivered, like a refund
+func CancelInvoice(c echo.Context) error {
+	invoice, err := models.CancelPaidInvoice(c.Param("id"))
+	return creditedInvoiceResponse(c, invoice, err)
+}
+
+// MarkOrderReady tells the customer that a paid order can be picked up
+func MarkOrderReady(c echo.Context) error {
+	invoice, err := models.MarkOrderReady(c.Param("id"))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no invoice found") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func creditedInvoiceResponse(c echo.Context, invoice entities.InvoiceStruct, err error) error {
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no invoice found") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if invoice.Id != "" {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+// GetCreditNotes lists the credit notes of every customer
+func GetCreditNotes(c echo.Context) error {
+	notes, err := models.GetCreditNotes("")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, notes)
+}
+
+// GetSelfCreditNotes lists the credit notes of the logged in user
+func GetSelfCreditNotes(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	notes, err := models.GetCreditNotes(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, notes)
+}
+
+// CancelSelfInvoice cancels a pending order of the logged in user, giving back its loyalty points
+func CancelSelfInvoice(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	invoice, err := models.GetUserInvoice(user.Id, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	if err := models.CancelPendingInvoice(user.Id, invoice); err != nil {
+		if strings.HasPrefix(err.Error(), "no pending invoice found") {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "only pending orders can be cancelled, order is " + invoice.Order.Status})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Order cancelled successfully"})
+}
+
+// GetSelfInvoicePDF downloads the PDF of an invoice of the logged in user
+func GetSelfInvoicePDF(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	invoice, blob, err := models.GetInvoicePDF(user.Id, c.Param("id"))
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "no invoice found"):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "only paid orders"):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="invoice-%s.pdf"`, invoice.Number))
+	return c.Blob(http.StatusOK, blob.ContentType, blob.Data)
+}
+
+// GetSelfCreditNotePDF downloads the PDF of a credit note of the logged in user
+func GetSelfCreditNotePDF(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	note, blob, err := models.GetCreditNotePDF(user.Id, c.Param("id"))
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "no credit note found"):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		case strings.HasSuffix(err.Error(), "is not issued yet"):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="credit-note-%s.pdf"`, note.Number))
+	return c.Blob(http.StatusOK, blob.ContentType, blob.Data)
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// GetSelfLoyalty shows the loyalty balance of the user and the history of their points
+func GetSelfLoyalty(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	account, err := models.GetLoyaltyAccount(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting loyalty points"})
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+func GetLoyaltyRules(c echo.Context) error {
+	rules, err := models.GetLoyaltyRules()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting loyalty rules"})
+	}
+
+	return c.JSON(http.StatusOK, rules)
+}
+
+func AddLoyaltyRule(c echo.Context) error {
+	var rule entities.LoyaltyRuleStruct
+	if err := c.Bind(&rule); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid loyalty rule on bind"})
+	}
+
+	if err := c.Validate(rule); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid loyalty rule data: %v", err)})
+	}
+
+	created, err := models.CreateLoyaltyRule(rule)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, created)
+}
+
+func UpdateLoyaltyRule(c echo.Context) error {
+	var rule entities.LoyaltyRuleStruct
+	if err := c.Bind(&rule); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid loyalty rule on bind"})
+	}
+
+	if err := c.Validate(rule); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid loyalty rule data: %v", err)})
+	}
+
+	updated, err := models.UpdateLoyaltyRule(c.Param("id"), rule)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no loyalty rule found") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+func DeleteLoyaltyRule(c echo.Context) error {
+	if err := models.DeleteLoyaltyRule(c.Param("id")); err != nil {
+		if strings.HasPrefix(err.Error(), "no loyalty rule found") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Loyalty rule deleted"})
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+const maxMailLimit = 200
+
+// GetMails lists the last emails of the outbox, ?status= filters on pending, sent or failed
+func GetMails(c echo.Context) error {
+	limit := 50
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxMailLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxMailLimit)})
+		}
+		limit = parsed
+	}
+
+	mails, err := models.GetMails(c.QueryParam("status"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, mails)
+}
+
+// RetryMail sends a failed email again
+func RetryMail(c echo.Context) error {
+	if err := models.RetryMail(c.Param("id")); err != nil {
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Email queued again"})
+}
@@ -2,13 +2,10 @@ package controllers
 
 import (
 	"context"
-	"fmt"
-	"math"
+	"log"
 	"net/http"
 	"os"
-	"sort"
-	"strconv"
-	"time"
+	"strings"
 
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
@@ -17,14 +14,12 @@ import (
 	paypal "github.com/plutov/paypal/v4"
 )
 
-func CreatePayment(c echo.Context) error {
-	type CartItem struct {
-		ProductID string `json:"productId" validate:"required"`
-		Quantity  int    `json:"quantity" validate:"required,gt=0"`
-	}
-	var req struct {
-		Cart []CartItem `json:"cart" validate:"required,dive"`
-	}
+// QuotePayment prices a cart with the promotions and loyalty points that apply, without
+// creating the order
+func QuotePayment(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req entities.CheckoutStruct
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 	}
@@ -32,44 +27,49 @@ func CreatePayment(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
 	}
 
-	//cancel the last invoice before creating a new one
-	_, err := models.CancelLastInvoice(c)
+	invoice, err := models.PriceOrder(user.Id, req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "failed to cancel last invoice: " + err.Error(),
-		})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	var totalPrice float64
-	var orderProducts []entities.OrderProductStruct
-	for _, item := range req.Cart {
-		product, err := models.GetProductById(item.ProductID)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"error": "product not found: " + item.ProductID,
-			})
-		}
-		price := product.PriceVat * float64(item.Quantity)
-		totalPrice += price
-		orderProducts = append(orderProducts, entities.OrderProductStruct{
-			ProductId: product.Id,
-			Quantity:  item.Quantity,
-			Price:     price,
-		})
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func CreatePayment(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req entities.CheckoutStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
 	}
 
-	order := entities.OrderStruct{
-		Date:          time.Now(),
-		Status:        "pending",
-		PaymentMethod: "PAYPAL",
-		Products:      orderProducts,
+	return createOrder(c, user, req, false)
+}
+
+// createOrder prices a checkout and saves it as the pending order of the user, which PayPal
+// then captures. An order made from the cart empties it once paid.
+func createOrder(c echo.Context, user entities.UserBasicStruct, req entities.CheckoutStruct, fromCart bool) error {
+	// Only the order being replaced is cancelled, the other pending ones expire by themselves.
+	// It is kept until the new order is saved, so that a failed checkout does not lose it.
+	var replaced *entities.InvoiceStruct
+	if req.ReplaceOrder != "" {
+		invoice, err := models.GetUserInvoice(user.Id, req.ReplaceOrder)
+		if err != nil && !strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to read replaced order: " + err.Error(),
+			})
+		}
+		if err == nil && invoice.Order.Status == "pending" {
+			replaced = &invoice
+		}
 	}
 
-	invoice := entities.InvoiceStruct{
-		Date:       time.Now().Format(time.RFC3339),
-		TotalPrice: totalPrice,
-		Order:      order,
-		Archived:   false,
+	invoice, err := models.PriceOrder(user.Id, req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
 	invoiceInserted, err := models.CreateInvoiceSelf(c, invoice)
@@ -79,12 +79,56 @@ func CreatePayment(c echo.Context) error {
 		})
 	}
 
+	err = reserveOrder(user.Id, invoiceInserted)
+	if err != nil && replaced != nil {
+		// The new order may need the points or promotion uses the replaced one still holds
+		if cancelErr := models.CancelPendingInvoice(user.Id, *replaced); cancelErr != nil && !strings.HasPrefix(cancelErr.Error(), "no ") {
+			log.Printf("Failed to cancel replaced invoice %s: %v", replaced.Id, cancelErr)
+		} else {
+			replaced = nil
+			err = reserveOrder(user.Id, invoiceInserted)
+		}
+	}
+	if err != nil {
+		if cancelErr := models.CancelPendingInvoice(user.Id, invoiceInserted); cancelErr != nil {
+			log.Printf("Failed to cancel invoice %s: %v", invoiceInserted.Id, cancelErr)
+		}
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	if replaced != nil {
+		// Paid or expired meanwhile when it is no longer pending
+		if err := models.CancelPendingInvoice(user.Id, *replaced); err != nil && !strings.HasPrefix(err.Error(), "no ") {
+			log.Printf("Failed to cancel replaced invoice %s: %v", replaced.Id, err)
+		}
+	}
+
+	if fromCart {
+		if err := models.SetCartInvoice(user.Id, invoiceInserted.Id); err != nil {
+			log.Printf("Failed to link the cart to invoice %s: %v", invoiceInserted.Id, err)
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{
 		"message":   "Order created successfully",
 		"invoiceId": invoiceInserted.Id,
+		"total":     invoiceInserted.TotalPrice.Decimal(),
+		"discount":  invoiceInserted.TotalDiscount.Decimal(),
+		"currency":  invoiceInserted.Currency,
+		"expiresAt": invoiceInserted.ExpiresAt,
 	})
 }
 
+// reserveOrder spends the loyalty points and reserves the promotions of a new order, they come
+// back if it is cancelled. It fails if the points or a promotion use are no longer available,
+// and can be retried since what was already reserved is not taken twice.
+func reserveOrder(userId string, invoice entities.InvoiceStruct) error {
+	if err := models.RedeemLoyaltyPoints(userId, invoice); err != nil {
+		return err
+	}
+	return models.ReservePromotionRedemptions(userId, invoice)
+}
+
 func CapturePayment(c echo.Context) error {
 	user, ok := c.Get("user").(entities.UserBasicStruct)
 	if !ok {
@@ -93,6 +137,7 @@ func CapturePayment(c echo.Context) error {
 
 	var req struct {
 		PaypalOrderID string `json:"paypalOrderId" validate:"required"`
+		InvoiceId     string `json:"invoiceId"` // The most recent pending order by default
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
@@ -121,10 +166,10 @@ func CapturePayment(c echo.Context) error {
 		})
 	}
 
-	// Extract total amount from PayPal order
-	var paypalAmount float64
+	// Extract total amount from PayPal order, parsed exactly from its decimal string
+	var paypalAmount entities.Money
 	if len(order.PurchaseUnits) > 0 && order.PurchaseUnits[0].Amount != nil {
-		paypalAmount, err = strconv.ParseFloat(order.PurchaseUnits[0].Amount.Value, 64)
+		paypalAmount, err = entities.ParseMoney(order.PurchaseUnits[0].Amount.Value, order.PurchaseUnits[0].Amount.Currency)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"error": "failed to parse PayPal order amount: " + err.Error(),
@@ -136,39 +181,67 @@ func CapturePayment(c echo.Context) error {
 		})
 	}
 
-	userDetailed, err := models.GetUserDetails(user.Id)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+	var pendingInvoice entities.InvoiceStruct
+	if req.InvoiceId != "" {
+		pendingInvoice, err = models.GetUserInvoice(user.Id, req.InvoiceId)
+	} else {
+		pendingInvoice, err = models.GetLastPendingInvoice(user.Id)
 	}
-
-	invoices := userDetailed.Invoices
-
-	if len(invoices) == 0 {
+	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "pending invoice not found"})
 	}
-
-	sort.Slice(invoices, func(i, j int) bool {
-		return invoices[i].Date > invoices[j].Date
-	})
-
-	pendingInvoice := invoices[0]
+	if pendingInvoice.Order.Status != "pending" && pendingInvoice.Order.Status != "expired" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "order is " + pendingInvoice.Order.Status})
+	}
 
 	// Verify that the amount matches
-	// Allow for a small difference (0.01) to account for floating point precision issues
-	if math.Abs(pendingInvoice.TotalPrice-paypalAmount) > 0.01 {
+	if !pendingInvoice.TotalPrice.Equal(paypalAmount) {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":    "payment amount does not match order total",
-			"expected": fmt.Sprintf("%.2f", pendingInvoice.TotalPrice),
-			"received": fmt.Sprintf("%.2f", paypalAmount),
+			"expected": pendingInvoice.TotalPrice.String(),
+			"received": paypalAmount.String(),
 		})
 	}
 
-	pendingInvoice.Order.Status = "paid"
+	// The payment may have been approved right before the order expired
+	if pendingInvoice.Order.Status == "expired" {
+		if err := models.ReclaimExpiredInvoice(user.Id, pendingInvoice); err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "order expired: " + err.Error()})
+		}
+	}
 
-	invoiceValid, err := models.UpdateInvoiceSelf(c, pendingInvoice)
+	pendingInvoice.LoyaltyPointsEarned, err = models.ComputeLoyaltyPoints(pendingInvoice)
+	if err != nil {
+		log.Printf("Failed to compute loyalty points of invoice %s: %v", pendingInvoice.Id, err)
+	}
 
+	invoiceValid, err := models.MarkInvoicePaid(user.Id, pendingInvoice)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "order not found or already updated"})
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "order expired, cancelled or already paid"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	// The order is paid whatever happens, its promotions were reserved when it was created so
+	// a failure only leaves the promotion stats or the loyalty balance behind
+	if err := models.RecordPromotionRedemptions(user.Id, invoiceValid); err != nil {
+		log.Printf("Failed to record promotion redemptions of invoice %s: %v", invoiceValid.Id, err)
+	}
+	if err := models.AwardLoyaltyPoints(user.Id, invoiceValid); err != nil {
+		log.Printf("Failed to award loyalty points of invoice %s: %v", invoiceValid.Id, err)
+	}
+	if err := models.ClearPaidCart(user.Id, invoiceValid.Id); err != nil {
+		log.Printf("Failed to empty the cart of invoice %s: %v", invoiceValid.Id, err)
+	}
+	// The PDF can still be issued when it is first downloaded
+	if issued, err := models.IssueInvoice(user.Id, invoiceValid.Id); err != nil {
+		log.Printf("Failed to issue invoice %s: %v", invoiceValid.Id, err)
+	} else {
+		invoiceValid = issued
+	}
+	if err := models.QueueOrderMails(user.Id, invoiceValid); err != nil {
+		log.Printf("Failed to queue the emails of invoice %s: %v", invoiceValid.Id, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -178,5 +251,5 @@ func CapturePayment(c echo.Context) error {
 }
 
 func ReturnPayment(c echo.Context) error {
-	return c.Redirect(http.StatusFound, "com.baptistegrimaldi.trinity://paypalpay")
+	return c.Redirect(http.StatusFound, models.AppLinkScheme+"paypalpay")
 }
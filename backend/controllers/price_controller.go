@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// GetProductPrices lists the price history of a product, including scheduled changes.
+// With ?at=<RFC3339 date> only the price in effect at that date is returned.
+func GetProductPrices(c echo.Context) error {
+	id := c.Param("id")
+
+	if at := c.QueryParam("at"); at != "" {
+		date, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid at date, expected RFC3339"})
+		}
+
+		price, err := models.GetProductPriceAt(id, date)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, price)
+	}
+
+	history, err := models.GetProductPriceHistory(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting price history"})
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+// ChangeProductPrice applies a new price now, or schedules it when effective_at is in the future
+func ChangeProductPrice(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+	id := c.Param("id")
+
+	var change entities.PriceChangeStruct
+	if err := c.Bind(&change); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid price on bind"})
+	}
+
+	if err := c.Validate(change); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid price data: %v", err)})
+	}
+
+	entry, err := models.ChangeProductPrice(id, change, user.Id)
+	if err != nil {
+		if err.Error() == fmt.Sprintf("no product found with id: %s", id) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Product %s not found", id)})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, entry)
+}
+
+func CancelProductPriceChange(c echo.Context) error {
+	err := models.CancelScheduledPriceChange(c.Param("id"), c.Param("changeId"))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no scheduled price change found") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Price change cancelled"})
+}
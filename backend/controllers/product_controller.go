@@ -1,8 +1,12 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"trinity/backend/catalog"
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
 
@@ -16,6 +20,11 @@ func GetProducts(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting products"})
 	}
 
+	products, err = convertProductPrices(c, products)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	return c.JSON(http.StatusOK, products)
 }
 
@@ -28,7 +37,12 @@ func GetProductsByBarcode(c echo.Context) error {
 		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting product"})
 	}
-	return c.JSON(http.StatusOK, product)
+
+	converted, err := convertProductPrices(c, []entities.ProductStruct{product})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, converted[0])
 }
 
 func UpdateProduct(c echo.Context) error {
@@ -67,14 +81,82 @@ func ArchiveProduct(c echo.Context) error {
 	return c.JSON(http.StatusNoContent, nil)
 }
 
+// SyncProduct re-fetches a product's details from the catalog on demand
+func SyncProduct(c echo.Context) error {
+	product_id := c.Param("id")
+	if _, err := models.GetProductById(product_id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Product %s not found", product_id)})
+	}
+
+	product, err := models.SyncProductCatalog(product_id)
+	if err != nil {
+		if errors.Is(err, catalog.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, product)
+}
+
 func GetProductsBySearch(c echo.Context) error {
 	name := c.Param("name")
-	product, err := models.GetProductBySearchProducts(name)
+	if name == "" {
+		name = c.QueryParam("q")
+	}
+
+	filter, err := parseProductFilter(c)
 	if err != nil {
-		if err.Error() == fmt.Sprintf("no product found: %s", name) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	product, err := models.GetProductBySearchProducts(name, filter)
+	if err != nil {
+		if err.Error() == fmt.Sprintf("no products found matching: %s", name) {
 			return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Product %s not found", name)})
 		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting product"})
 	}
+
+	product, err = convertProductPrices(c, product)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 	return c.JSON(http.StatusOK, product)
 }
+
+// convertProductPrices returns prices in the store currency asked with ?currency=USD
+func convertProductPrices(c echo.Context, products []entities.ProductStruct) ([]entities.ProductStruct, error) {
+	return models.ConvertProductPrices(products, strings.ToUpper(c.QueryParam("currency")))
+}
+
+// parseProductFilter reads the nutrition search filters from the query string, e.g.
+// ?allergen_free=gluten,milk&nutriscore=a,b&nova_max=2&label=organic,vegan&category=<id>
+func parseProductFilter(c echo.Context) (entities.ProductFilterStruct, error) {
+	splitList := func(value string) []string {
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+
+	filter := entities.ProductFilterStruct{
+		AllergenFree: splitList(c.QueryParam("allergen_free")),
+		NutriScores:  splitList(c.QueryParam("nutriscore")),
+		Labels:       splitList(c.QueryParam("label")),
+		CategoryId:   c.QueryParam("category"),
+	}
+
+	if novaMax := c.QueryParam("nova_max"); novaMax != "" {
+		value, err := strconv.Atoi(novaMax)
+		if err != nil || value < 1 || value > 4 {
+			return entities.ProductFilterStruct{}, fmt.Errorf("nova_max must be between 1 and 4")
+		}
+		filter.MaxNova = value
+	}
+
+	return filter, nil
+}
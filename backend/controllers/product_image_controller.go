@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"trinity/backend/items/models"
+	"trinity/backend/storage"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+const maxImageUploadSize = 10 << 20 // 10 MB
+
+// UploadProductImage handles multipart uploads of a product photo ("image" field)
+func UploadProductImage(c echo.Context) error {
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing image file"})
+	}
+	if fileHeader.Size > maxImageUploadSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "image too large"})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read image"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read image"})
+	}
+
+	images, err := models.UploadProductImage(c.Param("id"), data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, images)
+}
+
+// MirrorProductImage copies the OpenFoodFacts image of a product into our storage
+func MirrorProductImage(c echo.Context) error {
+	images, err := models.MirrorProductImage(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, images)
+}
+
+func DeleteProductImage(c echo.Context) error {
+	images, err := models.DeleteProductImage(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, images)
+}
+
+// GetProductImage serves an uploaded product image ("s" or "xl") with cache headers.
+// Products without an uploaded image are redirected to their OpenFoodFacts image.
+func GetProductImage(c echo.Context) error {
+	size := c.Param("size")
+	if size != "s" && size != "xl" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown image size"})
+	}
+
+	blob, version, fallback, err := models.GetProductImage(c.Param("id"), size)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) && fallback != "" {
+			c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+			return c.Redirect(http.StatusFound, fallback)
+		}
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "image not found"})
+	}
+
+	etag := fmt.Sprintf(`"%s-%s"`, version, size)
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	if c.QueryParam("v") == version {
+		// Versioned URLs never change content
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		header.Set("Cache-Control", "public, max-age=86400")
+	}
+	if !blob.ModTime.IsZero() {
+		header.Set(echo.HeaderLastModified, blob.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.Blob(http.StatusOK, blob.ContentType, blob.Data)
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+const maxImportFileSize = 10 << 20 // 10 MB
+
+// ImportProducts handles multipart uploads of a CSV or JSON product file.
+// The file is processed in the background, the returned job reports per-row results.
+func ImportProducts(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing import file"})
+	}
+	if fileHeader.Size > maxImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "import file too large"})
+	}
+
+	format := strings.ToLower(c.QueryParam("format"))
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fileHeader.Filename)), ".")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read import file"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read import file"})
+	}
+
+	rows, failures, err := models.ParseProductImport(format, data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	job, err := models.CreateImportJob(entities.ImportJobStruct{
+		Format:    format,
+		DryRun:    c.QueryParam("dry_run") == "true",
+		Total:     len(rows) + len(failures),
+		CreatedBy: user.Id,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create import job"})
+	}
+
+	go models.RunProductImport(job, rows, failures)
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func GetImportJob(c echo.Context) error {
+	job, err := models.GetImportJob(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "import job not found"})
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// ExportProducts downloads the catalog in the same CSV or JSON format accepted by ImportProducts
+func ExportProducts(c echo.Context) error {
+	format := strings.ToLower(c.QueryParam("format"))
+	if format == "" {
+		format = "csv"
+	}
+
+	rows, err := models.ExportProducts()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error exporting products"})
+	}
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("2006-01-02"), format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	switch format {
+	case "csv":
+		var buf bytes.Buffer
+		if err := models.WriteProductExportCSV(&buf, rows); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error exporting products"})
+		}
+		return c.Blob(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	case "json":
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error exporting products"})
+		}
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, data)
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unsupported export format: %s", format)})
+	}
+}
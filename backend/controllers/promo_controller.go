@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
 
@@ -30,3 +32,140 @@ func GetDeals(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, promotions)
 }
+
+func GetPromotions(c echo.Context) error {
+	promotions, err := models.GetPromotions(c.QueryParam("status"))
+	if err != nil {
+		log.Printf("Failed to get promotions: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting promotions"})
+	}
+
+	return c.JSON(http.StatusOK, promotions)
+}
+
+func GetPromotion(c echo.Context) error {
+	promotion, err := models.GetPromotionById(c.Param("id"))
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func AddPromotion(c echo.Context) error {
+	var input entities.PromotionInputStruct
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid promotion on bind"})
+	}
+
+	if err := c.Validate(input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid promotion data: %v", err)})
+	}
+
+	promotion, err := models.CreatePromotion(input)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, promotion)
+}
+
+func UpdatePromotion(c echo.Context) error {
+	var input entities.PromotionInputStruct
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid promotion on bind"})
+	}
+
+	if err := c.Validate(input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid promotion data: %v", err)})
+	}
+
+	promotion, err := models.UpdatePromotion(c.Param("id"), input)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func PausePromotion(c echo.Context) error {
+	return changePromotionStatus(c, models.PausePromotion)
+}
+
+func ResumePromotion(c echo.Context) error {
+	return changePromotionStatus(c, models.ResumePromotion)
+}
+
+func ExpirePromotion(c echo.Context) error {
+	return changePromotionStatus(c, models.ExpirePromotion)
+}
+
+func changePromotionStatus(c echo.Context, change func(id string) (entities.PromotStruct, error)) error {
+	promotion, err := change(c.Param("id"))
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func DeletePromotion(c echo.Context) error {
+	if err := models.DeletePromotion(c.Param("id")); err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Promotion deleted"})
+}
+
+// GetPromotionsStats shows the redemptions, discounts and revenue of every promotion used
+func GetPromotionsStats(c echo.Context) error {
+	stats, err := models.GetPromotionsStats("")
+	if err != nil {
+		log.Printf("Failed to get promotion stats: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting promotion stats"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"currency":   entities.DefaultCurrency,
+		"promotions": stats,
+	})
+}
+
+func GetPromotionStats(c echo.Context) error {
+	promotion, err := models.GetPromotionById(c.Param("id"))
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	stats, err := models.GetPromotionsStats(promotion.Id)
+	if err != nil {
+		log.Printf("Failed to get the stats of promotion %s: %v", promotion.Id, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting promotion stats"})
+	}
+
+	result := entities.PromotionStatsStruct{
+		PromotionId:   promotion.Id,
+		Name:          promotion.Name,
+		Code:          promotion.Code,
+		TotalDiscount: entities.NewMoney(0, entities.DefaultCurrency),
+		Revenue:       entities.NewMoney(0, entities.DefaultCurrency),
+	}
+	if len(stats) > 0 {
+		result = stats[0]
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"currency":  entities.DefaultCurrency,
+		"promotion": result,
+	})
+}
+
+func promotionError(c echo.Context, err error) error {
+	switch {
+	case strings.HasPrefix(err.Error(), "no promotion found"):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case strings.HasSuffix(err.Error(), "already exists"):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
 
@@ -12,13 +14,10 @@ type TokenRequest struct {
 	Token string `json:"token" validate:"required"`
 }
 
-type NotificationRequest struct {
-	Title string `json:"title" validate:"required"`
-	Body  string `json:"body" validate:"required"`
-}
-
+// NotifyHandler queues a notification for the users of its target, every user without one.
+// It is sent in the background, the dispatch returned follows the delivery.
 func NotifyHandler(c echo.Context) error {
-	var req NotificationRequest
+	var req entities.NotificationRequestStruct
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 	}
@@ -27,14 +26,31 @@ func NotifyHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
 	}
 
-	err := models.SendNotification(req.Title, req.Body)
+	dispatch, err := models.EnqueueNotification(req)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid ") {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusAccepted, dispatch)
+}
+
+// GetNotificationDispatch reports how far the delivery of a notification went
+func GetNotificationDispatch(c echo.Context) error {
+	dispatch, err := models.GetNotificationDispatch(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, "Notification sent")
+	return c.JSON(http.StatusOK, dispatch)
 }
 
+// RegisterToken records a device of the logged in user, the app calls it on every start
 func RegisterToken(c echo.Context) error {
 	user, ok := c.Get("user").(entities.UserBasicStruct)
 	if !ok {
@@ -42,7 +58,7 @@ func RegisterToken(c echo.Context) error {
 	}
 	userId := user.Id
 
-	var req TokenRequest
+	var req entities.DeviceStruct
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 	}
@@ -51,13 +67,121 @@ func RegisterToken(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
 	}
 
-	err := models.UpdateDeviceToken(userId, req.Token)
+	device, err := models.RegisterDevice(userId, req)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to register token"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"token":   req.Token,
+	return c.JSON(http.StatusOK, map[string]any{
+		"token":   device.Token,
+		"device":  device,
 		"message": "token registered successfully",
 	})
 }
+
+// UnregisterToken removes a device of the logged in user, the app calls it on logout
+func UnregisterToken(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req TokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request malformed"})
+	}
+
+	if err := models.UnregisterDevice(user.Id, req.Token); err != nil {
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "no device registered with this token"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to unregister token"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "token unregistered successfully"})
+}
+
+// GetSelfDevices lists the devices the logged in user receives notifications on
+func GetSelfDevices(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	devices, err := models.GetUserDevices(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, devices)
+}
+
+func GetSelfNotificationPreferences(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	preferences, err := models.GetNotificationPreferences(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// UpdateSelfNotificationPreferences opts the logged in user in or out of each type of notification
+func UpdateSelfNotificationPreferences(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	var req entities.NotificationPreferencesStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+	}
+
+	preferences, err := models.SetNotificationPreferences(user.Id, req)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// GetSelfNotifications lists the last notifications sent to the logged in user, ?type= keeps
+// the marketing or the transactional ones
+func GetSelfNotifications(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	limit := 50
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 200 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 200"})
+		}
+		limit = parsed
+	}
+
+	notifications, err := models.GetNotificationHistory(user.Id, c.QueryParam("type"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// MarkSelfNotificationOpened is called by the app when the logged in user opens a
+// notification, with the notificationId it was sent
+func MarkSelfNotificationOpened(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	notification, err := models.MarkNotificationOpened(user.Id, c.Param("id"))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "invalid ") {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, notification)
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	defaultRecommendationLimit = 10
+	maxRecommendationLimit     = 20
+)
+
+// parseRecommendationLimit reads ?limit=, the number of products to return
+func parseRecommendationLimit(c echo.Context) (int, error) {
+	value := c.QueryParam("limit")
+	if value == "" {
+		return defaultRecommendationLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxRecommendationLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxRecommendationLimit)
+	}
+	return limit, nil
+}
+
+// GetRelatedProducts returns the products customers bought along with a product
+func GetRelatedProducts(c echo.Context) error {
+	limit, err := parseRecommendationLimit(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	products, err := models.GetRelatedProducts(c.Param("id"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting related products"})
+	}
+
+	products, err = convertProductPrices(c, products)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, products)
+}
+
+// GetSelfRecommendations returns the products recommended to the logged in user
+func GetSelfRecommendations(c echo.Context) error {
+	user := c.Get("user").(entities.UserBasicStruct)
+
+	limit, err := parseRecommendationLimit(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	products, err := models.GetUserRecommendedProducts(user.Id, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting recommendations"})
+	}
+
+	products, err = convertProductPrices(c, products)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, products)
+}
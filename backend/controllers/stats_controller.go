@@ -16,6 +16,7 @@ func GetEarnings(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"earnings": earnings,
+		"currency": earnings.Currency,
 	})
 }
 
@@ -34,8 +35,9 @@ func GetAverageSpending(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting average spending"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]float64{
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"average_spending": result,
+		"currency":         result.Currency,
 	})
 }
 
@@ -78,8 +80,9 @@ func GetAverageProductCost(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting average product cost"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]float64{
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"average_product_cost": result,
+		"currency":             result.Currency,
 	})
 }
 
@@ -3,6 +3,7 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"trinity/backend/auth/middlewares"
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
@@ -139,6 +140,24 @@ func UpdateOtherUser(c echo.Context) error {
 	return c.JSON(http.StatusAccepted, updated_user)
 }
 
+// SetUserSegments replaces the segments of a user, which promotions can be restricted to
+func SetUserSegments(c echo.Context) error {
+	var req entities.UserSegmentsStruct
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid segments on bind"})
+	}
+
+	segments, err := models.SetUserSegments(c.Param("id"), req.Segments)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no user found") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, entities.UserSegmentsStruct{Segments: segments})
+}
+
 func ArchiveUser(c echo.Context) error {
 	err := models.ArchiveUserById(c.Param("id"))
 	if err != nil {
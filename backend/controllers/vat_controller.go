@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"trinity/backend/items/entities"
+	"trinity/backend/items/models"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+func GetVatRates(c echo.Context) error {
+	rates, err := models.GetVatRates()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error getting VAT rates"})
+	}
+
+	return c.JSON(http.StatusOK, rates)
+}
+
+func AddVatRate(c echo.Context) error {
+	var rate entities.VatRateStruct
+	if err := c.Bind(&rate); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid VAT rate on bind"})
+	}
+
+	if err := c.Validate(rate); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid VAT rate data: %v", err)})
+	}
+
+	created, err := models.CreateVatRate(rate)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, created)
+}
+
+func UpdateVatRate(c echo.Context) error {
+	id := c.Param("id")
+
+	var rate entities.VatRateStruct
+	if err := c.Bind(&rate); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid VAT rate on bind"})
+	}
+
+	if err := c.Validate(rate); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid VAT rate data: %v", err)})
+	}
+
+	updated, err := models.UpdateVatRate(id, rate)
+	if err != nil {
+		if err.Error() == fmt.Sprintf("no VAT rate found with id: %s", id) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("VAT rate %s not found", id)})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+// SetProductVatRate assigns a VAT rate to a product, overriding the one of its category
+func SetProductVatRate(c echo.Context) error {
+	return assignVatRate(c, models.SetProductVatRate)
+}
+
+// SetCategoryVatRate assigns a VAT rate to a category and, through it, to its sub-categories
+func SetCategoryVatRate(c echo.Context) error {
+	return assignVatRate(c, models.SetCategoryVatRate)
+}
+
+func assignVatRate(c echo.Context, assign func(id string, vatRateId string) error) error {
+	var assignment entities.VatAssignmentStruct
+	if err := c.Bind(&assignment); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid VAT rate on bind"})
+	}
+
+	if err := assign(c.Param("id"), assignment.VatRateId); err != nil {
+		if strings.HasPrefix(err.Error(), "no ") {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, assignment)
+}
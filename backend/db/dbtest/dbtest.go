@@ -0,0 +1,58 @@
+// Package dbtest runs tests against the MongoDB server of the DB_* environment variables, in
+// a database of their own. Tests are skipped when no server is configured or reachable.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"trinity/backend/db"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Setup points db.GetDatabase at an empty database, dropped once the test is done
+func Setup(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	host, username, password := os.Getenv("DB_HOST"), os.Getenv("DB_USERNAME"), os.Getenv("DB_PASSWORD")
+	if host == "" || username == "" || password == "" {
+		t.Skip("DB_HOST, DB_USERNAME and DB_PASSWORD are not set, skipping database test")
+	}
+
+	// db.GetDatabase retries for a while, a server that is not there is found out quickly here
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	uri := fmt.Sprintf("mongodb://%s:%s@%s/?authSource=admin", username, password, host)
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err == nil {
+		err = client.Ping(ctx, nil)
+	}
+	if err != nil {
+		t.Skipf("MongoDB is not reachable at %s, skipping database test: %v", host, err)
+	}
+
+	// Database names cannot hold dots, slashes or spaces, nor be longer than 63 bytes
+	name := strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, fmt.Sprintf("test_%s_%d", t.Name(), time.Now().UnixNano()))
+	if len(name) > 60 {
+		name = name[len(name)-60:]
+	}
+	t.Setenv("DB_NAME", name)
+
+	t.Cleanup(func() {
+		if err := client.Database(name).Drop(context.Background()); err != nil {
+			t.Logf("Failed to drop test database %s: %v", name, err)
+		}
+		client.Disconnect(context.Background())
+	})
+	return db.GetDatabase()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
@@ -16,25 +17,67 @@ import (
 
 func SeedAll(db *mongo.Database) error {
 
+	// Convert the amounts stored before the Money type
+	if err := MigrateMoney(db); err != nil {
+		return err
+	}
+
 	// Initialize collections
 	if err := InitializeCities(db); err != nil {
 		return err
 	}
+	// Products need a VAT rate to derive their price without VAT
+	if err := InitializeVatRates(db); err != nil {
+		return err
+	}
 	if err := InitializeProducts(db); err != nil {
 		return err
 	}
+	if err := InitializeCategories(db); err != nil {
+		return err
+	}
+	if err := InitializeExchangeRates(db); err != nil {
+		return err
+	}
+	if err := InitializePriceHistory(db); err != nil {
+		return err
+	}
 	if err := InitialiseSuppliers(db); err != nil {
 		return err
 	}
 	if err := InitializeRoles(db); err != nil {
 		return err
 	}
+	if err := GrantNewPermissions(db); err != nil {
+		return err
+	}
 	if err := InitializeUsers(db); err != nil {
 		return err
 	}
 	if err := InitializePromotions(db); err != nil {
 		return err
 	}
+	if err := InitializeLoyalty(db); err != nil {
+		return err
+	}
+	if err := InitializeIdempotencyKeys(db); err != nil {
+		return err
+	}
+	if err := InitializeCreditNotes(db); err != nil {
+		return err
+	}
+	if err := InitializeMailOutbox(db); err != nil {
+		return err
+	}
+	if err := InitializeNotifications(db); err != nil {
+		return err
+	}
+	if err := InitializeDevices(db); err != nil {
+		return err
+	}
+	if err := InitializeCampaigns(db); err != nil {
+		return err
+	}
 
 	log.Println("MongoDB initialization completed successfully.")
 
@@ -90,6 +133,207 @@ func createProductReferenceIndex(db *mongo.Database) error {
 
 func createPromotionCodeIndex(db *mongo.Database) error {
 	collection := db.Collection("promotions")
+
+	// Codes used to be unique over every promotion, automatic promotions have no code
+	cursor, err := collection.Indexes().List(context.Background())
+	if err != nil {
+		return fmt.Errorf("error listing promotion indexes: %v", err)
+	}
+	var indexes []bson.M
+	if err := cursor.All(context.Background(), &indexes); err != nil {
+		return fmt.Errorf("error listing promotion indexes: %v", err)
+	}
+	for _, index := range indexes {
+		if index["name"] == "code_1" && index["partialFilterExpression"] == nil {
+			if _, err := collection.Indexes().DropOne(context.Background(), "code_1"); err != nil {
+				return fmt.Errorf("error dropping promotion code index: %v", err)
+			}
+		}
+	}
+
+	_, err = collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{primitive.E{Key: "code", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"code": bson.M{"$gt": ""}}),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating unique index on promotion code: %v", err)
+	}
+	return nil
+}
+
+// createPromotionRedemptionIndexes makes a redemption unique per promotion and invoice, and
+// speeds up counting the redemptions of a user
+func createPromotionRedemptionIndexes(db *mongo.Database) error {
+	collection := db.Collection("promotion_redemptions")
+	_, err := collection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "promotionId", Value: 1}, {Key: "invoiceId", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "promotionId", Value: 1}, {Key: "userId", Value: 1}},
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating promotion redemption indexes: %v", err)
+	}
+	return nil
+}
+
+// createLoyaltyLedgerIndexes keeps a single entry of each type per invoice, so that points
+// are never earned or given back twice
+func createLoyaltyLedgerIndexes(db *mongo.Database) error {
+	collection := db.Collection("loyalty_ledger")
+	_, err := collection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{{Key: "invoiceId", Value: 1}, {Key: "type", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"invoiceId": bson.M{"$gt": ""}}),
+			},
+			{
+				Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating loyalty ledger indexes: %v", err)
+	}
+	return nil
+}
+
+// InitializeIdempotencyKeys lets MongoDB remove the stored responses once they expire
+func InitializeIdempotencyKeys(db *mongo.Database) error {
+	collection := db.Collection("idempotency_keys")
+	_, err := collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	)
+	if err != nil {
+		log.Printf("Error creating TTL index for idempotency keys: %v", err)
+	}
+	return nil
+}
+
+// InitializeCreditNotes makes sure an invoice is credited only once
+func InitializeCreditNotes(db *mongo.Database) error {
+	collection := db.Collection("credit_notes")
+	_, err := collection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "invoiceId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "issuedAt", Value: -1}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating credit note indexes: %v", err)
+	}
+	return nil
+}
+
+// InitializeMailOutbox indexes the emails waiting to be sent
+func InitializeMailOutbox(db *mongo.Database) error {
+	collection := db.Collection("mail_outbox")
+	_, err := collection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating mail outbox indexes: %v", err)
+	}
+	return nil
+}
+
+// InitializeNotifications indexes the notification history of each user and the queue
+func InitializeNotifications(db *mongo.Database) error {
+	collection := db.Collection("notifications")
+	_, err := collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "sentAt", Value: -1}}},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating notification history index: %v", err)
+	}
+
+	// The queue the notification workers send from
+	_, err = db.Collection("notification_jobs").Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+			{Keys: bson.D{{Key: "dispatchId", Value: 1}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating notification queue indexes: %v", err)
+	}
+	return nil
+}
+
+// InitializeCampaigns indexes the campaigns the scheduler looks for
+func InitializeCampaigns(db *mongo.Database) error {
+	collection := db.Collection("campaigns")
+	_, err := collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "scheduledAt", Value: 1}}},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating campaign index: %v", err)
+	}
+	return nil
+}
+
+// InitializeDevices moves the device token users had before they could have several devices
+func InitializeDevices(db *mongo.Database) error {
+	collection := db.Collection("devices")
+	_, err := collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}}},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating device index: %v", err)
+	}
+
+	migrated, err := models.MigrateDeviceTokens()
+	if err != nil {
+		return fmt.Errorf("error migrating device tokens: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("Moved the device token of %d users to their devices", migrated)
+	}
+	return nil
+}
+
+func createCategorySlugIndex(db *mongo.Database) error {
+	collection := db.Collection("categories")
+	_, err := collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{primitive.E{Key: "slug", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating unique index on category slug: %v", err)
+	}
+	return nil
+}
+
+func createVatRateCodeIndex(db *mongo.Database) error {
+	collection := db.Collection("vat_rates")
 	_, err := collection.Indexes().CreateOne(
 		context.Background(),
 		mongo.IndexModel{
@@ -98,7 +342,22 @@ func createPromotionCodeIndex(db *mongo.Database) error {
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("error creating unique index on promotion code: %v", err)
+		return fmt.Errorf("error creating unique index on VAT rate code: %v", err)
+	}
+	return nil
+}
+
+func createPriceHistoryIndexes(db *mongo.Database) error {
+	collection := db.Collection("price_history")
+	_, err := collection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "productId", Value: 1}, {Key: "effectiveAt", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "effectiveAt", Value: 1}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating indexes on price history: %v", err)
 	}
 	return nil
 }
@@ -159,7 +418,6 @@ func InitializeUsers(db *mongo.Database) error {
 			City:        firstCity,
 			Address:     "123 Main St",
 			Roles:       []entities.RoleStruct{employeeRole},
-			DeviceToken: "ExponentPushToken[john-doe-device-token-1]",
 			Invoices: []entities.InvoiceStruct{
 				{
 					Id:         primitive.NewObjectID().Hex(),
@@ -187,7 +445,7 @@ func InitializeUsers(db *mongo.Database) error {
 				{
 					Id:         primitive.NewObjectID().Hex(),
 					Date:       time.Now().Format(time.RFC3339),
-					TotalPrice: product1.PriceVat * 3,
+					TotalPrice: product1.PriceVat.Mul(3),
 					Order: entities.OrderStruct{
 						Id:            primitive.NewObjectID().Hex(),
 						Date:          time.Now(),
@@ -203,7 +461,7 @@ func InitializeUsers(db *mongo.Database) error {
 							{
 								ProductId: product1.Id,
 								Quantity:  3,
-								Price:     product1.PriceVat * 3,
+								Price:     product1.PriceVat.Mul(3),
 							},
 						},
 					},
@@ -212,7 +470,7 @@ func InitializeUsers(db *mongo.Database) error {
 				{
 					Id:         primitive.NewObjectID().Hex(),
 					Date:       time.Now().Format(time.RFC3339),
-					TotalPrice: product2.PriceVat * 8,
+					TotalPrice: product2.PriceVat.Mul(8),
 					Order: entities.OrderStruct{
 						Id:            primitive.NewObjectID().Hex(),
 						Date:          time.Now(),
@@ -227,7 +485,7 @@ func InitializeUsers(db *mongo.Database) error {
 							{
 								ProductId: product2.Id,
 								Quantity:  8,
-								Price:     product2.PriceVat * 8,
+								Price:     product2.PriceVat.Mul(8),
 							},
 						},
 					},
@@ -261,7 +519,6 @@ func InitializeUsers(db *mongo.Database) error {
 			City:        firstCity,
 			Address:     "123 Main St",
 			Roles:       []entities.RoleStruct{employeeRole},
-			DeviceToken: "ExponentPushToken[machine-dupond-device-token-2]",
 			Invoices: []entities.InvoiceStruct{
 				{
 					Id:         primitive.NewObjectID().Hex(),
@@ -315,7 +572,6 @@ func InitializeUsers(db *mongo.Database) error {
 			City:        firstCity,
 			Address:     "435 troll lane",
 			Roles:       []entities.RoleStruct{roleAdmin},
-			DeviceToken: "ExponentPushToken[linus-torvalds-device-token-3]",
 			Logs: []entities.LogStruct{
 				{
 					TableName:  "users",
@@ -369,8 +625,7 @@ func InitializeProducts(db *mongo.Database) error {
 		// })
 		_, err := models.CreateProduct(entities.ProductBasic{
 			Reference:     "1234567890",
-			PriceVat:      9.6,
-			PriceNot:      8,
+			PriceVat:      entities.NewMoney(960, entities.DefaultCurrency),
 			StockQuantity: 1400,
 		})
 		if err != nil {
@@ -380,8 +635,7 @@ func InitializeProducts(db *mongo.Database) error {
 
 		_, err = models.CreateProduct(entities.ProductBasic{
 			Reference:     "8410261718217",
-			PriceVat:      6,
-			PriceNot:      5,
+			PriceVat:      entities.NewMoney(600, entities.DefaultCurrency),
 			StockQuantity: 130,
 		})
 		if err != nil {
@@ -391,8 +645,7 @@ func InitializeProducts(db *mongo.Database) error {
 
 		_, err = models.CreateProduct(entities.ProductBasic{
 			Reference:     "7622210100917",
-			PriceVat:      9.6,
-			PriceNot:      8,
+			PriceVat:      entities.NewMoney(960, entities.DefaultCurrency),
 			StockQuantity: 400,
 		})
 
@@ -403,8 +656,7 @@ func InitializeProducts(db *mongo.Database) error {
 
 		_, err = models.CreateProduct(entities.ProductBasic{
 			Reference:     "9002490246594",
-			PriceVat:      48,
-			PriceNot:      40,
+			PriceVat:      entities.NewMoney(4800, entities.DefaultCurrency),
 			StockQuantity: 823,
 		})
 		if err != nil {
@@ -414,8 +666,7 @@ func InitializeProducts(db *mongo.Database) error {
 
 		_, err = models.CreateProduct(entities.ProductBasic{
 			Reference:     "5449000195340",
-			PriceVat:      6,
-			PriceNot:      5,
+			PriceVat:      entities.NewMoney(600, entities.DefaultCurrency),
 			StockQuantity: 1233,
 		})
 		if err != nil {
@@ -425,8 +676,7 @@ func InitializeProducts(db *mongo.Database) error {
 
 		_, err = models.CreateProduct(entities.ProductBasic{
 			Reference:     "3174780000363",
-			PriceVat:      3,
-			PriceNot:      2.50,
+			PriceVat:      entities.NewMoney(300, entities.DefaultCurrency),
 			StockQuantity: 12,
 		})
 		if err != nil {
@@ -436,8 +686,7 @@ func InitializeProducts(db *mongo.Database) error {
 
 		_, err = models.CreateProduct(entities.ProductBasic{
 			Reference:     "8000500426494",
-			PriceVat:      4.2,
-			PriceNot:      3.50,
+			PriceVat:      entities.NewMoney(420, entities.DefaultCurrency),
 			StockQuantity: 12,
 		})
 
@@ -453,6 +702,102 @@ func InitializeProducts(db *mongo.Database) error {
 	return nil
 }
 
+// InitializeCategories links products created before the categories collection existed
+func InitializeCategories(db *mongo.Database) error {
+	// Create unique slug index for categories
+	if err := createCategorySlugIndex(db); err != nil {
+		log.Printf("Error creating unique slug index for categories: %v", err)
+	}
+
+	updated, err := models.SyncProductCategories()
+	if err != nil {
+		log.Fatalf("Error linking products to categories: %v", err)
+		return err
+	}
+
+	log.Printf("Collection 'categories' initialized, %d products linked.", updated)
+	return nil
+}
+
+// MigrateMoney converts amounts stored as decimal numbers to cents
+func MigrateMoney(db *mongo.Database) error {
+	migrated, err := models.MigrateMoneyFields()
+	if err != nil {
+		log.Fatalf("Error migrating amounts to cents: %v", err)
+		return err
+	}
+
+	if migrated > 0 {
+		log.Printf("%d documents migrated to cent amounts.", migrated)
+	}
+	return nil
+}
+
+// InitializeVatRates seeds the VAT rates used to compute order taxes
+func InitializeVatRates(db *mongo.Database) error {
+	if err := createVatRateCodeIndex(db); err != nil {
+		log.Printf("Error creating unique code index for VAT rates: %v", err)
+	}
+
+	inserted, err := models.InitializeVatRates()
+	if err != nil {
+		log.Fatalf("Error initializing VAT rates: %v", err)
+		return err
+	}
+
+	log.Printf("Collection 'vat_rates' initialized, %d rates inserted.", inserted)
+
+	// Orders and credit notes used to store rates as fractions, and products a price
+	// without VAT that did not follow their rate
+	migrated, err := models.MigrateVatRatesToBasisPoints()
+	if err != nil {
+		log.Printf("Error migrating VAT rates to basis points: %v", err)
+	} else if migrated > 0 {
+		log.Printf("Migrated VAT rates of %d documents to basis points.", migrated)
+	}
+	synced, err := models.SyncNetPrices()
+	if err != nil {
+		log.Printf("Error syncing prices without VAT: %v", err)
+	} else if synced > 0 {
+		log.Printf("Synced the price without VAT of %d products.", synced)
+	}
+	return nil
+}
+
+// InitializeExchangeRates loads EXCHANGE_RATES_FILE when configured.
+// Rates can also be set one by one by an admin.
+func InitializeExchangeRates(db *mongo.Database) error {
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		return nil
+	}
+
+	loaded, err := models.LoadExchangeRatesFile(path)
+	if err != nil {
+		log.Printf("Error loading exchange rates from %s: %v", path, err)
+		return nil
+	}
+
+	log.Printf("Collection 'exchange_rates' initialized, %d rates loaded.", loaded)
+	return nil
+}
+
+// InitializePriceHistory records the current price of products created before price history existed
+func InitializePriceHistory(db *mongo.Database) error {
+	if err := createPriceHistoryIndexes(db); err != nil {
+		log.Printf("Error creating indexes for price history: %v", err)
+	}
+
+	recorded, err := models.InitializePriceHistory()
+	if err != nil {
+		log.Fatalf("Error initializing price history: %v", err)
+		return err
+	}
+
+	log.Printf("Collection 'price_history' initialized, %d prices recorded.", recorded)
+	return nil
+}
+
 func InitializeCities(db *mongo.Database) error {
 	collection := db.Collection("cities")
 
@@ -516,7 +861,7 @@ func InitialiseSuppliers(db *mongo.Database) error {
 				{
 					Id:         primitive.NewObjectID().Hex(),
 					Date:       time.Now().Format(time.RFC3339),
-					TotalPrice: 100,
+					TotalPrice: entities.NewMoney(10000, entities.DefaultCurrency),
 					Order: entities.OrderStruct{
 						Id:     primitive.NewObjectID().Hex(),
 						Date:   time.Now(),
@@ -608,6 +953,49 @@ func InitializeRoles(db *mongo.Database) error {
 	return nil
 }
 
+// newPermissions lists the permissions added to the roles after they were first seeded
+var newPermissions = map[string][]entities.PermissionStruct{
+	"employee": {
+		{Resource: "/payment/quote", Actions: []string{"POST"}},
+		{Resource: "/user/self/loyalty", Actions: []string{"GET"}},
+		{Resource: "/product/recommendations/self", Actions: []string{"GET"}},
+		{Resource: "/cart/self/*", Actions: []string{"GET", "POST", "PUT", "DELETE"}},
+		{Resource: "/invoice/self/:id/cancel", Actions: []string{"POST"}},
+		{Resource: "/invoice/self/:id/pdf", Actions: []string{"GET"}},
+		{Resource: "/invoice/self/credit-notes", Actions: []string{"GET"}},
+		{Resource: "/invoice/self/credit-notes/:id/pdf", Actions: []string{"GET"}},
+		{Resource: "/push-notification/self/*", Actions: []string{"GET", "PUT"}},
+		{Resource: "/push-notification/unregister-token", Actions: []string{"POST"}},
+		{Resource: "/push-notification/self/history/:id/open", Actions: []string{"POST"}},
+	},
+	"user": {
+		{Resource: "/payment/quote", Actions: []string{"POST"}},
+		{Resource: "/user/self/loyalty", Actions: []string{"GET"}},
+		{Resource: "/product/recommendations/self", Actions: []string{"GET"}},
+		{Resource: "/cart/self/*", Actions: []string{"GET", "POST", "PUT", "DELETE"}},
+		{Resource: "/invoice/self/:id/cancel", Actions: []string{"POST"}},
+		{Resource: "/invoice/self/:id/pdf", Actions: []string{"GET"}},
+		{Resource: "/invoice/self/credit-notes", Actions: []string{"GET"}},
+		{Resource: "/invoice/self/credit-notes/:id/pdf", Actions: []string{"GET"}},
+		{Resource: "/push-notification/self/*", Actions: []string{"GET", "PUT"}},
+		{Resource: "/push-notification/unregister-token", Actions: []string{"POST"}},
+		{Resource: "/push-notification/self/history/:id/open", Actions: []string{"POST"}},
+	},
+}
+
+// GrantNewPermissions gives existing roles, and the users holding them, the permissions of
+// the routes added since they were created
+func GrantNewPermissions(db *mongo.Database) error {
+	for role, permissions := range newPermissions {
+		if err := models.GrantRolePermissions(role, permissions...); err != nil {
+			log.Fatalf("Error granting permissions to role %s: %v", role, err)
+			return err
+		}
+	}
+	log.Println("Role permissions up to date.")
+	return nil
+}
+
 func InitializePromotions(db *mongo.Database) error {
 	collection := db.Collection("promotions")
 
@@ -615,6 +1003,14 @@ func InitializePromotions(db *mongo.Database) error {
 	if err := createPromotionCodeIndex(db); err != nil {
 		log.Printf("Error creating unique code index for promotions: %v", err)
 	}
+	if err := createPromotionRedemptionIndexes(db); err != nil {
+		log.Printf("Error creating indexes for promotion redemptions: %v", err)
+	}
+	if updated, err := models.InitializeUserRedemptions(); err != nil {
+		log.Printf("Error counting promotion redemptions per user: %v", err)
+	} else if updated > 0 {
+		log.Printf("Counted the redemptions per user of %d promotions.", updated)
+	}
 
 	// Check if collection is empty
 	count, err := collection.CountDocuments(context.Background(), bson.D{})
@@ -652,6 +1048,7 @@ func InitializePromotions(db *mongo.Database) error {
 			Name:                   firstProduct.Name,
 			Brand:                  firstProduct.Brand,
 			Category:               firstProduct.Category,
+			CategoryIds:            firstProduct.CategoryIds,
 			NutritionalInformation: firstProduct.NutritionalInformation,
 		}
 
@@ -663,6 +1060,7 @@ func InitializePromotions(db *mongo.Database) error {
 			Name:                   product1.Name,
 			Brand:                  product1.Brand,
 			Category:               product1.Category,
+			CategoryIds:            product1.CategoryIds,
 			NutritionalInformation: product1.NutritionalInformation,
 		}
 
@@ -674,6 +1072,7 @@ func InitializePromotions(db *mongo.Database) error {
 			Name:                   product2.Name,
 			Brand:                  product2.Brand,
 			Category:               product2.Category,
+			CategoryIds:            product2.CategoryIds,
 			NutritionalInformation: product2.NutritionalInformation,
 		}
 
@@ -684,12 +1083,12 @@ func InitializePromotions(db *mongo.Database) error {
 				Name:          "Summer Sale",
 				Description:   "Get 20% off on selected products",
 				DiscountType:  "percentage",
-				DiscountValue: 20,
+				DiscountValue: 2000,
 				Code:          "SUMMER20",
 				StartDate:     time.Now().Format(time.RFC3339),
 				EndDate:       time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339),
 				Products:      []entities.ProductOrder{firstProductOrder},
-				MinPurchase:   0,
+				MinPurchase:   entities.NewMoney(0, entities.DefaultCurrency),
 				Status:        "active",
 				CreatedAt:     time.Now().Format(time.RFC3339),
 				UpdatedAt:     time.Now().Format(time.RFC3339),
@@ -699,12 +1098,12 @@ func InitializePromotions(db *mongo.Database) error {
 				Name:          "Buy One Get One Free",
 				Description:   "Buy one product and get another one free",
 				DiscountType:  "bogo",
-				DiscountValue: 100,
+				DiscountValue: 10000,
 				Code:          "BOGOF",
 				StartDate:     time.Now().Format(time.RFC3339),
 				EndDate:       time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339),
 				Products:      []entities.ProductOrder{product1Order},
-				MinPurchase:   2,
+				MinPurchase:   entities.NewMoney(200, entities.DefaultCurrency),
 				Status:        "active",
 				CreatedAt:     time.Now().Format(time.RFC3339),
 				UpdatedAt:     time.Now().Format(time.RFC3339),
@@ -714,12 +1113,12 @@ func InitializePromotions(db *mongo.Database) error {
 				Name:          "Flash Sale",
 				Description:   "Get €5 off on order",
 				DiscountType:  "fixed",
-				DiscountValue: 5,
+				DiscountValue: 500,
 				Code:          "FLASH5",
 				StartDate:     time.Now().Format(time.RFC3339),
 				EndDate:       time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339),
 				Products:      []entities.ProductOrder{product2Order, firstProductOrder},
-				MinPurchase:   50,
+				MinPurchase:   entities.NewMoney(5000, entities.DefaultCurrency),
 				Status:        "active",
 				CreatedAt:     time.Now().Format(time.RFC3339),
 				UpdatedAt:     time.Now().Format(time.RFC3339),
@@ -739,3 +1138,34 @@ func InitializePromotions(db *mongo.Database) error {
 
 	return nil
 }
+
+func InitializeLoyalty(db *mongo.Database) error {
+	collection := db.Collection("loyalty_rules")
+
+	if err := createLoyaltyLedgerIndexes(db); err != nil {
+		log.Printf("Error creating indexes for the loyalty ledger: %v", err)
+	}
+
+	count, err := collection.CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		log.Fatalf("Error checking loyalty rules collection: %v", err)
+		return err
+	}
+
+	if count == 0 {
+		_, err := models.CreateLoyaltyRule(entities.LoyaltyRuleStruct{
+			Name:          "Every purchase",
+			PointsPerUnit: 1,
+			Active:        true,
+		})
+		if err != nil {
+			log.Fatalf("Error initializing loyalty rules collection: %v", err)
+			return err
+		}
+		log.Println("Collection 'loyalty_rules' initialized.")
+	} else {
+		log.Println("Collection 'loyalty_rules' already initialized.")
+	}
+
+	return nil
+}
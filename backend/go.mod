@@ -5,11 +5,15 @@ go 1.23.3
 require (
 	github.com/go-playground/validator/v10 v10.24.0
 	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/jung-kurt/gofpdf v1.16.2
 	github.com/labstack/echo-jwt/v4 v4.3.0
 	github.com/labstack/echo/v4 v4.13.3
 	github.com/plutov/paypal/v4 v4.11.0
 	go.mongodb.org/mongo-driver v1.17.2
 	golang.org/x/crypto v0.36.0
+	golang.org/x/text v0.23.0
+	golang.org/x/time v0.11.0
+	google.golang.org/api v0.226.0
 )
 
 require (
@@ -75,9 +79,6 @@ require (
 	golang.org/x/oauth2 v0.28.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
-	golang.org/x/time v0.11.0 // indirect
-	google.golang.org/api v0.226.0 // indirect
 	google.golang.org/appengine/v2 v2.0.6 // indirect
 	google.golang.org/genproto v0.0.0-20241118233622-e639e219e697 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20250106144421-5f5ef82da422 // indirect
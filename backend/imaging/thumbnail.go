@@ -0,0 +1,89 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+const (
+	SizeS  = 200  // longest side of the small thumbnail, in pixels
+	SizeXL = 1000 // longest side of the large image, in pixels
+
+	maxPixels   = 40_000_000
+	jpegQuality = 85
+)
+
+// Decode reads a JPEG, PNG or GIF image, refusing pictures too large to process safely
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %v", err)
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image too large: %dx%d", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %v", err)
+	}
+	return img, nil
+}
+
+// Thumbnail scales src down so its longest side is at most maxSize, keeping the aspect ratio.
+// Transparent areas are flattened onto white since thumbnails are encoded as JPEG.
+// Pixels are averaged over their source area, which avoids the aliasing of nearest-neighbour.
+func Thumbnail(src image.Image, maxSize int) *image.RGBA {
+	bounds := src.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+
+	sw, sh := flat.Bounds().Dx(), flat.Bounds().Dy()
+	longest := max(sw, sh)
+	if longest <= maxSize {
+		return flat
+	}
+
+	dw := max(1, sw*maxSize/longest)
+	dh := max(1, sh*maxSize/longest)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := flat.Pix[sy*flat.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+
+	return dst
+}
+
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,319 @@
+package invoicing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"trinity/backend/items/entities"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	pageMargin = 10.0
+	lineHeight = 5.0
+)
+
+// Columns of the line items table, in millimetres, 190 being the width of an A4 page
+// without its margins
+var lineColumns = []struct {
+	title string
+	width float64
+	align string
+}{
+	{"Description", 70, "L"},
+	{"Qty", 14, "R"},
+	{"Unit price", 24, "R"},
+	{"VAT", 16, "R"},
+	{"Net", 22, "R"},
+	{"VAT amount", 22, "R"},
+	{"Total", 22, "R"},
+}
+
+// document is what invoices and credit notes have in common once laid out
+type document struct {
+	title           string
+	number          string
+	issuedAt        string
+	details         [][2]string
+	seller          entities.InvoicePartyStruct
+	buyer           entities.InvoicePartyStruct
+	lines           []entities.OrderProductStruct
+	subtotal        entities.Money
+	totalDiscount   entities.Money
+	discounts       []entities.AppliedDiscountStruct
+	loyaltyPoints   int64
+	loyaltyDiscount entities.Money
+	taxBreakdown    []entities.TaxBreakdownStruct
+	totalNet        entities.Money
+	totalTax        entities.Money
+	totalPrice      entities.Money
+	currency        string
+	exchangeRate    string
+	baseTotalPrice  entities.Money
+	baseTotalTax    entities.Money
+}
+
+// RenderPDF lays out an issued invoice: seller and buyer, line items, discounts, VAT
+// breakdown and totals
+func RenderPDF(invoice entities.InvoiceStruct) ([]byte, error) {
+	if invoice.Number == "" {
+		return nil, fmt.Errorf("invoice %s is not issued", invoice.Id)
+	}
+
+	return render(document{
+		title:    "Invoice",
+		number:   invoice.Number,
+		issuedAt: invoice.IssuedAt,
+		details: [][2]string{
+			{"Invoice number", invoice.Number},
+			{"Issue date", formatDate(invoice.IssuedAt)},
+			{"Order date", formatDate(invoice.Date)},
+			{"Order reference", invoice.Id},
+			{"Payment", strings.ToUpper(invoice.Order.PaymentMethod)},
+		},
+		seller:          invoice.Seller,
+		buyer:           invoice.Buyer,
+		lines:           invoice.Order.Products,
+		subtotal:        invoice.Subtotal,
+		totalDiscount:   invoice.TotalDiscount,
+		discounts:       invoice.Discounts,
+		loyaltyPoints:   invoice.LoyaltyPointsRedeemed,
+		loyaltyDiscount: invoice.LoyaltyDiscount,
+		taxBreakdown:    invoice.TaxBreakdown,
+		totalNet:        invoice.TotalNet,
+		totalTax:        invoice.TotalTax,
+		totalPrice:      invoice.TotalPrice,
+		currency:        invoice.Currency,
+		exchangeRate:    invoice.ExchangeRate,
+		baseTotalPrice:  invoice.BaseTotalPrice,
+		baseTotalTax:    invoice.BaseTotalTax,
+	})
+}
+
+// RenderCreditNotePDF lays out a credit note, which takes back the amounts of the invoice it
+// references
+func RenderCreditNotePDF(note entities.CreditNoteStruct) ([]byte, error) {
+	if note.Number == "" {
+		return nil, fmt.Errorf("credit note %s is not issued", note.Id)
+	}
+
+	return render(document{
+		title:    "Credit note",
+		number:   note.Number,
+		issuedAt: note.IssuedAt,
+		details: [][2]string{
+			{"Credit note number", note.Number},
+			{"Issue date", formatDate(note.IssuedAt)},
+			{"Credited invoice", note.InvoiceNumber},
+			{"Reason", note.Reason},
+		},
+		seller:         note.Seller,
+		buyer:          note.Buyer,
+		lines:          note.Lines,
+		taxBreakdown:   note.TaxBreakdown,
+		totalNet:       note.TotalNet,
+		totalTax:       note.TotalTax,
+		totalPrice:     note.TotalPrice,
+		currency:       note.Currency,
+		exchangeRate:   note.ExchangeRate,
+		baseTotalPrice: note.BaseTotalPrice,
+		baseTotalTax:   note.BaseTotalTax,
+	})
+}
+
+func render(doc document) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.SetTitle(doc.title+" "+doc.number, true)
+	pdf.SetCreationDate(parseDate(doc.issuedAt))
+	pdf.SetModificationDate(parseDate(doc.issuedAt))
+	// Core fonts only cover cp1252, which has the accents and the euro sign
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(0, lineHeight, tr(fmt.Sprintf("%s %s - page %d/{nb}", doc.title, doc.number, pdf.PageNo())), "", 0, "C", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+	pdf.AddPage()
+
+	writeHeader(pdf, tr, doc)
+	writeParties(pdf, tr, doc)
+	writeLines(pdf, tr, doc)
+	writeTotals(pdf, tr, doc)
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, fmt.Errorf("failed to render %s %s: %v", strings.ToLower(doc.title), doc.number, err)
+	}
+	return out.Bytes(), nil
+}
+
+func parseDate(value string) time.Time {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+func formatDate(value string) string {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return date.Format("2006-01-02")
+}
+
+func writeHeader(pdf *gofpdf.Fpdf, tr func(string) string, doc document) {
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, tr(strings.ToUpper(doc.title)), "", 1, "L", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, detail := range doc.details {
+		if detail[1] == "" {
+			continue
+		}
+		pdf.CellFormat(35, lineHeight, tr(detail[0]), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, lineHeight, tr(detail[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(lineHeight)
+}
+
+func partyLines(party entities.InvoicePartyStruct) []string {
+	lines := []string{party.Name, party.Address, strings.TrimSpace(party.PostalCode + " " + party.City), party.Country}
+	if party.Email != "" {
+		lines = append(lines, party.Email)
+	}
+	if party.VatNumber != "" {
+		lines = append(lines, "VAT number: "+party.VatNumber)
+	}
+	if party.CompanyId != "" {
+		lines = append(lines, "Company ID: "+party.CompanyId)
+	}
+	return lines
+}
+
+func writeParties(pdf *gofpdf.Fpdf, tr func(string) string, doc document) {
+	top := pdf.GetY()
+	for i, party := range []struct {
+		title string
+		party entities.InvoicePartyStruct
+	}{{"Seller", doc.seller}, {"Billed to", doc.buyer}} {
+		x := pageMargin + float64(i)*95
+		pdf.SetXY(x, top)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(95, lineHeight, tr(party.title), "", 2, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		for _, line := range partyLines(party.party) {
+			if strings.TrimSpace(line) != "" {
+				pdf.CellFormat(95, lineHeight, tr(line), "", 2, "L", false, 0, "")
+			}
+		}
+	}
+	pdf.SetXY(pageMargin, top+9*lineHeight)
+}
+
+// formatRate prints a VAT rate in basis points, e.g. 550 as 5.5%
+func formatRate(rate int64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%d.%02d", rate/100, rate%100), "0"), ".") + "%"
+}
+
+func writeLines(pdf *gofpdf.Fpdf, tr func(string) string, doc document) {
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(230, 230, 230)
+	for _, column := range lineColumns {
+		pdf.CellFormat(column.width, 7, tr(column.title), "1", 0, column.align, true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range doc.lines {
+		name := line.Name
+		if name == "" {
+			name = line.ProductId
+		}
+		values := []string{
+			name,
+			fmt.Sprintf("%d", line.Quantity),
+			line.UnitPrice.Decimal(),
+			formatRate(line.VatRate),
+			line.Net.Decimal(),
+			line.Tax.Decimal(),
+			line.Gross.Decimal(),
+		}
+		for i, column := range lineColumns {
+			value := tr(values[i])
+			// Long product names are cut to keep one row per line
+			for i == 0 && pdf.GetStringWidth(value) > column.width-2 {
+				value = value[:len(value)-1]
+			}
+			pdf.CellFormat(column.width, 6, value, "1", 0, column.align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(lineHeight)
+}
+
+func writeAmount(pdf *gofpdf.Fpdf, tr func(string) string, label string, amount entities.Money, bold bool) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	pdf.SetFont("Helvetica", style, 10)
+	pdf.SetX(pageMargin + 100)
+	pdf.CellFormat(60, 6, tr(label), "", 0, "L", false, 0, "")
+	pdf.CellFormat(30, 6, tr(amount.String()), "", 1, "R", false, 0, "")
+}
+
+func writeTotals(pdf *gofpdf.Fpdf, tr func(string) string, doc document) {
+	if !doc.totalDiscount.IsZero() {
+		writeAmount(pdf, tr, "Subtotal", doc.subtotal, false)
+		for _, discount := range doc.discounts {
+			label := discount.Name
+			if discount.Code != "" {
+				label += " (" + discount.Code + ")"
+			}
+			writeAmount(pdf, tr, label, entities.NewMoney(-discount.Amount.Amount, discount.Amount.Currency), false)
+		}
+		if doc.loyaltyPoints > 0 {
+			label := fmt.Sprintf("Loyalty points (%d)", doc.loyaltyPoints)
+			writeAmount(pdf, tr, label, entities.NewMoney(-doc.loyaltyDiscount.Amount, doc.loyaltyDiscount.Currency), false)
+		}
+		pdf.Ln(2)
+	}
+
+	// VAT breakdown, one row per rate
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(230, 230, 230)
+	pdf.SetX(pageMargin + 100)
+	for _, title := range []string{"VAT rate", "Net", "VAT", "Total"} {
+		pdf.CellFormat(22.5, 6, tr(title), "1", 0, "R", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 9)
+	for _, group := range doc.taxBreakdown {
+		pdf.SetX(pageMargin + 100)
+		for _, value := range []string{formatRate(group.Rate), group.Net.Decimal(), group.Tax.Decimal(), group.Gross.Decimal()} {
+			pdf.CellFormat(22.5, 6, tr(value), "1", 0, "R", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(2)
+
+	writeAmount(pdf, tr, "Total excluding VAT", doc.totalNet, false)
+	writeAmount(pdf, tr, "Total VAT", doc.totalTax, false)
+	writeAmount(pdf, tr, "Total including VAT", doc.totalPrice, true)
+
+	if doc.exchangeRate != "" && doc.currency != "" && doc.currency != doc.baseTotalPrice.Currency && !doc.baseTotalPrice.IsZero() {
+		pdf.Ln(lineHeight)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.MultiCell(0, 4, tr(fmt.Sprintf("Exchange rate: 1 %s = %s %s. Total including VAT: %s, of which VAT: %s.",
+			doc.baseTotalPrice.Currency, doc.exchangeRate, doc.currency,
+			doc.baseTotalPrice.String(), doc.baseTotalTax.String())), "", "L", false)
+	}
+}
@@ -0,0 +1,18 @@
+package invoicing
+
+import "testing"
+
+func TestFormatRate(t *testing.T) {
+	cases := map[int64]string{
+		2000: "20%",
+		1000: "10%",
+		550:  "5.5%",
+		210:  "2.1%",
+		0:    "0%",
+	}
+	for rate, want := range cases {
+		if got := formatRate(rate); got != want {
+			t.Errorf("formatRate(%d) = %q, want %q", rate, got, want)
+		}
+	}
+}
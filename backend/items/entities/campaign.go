@@ -0,0 +1,56 @@
+package entities
+
+import "time"
+
+// CampaignTemplateStruct is the text of a campaign in one language. It may use the variables
+// {{firstName}}, {{lastName}}, and with a promotion {{promoCode}} and {{promotionName}}.
+type CampaignTemplateStruct struct {
+	Title string `bson:"title" json:"title" validate:"required"`
+	Body  string `bson:"body" json:"body" validate:"required"`
+}
+
+// CampaignStruct is a notification sent to a target at a scheduled time, written in the
+// language of each user
+type CampaignStruct struct {
+	Id            string                            `bson:"_id,omitempty" json:"id"`
+	Name          string                            `bson:"name" json:"name"`
+	Type          string                            `bson:"type" json:"type"`                   // marketing or transactional
+	Templates     map[string]CampaignTemplateStruct `bson:"templates" json:"templates"`         // By locale, e.g. fr or en
+	DefaultLocale string                            `bson:"defaultLocale" json:"defaultLocale"` // For users whose locale has no template
+	Target        NotificationTargetStruct          `bson:"target" json:"target"`
+	PromotionId   string                            `bson:"promotionId,omitempty" json:"promotionId,omitempty"` // Opened by the app with the notification
+	Data          map[string]string                 `bson:"data,omitempty" json:"data,omitempty"`
+	ScheduledAt   time.Time                         `bson:"scheduledAt,omitempty" json:"scheduledAt,omitempty"` // Drafts have none
+	Status        string                            `bson:"status" json:"status"`                               // draft, scheduled, sending, sent, failed or cancelled
+	Error         string                            `bson:"error,omitempty" json:"error,omitempty"`             // Why it failed
+	DispatchId    string                            `bson:"dispatchId,omitempty" json:"dispatchId,omitempty"`
+	Stats         CampaignStatsStruct               `bson:"stats" json:"stats"`
+	CreatedAt     time.Time                         `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time                         `bson:"updatedAt" json:"updatedAt"`
+	SentAt        time.Time                         `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+	LockedUntil   time.Time                         `bson:"lockedUntil,omitempty" json:"-"` // Set while sending, a campaign still sending after it is sent again
+}
+
+// CampaignStatsStruct counts the delivery of a campaign, see NotificationDispatchStruct, and
+// the users who opened it
+type CampaignStatsStruct struct {
+	Targeted int `bson:"targeted" json:"targeted"`
+	OptedOut int `bson:"optedOut" json:"optedOut"`
+	NoDevice int `bson:"noDevice" json:"noDevice"`
+	Devices  int `bson:"devices" json:"devices"`
+	Sent     int `bson:"sent" json:"sent"`
+	Failed   int `bson:"failed" json:"failed"`
+	Opened   int `bson:"opened" json:"opened"`
+}
+
+// CampaignInputStruct creates or edits a campaign, it is saved as a draft without ScheduledAt
+type CampaignInputStruct struct {
+	Name          string                            `json:"name" validate:"required"`
+	Type          string                            `json:"type" validate:"omitempty,oneof=marketing transactional"` // marketing by default
+	Templates     map[string]CampaignTemplateStruct `json:"templates" validate:"required,min=1,dive"`
+	DefaultLocale string                            `json:"defaultLocale" validate:"required"`
+	Target        NotificationTargetStruct          `json:"target"`
+	PromotionId   string                            `json:"promotionId"`
+	Data          map[string]string                 `json:"data"`
+	ScheduledAt   string                            `json:"scheduledAt" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
@@ -0,0 +1,60 @@
+package entities
+
+type CartItemStruct struct {
+	ProductId string `bson:"productId" json:"productId" validate:"required"`
+	Quantity  int    `bson:"quantity" json:"quantity" validate:"required,gt=0"`
+}
+
+// CheckoutStruct is a cart along with how the user wants to pay for it
+type CheckoutStruct struct {
+	Cart          []CartItemStruct `json:"cart" validate:"required,dive"`
+	Currency      string           `json:"currency"`                       // One of the store currencies, the base one by default
+	Codes         []string         `json:"codes"`                          // Promo codes entered by the user
+	LoyaltyPoints int64            `json:"loyaltyPoints" validate:"gte=0"` // Points to spend on the order
+	ReplaceOrder  string           `json:"replaceOrder"`                   // Pending order this one replaces, cancelled once this one is created
+}
+
+// CartStruct is the cart a user keeps on the server, shared by all their devices
+type CartStruct struct {
+	UserId        string           `bson:"_id" json:"userId"`
+	Items         []CartItemStruct `bson:"items" json:"items"`
+	Currency      string           `bson:"currency" json:"currency"`
+	Codes         []string         `bson:"codes" json:"codes"`
+	LoyaltyPoints int64            `bson:"loyaltyPoints" json:"loyaltyPoints"`
+	InvoiceId     string           `bson:"invoiceId,omitempty" json:"invoiceId,omitempty"` // Order created from the cart, it is emptied once the order is paid
+	UpdatedAt     string           `bson:"updatedAt" json:"updatedAt"`
+	Version       int64            `bson:"version" json:"-"` // Increased on every change, a cart is only saved over the version it was read from
+}
+
+// CartQuantityStruct sets the quantity of a cart item
+type CartQuantityStruct struct {
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+// CartOptionsStruct sets how the user wants to pay for their cart
+type CartOptionsStruct struct {
+	Currency      string   `json:"currency"`
+	Codes         []string `json:"codes"`
+	LoyaltyPoints int64    `json:"loyaltyPoints" validate:"gte=0"`
+}
+
+// CartLineStruct is a cart item with the product as it is now. Issue tells why the line
+// cannot be ordered, e.g. the product is out of stock.
+type CartLineStruct struct {
+	ProductId string        `json:"productId"`
+	Quantity  int           `json:"quantity"`
+	Product   ProductStruct `json:"product"`
+	Issue     string        `json:"issue,omitempty"`
+}
+
+// CartDetailsStruct is a cart priced with the current prices, stock and promotions. Quote is
+// the order the lines without issue would make, Error tells why it could not be priced.
+type CartDetailsStruct struct {
+	Lines         []CartLineStruct `json:"lines"`
+	Currency      string           `json:"currency"`
+	Codes         []string         `json:"codes"`
+	LoyaltyPoints int64            `json:"loyaltyPoints"`
+	Quote         *InvoiceStruct   `json:"quote,omitempty"`
+	Error         string           `json:"error,omitempty"`
+	UpdatedAt     string           `json:"updatedAt"`
+}
@@ -0,0 +1,20 @@
+package entities
+
+type CategoryStruct struct {
+	Id        string   `bson:"_id,omitempty" json:"id"`
+	Slug      string   `bson:"slug" json:"slug"`
+	Name      string   `bson:"name" json:"name"`
+	ParentId  string   `bson:"parentId,omitempty" json:"parent_id,omitempty"`
+	Ancestors []string `bson:"ancestors" json:"ancestors"` // IDs from the root down to the direct parent
+	VatRateId string   `bson:"vatRateId,omitempty" json:"vat_rate_id,omitempty"`
+	Archived  bool     `bson:"archived" json:"archived"`
+}
+
+type CategoryImportStruct struct {
+	Categories string `json:"categories" validate:"required"` // OpenFoodFacts comma-separated list
+}
+
+// CategoryMoveStruct moves a category, with its sub-categories, under another one
+type CategoryMoveStruct struct {
+	ParentId string `json:"parent_id"` // Empty to make it a root category
+}
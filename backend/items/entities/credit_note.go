@@ -0,0 +1,32 @@
+package entities
+
+// Reasons of credit notes
+const (
+	CreditNoteRefund       = "refund"
+	CreditNoteCancellation = "cancellation"
+)
+
+// CreditNoteStruct cancels an issued invoice, in full, when the order is refunded or cancelled
+// after being paid. Its amounts are those taken back from the invoice.
+type CreditNoteStruct struct {
+	Id             string               `bson:"_id,omitempty" json:"id"`
+	Number         string               `bson:"number,omitempty" json:"number,omitempty"` // e.g. CN-2026-000007, sequential per year
+	InvoiceId      string               `bson:"invoiceId" json:"invoiceId"`
+	InvoiceNumber  string               `bson:"invoiceNumber" json:"invoiceNumber"`
+	UserId         string               `bson:"userId" json:"userId"`
+	Reason         string               `bson:"reason" json:"reason"` // refund or cancellation
+	IssuedAt       string               `bson:"issuedAt" json:"issuedAt"`
+	Seller         InvoicePartyStruct   `bson:"seller" json:"seller"`
+	Buyer          InvoicePartyStruct   `bson:"buyer" json:"buyer"`
+	Lines          []OrderProductStruct `bson:"lines" json:"lines"`
+	TaxBreakdown   []TaxBreakdownStruct `bson:"taxBreakdown" json:"taxBreakdown"`
+	TotalPrice     Money                `bson:"totalPrice" json:"totalPrice"`
+	TotalNet       Money                `bson:"totalNet" json:"totalNet"`
+	TotalTax       Money                `bson:"totalTax" json:"totalTax"`
+	Currency       string               `bson:"currency,omitempty" json:"currency,omitempty"`
+	ExchangeRate   string               `bson:"exchangeRate,omitempty" json:"exchangeRate,omitempty"`
+	BaseTotalPrice Money                `bson:"baseTotalPrice,omitempty" json:"baseTotalPrice"`
+	BaseTotalNet   Money                `bson:"baseTotalNet,omitempty" json:"baseTotalNet"`
+	BaseTotalTax   Money                `bson:"baseTotalTax,omitempty" json:"baseTotalTax"`
+	PdfKey         string               `bson:"pdfKey,omitempty" json:"-"`
+}
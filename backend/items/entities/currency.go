@@ -0,0 +1,29 @@
+package entities
+
+import "encoding/json"
+
+// ExchangeRateStruct converts the base currency into another one: 1 base unit = Rate units.
+// The rate is kept as a decimal string so conversions stay exact.
+type ExchangeRateStruct struct {
+	Currency  string `bson:"_id" json:"currency"`
+	Rate      string `bson:"rate" json:"rate"`
+	Source    string `bson:"source" json:"source"` // file, admin
+	UpdatedAt string `bson:"updatedAt" json:"updated_at"`
+}
+
+type ExchangeRateInputStruct struct {
+	Rate json.Number `json:"rate" validate:"required"`
+}
+
+// ExchangeRatesFileStruct is the format of EXCHANGE_RATES_FILE, e.g.
+// {"base": "EUR", "rates": {"USD": 1.0835, "GBP": "0.8421"}}
+type ExchangeRatesFileStruct struct {
+	Base  string                 `json:"base"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+type CurrenciesStruct struct {
+	Base  string               `json:"base"`
+	Store []string             `json:"store"`
+	Rates []ExchangeRateStruct `json:"rates"`
+}
@@ -0,0 +1,14 @@
+package entities
+
+import "time"
+
+// DeviceStruct is a device a user receives push notifications on. A token belongs to the
+// last user who registered it.
+type DeviceStruct struct {
+	Token        string    `bson:"_id" json:"token" validate:"required"` // FCM registration token
+	UserId       string    `bson:"userId" json:"-"`
+	Platform     string    `bson:"platform,omitempty" json:"platform,omitempty" validate:"omitempty,oneof=android ios web"`
+	AppVersion   string    `bson:"appVersion,omitempty" json:"appVersion,omitempty"`
+	RegisteredAt time.Time `bson:"registeredAt" json:"registeredAt"`
+	LastSeenAt   time.Time `bson:"lastSeenAt" json:"lastSeenAt"` // Last time the app registered the token
+}
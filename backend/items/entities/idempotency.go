@@ -0,0 +1,19 @@
+package entities
+
+import "time"
+
+// IdempotencyKeyStruct is a request sent with an Idempotency-Key header, along with the
+// response to replay when it is sent again
+type IdempotencyKeyStruct struct {
+	Id             string    `bson:"_id" json:"id"` // User ID and key
+	UserId         string    `bson:"userId" json:"userId"`
+	Key            string    `bson:"key" json:"key"`
+	Fingerprint    string    `bson:"fingerprint" json:"fingerprint"` // Hash of the method, path and body
+	Status         string    `bson:"status" json:"status"`           // processing or completed
+	LockedUntil    time.Time `bson:"lockedUntil,omitempty" json:"-"` // While processing, a retry takes the key over afterwards
+	ResponseStatus int       `bson:"responseStatus,omitempty" json:"responseStatus,omitempty"`
+	ContentType    string    `bson:"contentType,omitempty" json:"contentType,omitempty"`
+	ResponseBody   []byte    `bson:"responseBody,omitempty" json:"responseBody,omitempty"`
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt      time.Time `bson:"expiresAt" json:"expiresAt"` // Removed by a TTL index
+}
@@ -3,4 +3,11 @@ package entities
 type ImagesStruct struct {
 	S  string `bson:"s"`
 	XL string `bson:"xl"`
+	// Uploaded images, S and XL then point to our own image endpoint
+	Source     string `bson:"source,omitempty"` // openfoodfacts, upload
+	Version    string `bson:"version,omitempty" json:"-"`
+	SKey       string `bson:"sKey,omitempty" json:"-"`
+	XLKey      string `bson:"xlKey,omitempty" json:"-"`
+	OriginalS  string `bson:"originalS,omitempty" json:"-"` // OpenFoodFacts URLs kept as a fallback
+	OriginalXL string `bson:"originalXL,omitempty" json:"-"`
 }
@@ -1,23 +1,85 @@
 package entities
 
 type InvoiceStruct struct {
-	Id         string      `bson:"_id,omitempty" json:"id"`
-	Date       string      `bson:"date" json:"date"`
-	TotalPrice float64     `bson:"totalPrice" json:"totalPrice"`
-	Order      OrderStruct `bson:"order" json:"order"` // Embedded order details
-	Archived   bool        `bson:"archived" json:"archived"`
+	Id           string               `bson:"_id,omitempty" json:"id"`
+	Date         string               `bson:"date" json:"date"`
+	TotalPrice   Money                `bson:"totalPrice" json:"totalPrice"` // Including VAT
+	TotalNet     Money                `bson:"totalNet" json:"totalNet"`
+	TotalTax     Money                `bson:"totalTax" json:"totalTax"`
+	TaxBreakdown []TaxBreakdownStruct `bson:"taxBreakdown" json:"taxBreakdown"` // One entry per VAT rate
+	// Amounts above are in the currency charged, these are their base currency equivalents
+	Currency       string `bson:"currency,omitempty" json:"currency,omitempty"`
+	ExchangeRate   string `bson:"exchangeRate,omitempty" json:"exchangeRate,omitempty"` // 1 base unit = ExchangeRate units of Currency
+	BaseTotalPrice Money  `bson:"baseTotalPrice,omitempty" json:"baseTotalPrice"`
+	BaseTotalNet   Money  `bson:"baseTotalNet,omitempty" json:"baseTotalNet"`
+	BaseTotalTax   Money  `bson:"baseTotalTax,omitempty" json:"baseTotalTax"`
+	// Promotions applied, TotalPrice is Subtotal - TotalDiscount
+	Subtotal      Money                   `bson:"subtotal,omitempty" json:"subtotal"`
+	TotalDiscount Money                   `bson:"totalDiscount,omitempty" json:"totalDiscount"`
+	Discounts     []AppliedDiscountStruct `bson:"discounts,omitempty" json:"discounts,omitempty"`
+	// Loyalty points spent on the order, part of TotalDiscount, and earned once it is paid
+	LoyaltyPointsRedeemed int64       `bson:"loyaltyPointsRedeemed,omitempty" json:"loyaltyPointsRedeemed"`
+	LoyaltyDiscount       Money       `bson:"loyaltyDiscount,omitempty" json:"loyaltyDiscount"`
+	LoyaltyPointsEarned   int64       `bson:"loyaltyPointsEarned,omitempty" json:"loyaltyPointsEarned"`
+	Order                 OrderStruct `bson:"order" json:"order"` // Embedded order details
+	// A pending order is expired if it is not paid by then
+	ExpiresAt string `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	// Set once the order is paid and the invoice issued, they never change afterwards
+	Number   string             `bson:"number,omitempty" json:"number,omitempty"` // e.g. 2026-000042, sequential per year
+	IssuedAt string             `bson:"issuedAt,omitempty" json:"issuedAt,omitempty"`
+	Seller   InvoicePartyStruct `bson:"seller,omitempty" json:"seller,omitempty"`
+	Buyer    InvoicePartyStruct `bson:"buyer,omitempty" json:"buyer,omitempty"`
+	PdfKey   string             `bson:"pdfKey,omitempty" json:"-"` // Rendered PDF in the blob store
+	Archived bool               `bson:"archived" json:"archived"`
+}
+
+// InvoicePartyStruct is the seller or the buyer named on an issued invoice
+type InvoicePartyStruct struct {
+	Name       string `bson:"name" json:"name"`
+	Address    string `bson:"address" json:"address"`
+	PostalCode string `bson:"postalCode" json:"postalCode"`
+	City       string `bson:"city" json:"city"`
+	Country    string `bson:"country" json:"country"`
+	Email      string `bson:"email,omitempty" json:"email,omitempty"`
+	VatNumber  string `bson:"vatNumber,omitempty" json:"vatNumber,omitempty"`
+	CompanyId  string `bson:"companyId,omitempty" json:"companyId,omitempty"` // e.g. SIRET
+}
+
+// IsZero leaves the parties out of invoices not issued yet
+func (p InvoicePartyStruct) IsZero() bool {
+	return p == InvoicePartyStruct{}
 }
 
 type InvoiceOrderStruct struct {
-	Id         string                  `bson:"_id,omitempty" json:"id"`
-	Date       string                  `bson:"date" json:"date"`
-	TotalPrice float64                 `bson:"totalPrice" json:"totalPrice"`
-	Order      OrderWithProductDetails `bson:"order" json:"order"`
-	Archived   bool                    `bson:"archived" json:"archived"`
+	Id                    string                  `bson:"_id,omitempty" json:"id"`
+	Date                  string                  `bson:"date" json:"date"`
+	TotalPrice            Money                   `bson:"totalPrice" json:"totalPrice"`
+	TotalNet              Money                   `bson:"totalNet" json:"totalNet"`
+	TotalTax              Money                   `bson:"totalTax" json:"totalTax"`
+	TaxBreakdown          []TaxBreakdownStruct    `bson:"taxBreakdown" json:"taxBreakdown"`
+	Currency              string                  `bson:"currency,omitempty" json:"currency,omitempty"`
+	ExchangeRate          string                  `bson:"exchangeRate,omitempty" json:"exchangeRate,omitempty"`
+	BaseTotalPrice        Money                   `bson:"baseTotalPrice,omitempty" json:"baseTotalPrice"`
+	BaseTotalNet          Money                   `bson:"baseTotalNet,omitempty" json:"baseTotalNet"`
+	BaseTotalTax          Money                   `bson:"baseTotalTax,omitempty" json:"baseTotalTax"`
+	Subtotal              Money                   `bson:"subtotal,omitempty" json:"subtotal"`
+	TotalDiscount         Money                   `bson:"totalDiscount,omitempty" json:"totalDiscount"`
+	Discounts             []AppliedDiscountStruct `bson:"discounts,omitempty" json:"discounts,omitempty"`
+	LoyaltyPointsRedeemed int64                   `bson:"loyaltyPointsRedeemed,omitempty" json:"loyaltyPointsRedeemed"`
+	LoyaltyDiscount       Money                   `bson:"loyaltyDiscount,omitempty" json:"loyaltyDiscount"`
+	LoyaltyPointsEarned   int64                   `bson:"loyaltyPointsEarned,omitempty" json:"loyaltyPointsEarned"`
+	Order                 OrderWithProductDetails `bson:"order" json:"order"`
+	ExpiresAt             string                  `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	Number                string                  `bson:"number,omitempty" json:"number,omitempty"`
+	IssuedAt              string                  `bson:"issuedAt,omitempty" json:"issuedAt,omitempty"`
+	Seller                InvoicePartyStruct      `bson:"seller,omitempty" json:"seller,omitempty"`
+	Buyer                 InvoicePartyStruct      `bson:"buyer,omitempty" json:"buyer,omitempty"`
+	Archived              bool                    `bson:"archived" json:"archived"`
 }
 
 type UserInvoiceSummary struct {
-	Date       string  `bson:"date" json:"date"`
-	TotalPrice float64 `bson:"totalPrice" json:"totalPrice"`
-	ID         string  `bson:"_id" json:"id"`
+	Date       string `bson:"date" json:"date"`
+	TotalPrice Money  `bson:"totalPrice" json:"totalPrice"`
+	Currency   string `bson:"currency,omitempty" json:"currency,omitempty"`
+	ID         string `bson:"_id" json:"id"`
 }
@@ -0,0 +1,29 @@
+package entities
+
+// LoyaltyRuleStruct earns points on paid orders. A rule without product nor category applies
+// to every line, the points of the rules matching a line add up.
+type LoyaltyRuleStruct struct {
+	Id            string `bson:"_id,omitempty" json:"id"`
+	Name          string `bson:"name" json:"name" validate:"required"`
+	PointsPerUnit int64  `bson:"pointsPerUnit" json:"points_per_unit" validate:"gt=0"` // Points per whole unit of the base currency spent
+	ProductId     string `bson:"productId,omitempty" json:"product_id,omitempty"`
+	CategoryId    string `bson:"categoryId,omitempty" json:"category_id,omitempty"` // Along with its sub-categories
+	Active        bool   `bson:"active" json:"active"`
+}
+
+// LoyaltyEntryStruct is a movement of the loyalty ledger of a user
+type LoyaltyEntryStruct struct {
+	Id          string `bson:"_id,omitempty" json:"id"`
+	UserId      string `bson:"userId" json:"userId"`
+	Type        string `bson:"type" json:"type"`     // earn, redeem, release (order cancelled), reclaim (expired order paid), revoke and restore (order refunded)
+	Points      int64  `bson:"points" json:"points"` // Negative when points are spent or taken back
+	InvoiceId   string `bson:"invoiceId,omitempty" json:"invoiceId,omitempty"`
+	Description string `bson:"description" json:"description"`
+	CreatedAt   string `bson:"createdAt" json:"createdAt"`
+}
+
+type LoyaltyAccountStruct struct {
+	Balance    int64                `json:"balance"`
+	PointValue Money                `json:"pointValue"` // Discount given by one point
+	History    []LoyaltyEntryStruct `json:"history"`
+}
@@ -0,0 +1,30 @@
+package entities
+
+import "time"
+
+// MailStruct is an email of the outbox. It is rendered when queued and sent in the
+// background, failed attempts are retried later until MAIL_MAX_ATTEMPTS.
+type MailStruct struct {
+	Id            string                 `bson:"_id" json:"id"` // e.g. payment_receipt:<invoice ID>, an email is queued once
+	UserId        string                 `bson:"userId" json:"userId"`
+	To            string                 `bson:"to" json:"to"`
+	Template      string                 `bson:"template" json:"template"`
+	Subject       string                 `bson:"subject" json:"subject"`
+	Text          string                 `bson:"text" json:"text"`
+	Html          string                 `bson:"html" json:"html"`
+	Attachments   []MailAttachmentStruct `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Status        string                 `bson:"status" json:"status"` // pending, sent or failed
+	Attempts      int                    `bson:"attempts" json:"attempts"`
+	LastError     string                 `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	NextAttemptAt time.Time              `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LockedUntil   time.Time              `bson:"lockedUntil,omitempty" json:"-"` // Set while an attempt is running
+	CreatedAt     time.Time              `bson:"createdAt" json:"createdAt"`
+	SentAt        time.Time              `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+}
+
+// MailAttachmentStruct is a file of the blob store attached to an email when it is sent
+type MailAttachmentStruct struct {
+	Filename    string `bson:"filename" json:"filename"`
+	ContentType string `bson:"contentType" json:"contentType"`
+	BlobKey     string `bson:"blobKey" json:"blobKey"`
+}
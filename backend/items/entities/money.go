@@ -0,0 +1,227 @@
+package entities
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// DefaultCurrency is the base currency, set from BASE_CURRENCY at startup. Catalog prices and
+// stats are in it, and so are the amounts stored or received without a currency.
+var DefaultCurrency = "EUR"
+
+// Money is an amount in minor units (cents) of an ISO 4217 currency.
+//
+// In MongoDB it is stored as {amount, currency}; documents written before it existed hold
+// plain decimal numbers, which are still read (as DefaultCurrency). In JSON it is written as
+// a decimal number (9.99) so API clients are unaffected, and read from a number, a decimal
+// string or an {amount, currency} object.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// MoneyFromFloat converts a decimal amount, rounding to the nearest cent.
+// Only meant for values that were floats to begin with, use ParseMoney for user input.
+func MoneyFromFloat(value float64, currency string) Money {
+	return Money{Amount: int64(math.Round(value * 100)), Currency: currency}
+}
+
+// ParseMoney reads a decimal amount such as "9.99", "9,99" or "-3" exactly
+func ParseMoney(value string, currency string) (Money, error) {
+	value = strings.TrimSpace(strings.Replace(value, ",", ".", 1))
+	if value == "" {
+		return Money{}, fmt.Errorf("empty amount")
+	}
+
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	units, fraction, _ := strings.Cut(value, ".")
+	if units == "" {
+		units = "0"
+	}
+	if len(fraction) > 2 {
+		// Only zeros may follow the cents
+		if strings.Trim(fraction[2:], "0") != "" {
+			return Money{}, fmt.Errorf("invalid amount %q: more than 2 decimals", value)
+		}
+		fraction = fraction[:2]
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	whole, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || strings.ContainsAny(units, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	cents, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || strings.ContainsAny(fraction, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+
+	amount := whole*100 + cents
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+// Add sums two amounts of the same currency, amounts in different ones must be converted first
+func (m Money) Add(other Money) (Money, error) {
+	if m.currency() != other.currency() {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other, m)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.currency()}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if m.currency() != other.currency() {
+		return Money{}, fmt.Errorf("cannot subtract %s from %s", other, m)
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.currency()}, nil
+}
+
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.currency()}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Equal compares amounts and currencies, an empty currency being the default one
+func (m Money) Equal(other Money) bool {
+	return m.Amount == other.Amount && m.currency() == other.currency()
+}
+
+// Float64 is meant for display and ratios only, never for sums
+func (m Money) Float64() float64 {
+	return float64(m.Amount) / 100
+}
+
+// Decimal formats the amount with two decimals, e.g. "9.99"
+func (m Money) Decimal() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency()
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.Decimal()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*m = Money{}
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var object struct {
+			Amount   int64  `json:"amount"`
+			Currency string `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return fmt.Errorf("invalid amount: %v", err)
+		}
+		*m = Money{Amount: object.Amount, Currency: object.Currency}
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Errorf("invalid amount: %v", err)
+		}
+		parsed, err := ParseMoney(value, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	default:
+		// Numbers are parsed from their text so 0.1 + 0.2 style errors cannot creep in
+		if bytes.ContainsAny(data, "eE") {
+			value, err := strconv.ParseFloat(string(data), 64)
+			if err != nil {
+				return fmt.Errorf("invalid amount %s", data)
+			}
+			*m = MoneyFromFloat(value, DefaultCurrency)
+			return nil
+		}
+		parsed, err := ParseMoney(string(data), DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(struct {
+		Amount   int64  `bson:"amount"`
+		Currency string `bson:"currency"`
+	}{m.Amount, m.currency()})
+}
+
+// UnmarshalBSONValue reads both the {amount, currency} documents and the legacy decimal numbers
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bson.TypeEmbeddedDocument:
+		var stored struct {
+			Amount   bson.RawValue `bson:"amount"`
+			Currency string        `bson:"currency"`
+		}
+		if err := raw.Unmarshal(&stored); err != nil {
+			return err
+		}
+		amount, ok := stored.Amount.AsInt64OK()
+		if !ok {
+			return fmt.Errorf("invalid stored amount of type %s", stored.Amount.Type)
+		}
+		*m = Money{Amount: amount, Currency: stored.Currency}
+	case bson.TypeDouble:
+		*m = MoneyFromFloat(raw.Double(), DefaultCurrency)
+	case bson.TypeInt32:
+		*m = Money{Amount: int64(raw.Int32()) * 100, Currency: DefaultCurrency}
+	case bson.TypeInt64:
+		*m = Money{Amount: raw.Int64() * 100, Currency: DefaultCurrency}
+	case bson.TypeDecimal128:
+		parsed, err := ParseMoney(raw.Decimal128().String(), DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case bson.TypeNull, bson.TypeUndefined:
+		*m = Money{}
+	default:
+		return fmt.Errorf("cannot decode %s into Money", t)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package entities
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		value string
+		want  int64
+	}{
+		{"9.99", 999},
+		{"9,99", 999},
+		{"-3", -300},
+		{"-0,5", -50},
+		{" 12 ", 1200},
+		{".5", 50},
+		{"+2.10", 210},
+		{"1.500", 150},
+		{"0", 0},
+	}
+	for _, c := range cases {
+		got, err := ParseMoney(c.value, "EUR")
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", c.value, err)
+			continue
+		}
+		if want := NewMoney(c.want, "EUR"); got != want {
+			t.Errorf("ParseMoney(%q) = %v, want %v", c.value, got, want)
+		}
+	}
+}
+
+func TestParseMoneyRejectsInvalidAmounts(t *testing.T) {
+	for _, value := range []string{"", "1.999", "abc", "--1", "1,000.50", "1.-5"} {
+		if got, err := ParseMoney(value, "EUR"); err == nil {
+			t.Errorf("ParseMoney(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+func TestMoneyAddRefusesOtherCurrencies(t *testing.T) {
+	if _, err := NewMoney(100, "EUR").Add(NewMoney(100, "USD")); err == nil {
+		t.Error("added USD to EUR, want an error")
+	}
+	if _, err := NewMoney(100, "EUR").Sub(NewMoney(100, "USD")); err == nil {
+		t.Error("subtracted USD from EUR, want an error")
+	}
+
+	sum, err := NewMoney(100, "").Add(NewMoney(250, DefaultCurrency))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := NewMoney(350, DefaultCurrency); sum != want {
+		t.Errorf("got %v, want %v", sum, want)
+	}
+}
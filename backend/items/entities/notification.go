@@ -0,0 +1,96 @@
+package entities
+
+import "time"
+
+// Types of push notifications, users opt in to each of them separately
+const (
+	NotificationMarketing     = "marketing"     // Promotions and news, only sent to users who opted in
+	NotificationTransactional = "transactional" // About an order of the user, sent unless they opted out
+)
+
+// NotificationTargetStruct selects the users a notification is sent to. A user must match
+// every criterion given, and any of the values of a criterion. Without criteria, every user
+// is targeted.
+type NotificationTargetStruct struct {
+	UserIds     []string `bson:"userIds,omitempty" json:"userIds,omitempty"`
+	Roles       []string `bson:"roles,omitempty" json:"roles,omitempty"`             // Role names, e.g. user
+	Cities      []string `bson:"cities,omitempty" json:"cities,omitempty"`           // City names
+	CategoryIds []string `bson:"categoryIds,omitempty" json:"categoryIds,omitempty"` // Bought a product of the category or of a subcategory
+	Segments    []string `bson:"segments,omitempty" json:"segments,omitempty"`       // Custom segments set by admins, see UserStruct.Segments
+}
+
+func (t NotificationTargetStruct) IsZero() bool {
+	return len(t.UserIds) == 0 && len(t.Roles) == 0 && len(t.Cities) == 0 && len(t.CategoryIds) == 0 && len(t.Segments) == 0
+}
+
+type NotificationRequestStruct struct {
+	Title  string                   `bson:"title" json:"title" validate:"required"`
+	Body   string                   `bson:"body" json:"body" validate:"required"`
+	Type   string                   `bson:"type" json:"type" validate:"omitempty,oneof=marketing transactional"` // marketing by default
+	Target NotificationTargetStruct `bson:"target" json:"target"`
+	Data   map[string]string        `bson:"data,omitempty" json:"data,omitempty"` // Passed on to the app
+}
+
+// NotificationPreferencesStruct are the notifications a user agreed to receive
+type NotificationPreferencesStruct struct {
+	Marketing     bool   `bson:"marketing" json:"marketing"`
+	Transactional bool   `bson:"transactional" json:"transactional"`
+	Locale        string `bson:"locale,omitempty" json:"locale,omitempty"` // Language of campaigns, e.g. fr or en-GB
+}
+
+// DefaultNotificationPreferences apply to users who never set theirs
+var DefaultNotificationPreferences = NotificationPreferencesStruct{Marketing: false, Transactional: true}
+
+// NotificationStruct is a notification sent to a user, kept as their history
+type NotificationStruct struct {
+	Id         string            `bson:"_id,omitempty" json:"id"`
+	DispatchId string            `bson:"dispatchId" json:"dispatchId"`
+	CampaignId string            `bson:"campaignId,omitempty" json:"campaignId,omitempty"`
+	UserId     string            `bson:"userId" json:"userId"`
+	Title      string            `bson:"title" json:"title"`
+	Body       string            `bson:"body" json:"body"`
+	Type       string            `bson:"type" json:"type"`
+	Data       map[string]string `bson:"data,omitempty" json:"data,omitempty"`
+	Status     string            `bson:"status" json:"status"` // pending, then sent once it reached a device, or failed
+	Error      string            `bson:"error,omitempty" json:"error,omitempty"`
+	SentAt     time.Time         `bson:"sentAt" json:"sentAt"`                         // When it was requested
+	OpenedAt   time.Time         `bson:"openedAt,omitempty" json:"openedAt,omitempty"` // Reported by the app
+}
+
+// NotificationDispatchStruct follows the delivery of a notification to its target. Each device
+// of the users who agreed to receive it is sent a message by a job.
+type NotificationDispatchStruct struct {
+	Id          string                    `bson:"_id,omitempty" json:"id"`
+	CampaignId  string                    `bson:"campaignId,omitempty" json:"campaignId,omitempty"`
+	Request     NotificationRequestStruct `bson:"request" json:"request"`
+	Status      string                    `bson:"status" json:"status"`                   // sending, completed, or failed if it could not be queued
+	Error       string                    `bson:"error,omitempty" json:"error,omitempty"` // Why it failed
+	Targeted    int                       `bson:"targeted" json:"targeted"`               // Users matching the target
+	OptedOut    int                       `bson:"optedOut" json:"optedOut"`               // Users who did not agree to this type of notification
+	NoDevice    int                       `bson:"noDevice" json:"noDevice"`               // Users without a registered device
+	Devices     int                       `bson:"devices" json:"devices"`                 // Messages to send, one per device
+	Sent        int                       `bson:"sent" json:"sent"`
+	Failed      int                       `bson:"failed" json:"failed"` // After every retry
+	Pruned      int                       `bson:"pruned" json:"pruned"` // Failed devices removed because FCM no longer accepts their token
+	CreatedAt   time.Time                 `bson:"createdAt" json:"createdAt"`
+	CompletedAt time.Time                 `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// NotificationJobStruct is the message of a dispatch to one device, in the queue the workers
+// send from. Failed attempts are retried later until NOTIFICATION_MAX_ATTEMPTS.
+type NotificationJobStruct struct {
+	Id             string            `bson:"_id,omitempty" json:"id"`
+	DispatchId     string            `bson:"dispatchId" json:"dispatchId"`
+	NotificationId string            `bson:"notificationId" json:"notificationId"` // Entry of the user history
+	UserId         string            `bson:"userId" json:"userId"`
+	Token          string            `bson:"token" json:"-"`
+	Title          string            `bson:"title" json:"title"`
+	Body           string            `bson:"body" json:"body"`
+	Data           map[string]string `bson:"data,omitempty" json:"data,omitempty"`
+	Status         string            `bson:"status" json:"status"` // pending, sent or failed
+	Attempts       int               `bson:"attempts" json:"attempts"`
+	LastError      string            `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	NextAttemptAt  time.Time         `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LockedUntil    time.Time         `bson:"lockedUntil,omitempty" json:"-"` // Set while a worker sends it
+	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,28 @@
+package entities
+
+type NutrientsStruct struct {
+	EnergyKcal    float64 `bson:"energyKcal" json:"energy_kcal"`
+	EnergyKj      float64 `bson:"energyKj" json:"energy_kj"`
+	Fat           float64 `bson:"fat" json:"fat"`
+	SaturatedFat  float64 `bson:"saturatedFat" json:"saturated_fat"`
+	Carbohydrates float64 `bson:"carbohydrates" json:"carbohydrates"`
+	Sugars        float64 `bson:"sugars" json:"sugars"`
+	Fibre         float64 `bson:"fibre" json:"fibre"`
+	Proteins      float64 `bson:"proteins" json:"proteins"`
+	Salt          float64 `bson:"salt" json:"salt"`
+}
+
+type NutritionStruct struct {
+	ServingSize string           `bson:"servingSize,omitempty" json:"serving_size,omitempty"` // e.g. "30 g"
+	Per100g     NutrientsStruct  `bson:"per100g" json:"per_100g"`
+	PerServing  *NutrientsStruct `bson:"perServing,omitempty" json:"per_serving,omitempty"`
+}
+
+// ProductFilterStruct narrows a product search on its nutrition facts
+type ProductFilterStruct struct {
+	AllergenFree []string // e.g. "gluten", "milk"
+	NutriScores  []string // accepted grades, e.g. "a", "b"
+	MaxNova      int      // 1 to 4, 0 means no limit
+	Labels       []string // required labels, e.g. "organic", "vegan"
+	CategoryId   string
+}
@@ -7,16 +7,27 @@ import (
 type OrderStruct struct {
 	Id            string               `bson:"_id,omitempty" json:"id"`
 	Date          time.Time            `bson:"date" json:"date"`
-	Status        string               `bson:"status" json:"status"` // e.g., "pending", "paid", "cancelled"
+	Status        string               `bson:"status" json:"status"` // e.g., "pending", "paid", "ready", "cancelled"
 	Products      []OrderProductStruct `bson:"products" json:"products"`
 	PaymentMethod string               `bson:"paymentMethod" json:"paymentMethod"` // e.g., "PAYPAL"
 	PaymentInfo   PaymentInfo          `bson:"paymentInfo" json:"paymentInfo"`     // PayPal payment details
 }
 
 type OrderProductStruct struct {
-	ProductId string  `bson:"productId" json:"productId"`
-	Quantity  int     `bson:"quantity" json:"quantity"`
-	Price     float64 `bson:"price" json:"price"`
+	ProductId string `bson:"productId" json:"productId"`
+	Name      string `bson:"name,omitempty" json:"name,omitempty"` // Product name when the order was placed
+	Quantity  int    `bson:"quantity" json:"quantity"`
+	Price     Money  `bson:"price" json:"price"`         // Total price for this line (UnitPrice * Quantity - Discount)
+	UnitPrice Money  `bson:"unitPrice" json:"unitPrice"` // Product PriceVat when the order was placed
+	VatRateId string `bson:"vatRateId" json:"vatRateId"`
+	VatRate   int64  `bson:"vatRate" json:"vatRate"` // basis points, 2000 = 20%
+	Net       Money  `bson:"net" json:"net"`
+	Tax       Money  `bson:"tax" json:"tax"`
+	Gross     Money  `bson:"gross" json:"gross"`                   // Same as Price, net + tax
+	BasePrice Money  `bson:"basePrice,omitempty" json:"basePrice"` // Price in the base currency
+	// Promotions applied to this line, see InvoiceStruct.Discounts
+	Discount     Money    `bson:"discount,omitempty" json:"discount"`
+	PromotionIds []string `bson:"promotionIds,omitempty" json:"promotionIds,omitempty"`
 }
 
 type OrderWithProductDetails struct {
@@ -28,9 +39,18 @@ type OrderWithProductDetails struct {
 }
 
 type OrderProductWithDetails struct {
-	Product  ProductOrder `bson:"product" json:"product"` // Full product details
-	Quantity int          `bson:"quantity" json:"quantity"`
-	Price    float64      `bson:"price" json:"price"` // Total price for this product (PriceNot * Quantity)
+	Product      ProductOrder `bson:"product" json:"product"` // Full product details
+	Quantity     int          `bson:"quantity" json:"quantity"`
+	Price        Money        `bson:"price" json:"price"` // Total price for this product (PriceNot * Quantity)
+	UnitPrice    Money        `bson:"unitPrice" json:"unitPrice"`
+	VatRateId    string       `bson:"vatRateId" json:"vatRateId"`
+	VatRate      int64        `bson:"vatRate" json:"vatRate"` // basis points
+	Net          Money        `bson:"net" json:"net"`
+	Tax          Money        `bson:"tax" json:"tax"`
+	Gross        Money        `bson:"gross" json:"gross"`
+	BasePrice    Money        `bson:"basePrice,omitempty" json:"basePrice"`
+	Discount     Money        `bson:"discount,omitempty" json:"discount"`
+	PromotionIds []string     `bson:"promotionIds,omitempty" json:"promotionIds,omitempty"`
 }
 
 // PaymentLink represents a link returned by PayPal (e.g., approval URL)
@@ -46,18 +66,32 @@ type PaymentInfo struct {
 	Status         string `bson:"status,omitempty"`
 }
 
-func (o *OrderStruct) GetTotalPrice() float64 {
-	var total float64
-	for _, product := range o.Products {
-		total += product.Price
+func (o *OrderStruct) GetTotalPrice() (Money, error) {
+	var total Money
+	for i, product := range o.Products {
+		if i == 0 {
+			total = product.Price
+			continue
+		}
+		var err error
+		if total, err = total.Add(product.Price); err != nil {
+			return Money{}, err
+		}
 	}
-	return total
+	return total, nil
 }
 
-func (o *OrderWithProductDetails) GetTotalPrice() float64 {
-	var total float64
-	for _, product := range o.Products {
-		total += product.Price
+func (o *OrderWithProductDetails) GetTotalPrice() (Money, error) {
+	var total Money
+	for i, product := range o.Products {
+		if i == 0 {
+			total = product.Price
+			continue
+		}
+		var err error
+		if total, err = total.Add(product.Price); err != nil {
+			return Money{}, err
+		}
 	}
-	return total
+	return total, nil
 }
@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"time"
+)
+
+// PriceHistoryStruct is one price of a product, either already applied or scheduled for later
+type PriceHistoryStruct struct {
+	Id          string    `bson:"_id,omitempty" json:"id"`
+	ProductId   string    `bson:"productId" json:"product_id"`
+	PriceVat    Money     `bson:"priceVat" json:"price_vat"`
+	PriceNot    Money     `bson:"priceNot" json:"price_not"`
+	EffectiveAt time.Time `bson:"effectiveAt" json:"effective_at"`
+	Status      string    `bson:"status" json:"status"`                            // scheduled, applying, applied, cancelled, failed
+	LastError   string    `bson:"lastError,omitempty" json:"last_error,omitempty"` // Why a scheduled change could not be applied
+	LockedUntil time.Time `bson:"lockedUntil,omitempty" json:"-"`                  // While the scheduler applies it
+	Source      string    `bson:"source" json:"source"`                            // initial, manual, import, schedule
+	CreatedBy   string    `bson:"createdBy,omitempty" json:"created_by,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt" json:"created_at"`
+}
+
+type PriceChangeStruct struct {
+	PriceVat    Money  `json:"price_vat" validate:"required,gt=0"` // The price without VAT follows from the VAT rate
+	EffectiveAt string `json:"effective_at"`                       // RFC3339, applied immediately when empty or in the past
+}
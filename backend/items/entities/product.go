@@ -1,39 +1,55 @@
 package entities
 
 type ProductStruct struct {
-	Id                     string       `bson:"_id,omitempty" json:"id" validate:"required"`
-	Reference              string       `bson:"reference" json:"reference" validate:"required"`
-	Images                 ImagesStruct `bson:"images,omitempty" json:"images"`
-	PriceVat               float64      `bson:"priceVat" json:"price_vat" validate:"required"`
-	PriceNot               float64      `bson:"priceNot" json:"price_not"`
-	StockQuantity          float64      `bson:"stockQuantity" json:"stock_quantity"`
-	Name                   string       `bson:"name" json:"name" validate:"required"`
-	Brand                  string       `bson:"brand" json:"brand"`
-	Category               string       `bson:"category" json:"category"`
-	NutritionalInformation string       `bson:"nutritionalInformation" json:"nutritional_information"`
-	Archived               bool         `bson:"archived" json:"archived"`
+	Id                     string          `bson:"_id,omitempty" json:"id" validate:"required"`
+	Reference              string          `bson:"reference" json:"reference" validate:"required"`
+	Images                 ImagesStruct    `bson:"images,omitempty" json:"images"`
+	PriceVat               Money           `bson:"priceVat" json:"price_vat" validate:"required"`
+	PriceNot               Money           `bson:"priceNot" json:"price_not"`
+	StockQuantity          float64         `bson:"stockQuantity" json:"stock_quantity"`
+	Name                   string          `bson:"name" json:"name" validate:"required"`
+	Brand                  string          `bson:"brand" json:"brand"`
+	Category               string          `bson:"category" json:"category"`
+	CategoryIds            []string        `bson:"categoryIds" json:"category_ids"`
+	NutritionalInformation string          `bson:"nutritionalInformation" json:"nutritional_information"`
+	Nutrition              NutritionStruct `bson:"nutrition" json:"nutrition"`
+	Allergens              []string        `bson:"allergens" json:"allergens"`                        // e.g. "gluten", "milk", null when unknown
+	NutriScore             string          `bson:"nutriScore,omitempty" json:"nutri_score,omitempty"` // a to e
+	NovaGroup              int             `bson:"novaGroup,omitempty" json:"nova_group,omitempty"`   // 1 to 4
+	Ingredients            string          `bson:"ingredients,omitempty" json:"ingredients,omitempty"`
+	Labels                 []string        `bson:"labels" json:"labels"`                                    // e.g. "organic", "vegan"
+	CatalogSource          string          `bson:"catalogSource,omitempty" json:"catalog_source,omitempty"` // openfoodfacts, manual, pending
+	CatalogSyncedAt        string          `bson:"catalogSyncedAt,omitempty" json:"catalog_synced_at,omitempty"`
+	VatRateId              string          `bson:"vatRateId,omitempty" json:"vat_rate_id,omitempty"` // Overrides the category VAT rate
+	Currency               string          `bson:"-" json:"currency,omitempty"`                      // Currency of the prices returned, see ConvertProductPrices
+	Archived               bool            `bson:"archived" json:"archived"`
 }
 
 type ProductOrder struct {
 	Id                     string       `bson:"_id,omitempty" json:"id" validate:"required"`
 	Reference              string       `bson:"reference" json:"reference" validate:"required"`
 	Images                 ImagesStruct `bson:"images,omitempty" json:"images"`
-	PriceVat               float64      `bson:"priceVat" json:"price_vat" validate:"required"`
-	PriceNot               float64      `bson:"priceNot" json:"price_not"`
+	PriceVat               Money        `bson:"priceVat" json:"price_vat" validate:"required"`
+	PriceNot               Money        `bson:"priceNot" json:"price_not"`
 	Name                   string       `bson:"name" json:"name" validate:"required"`
 	Brand                  string       `bson:"brand" json:"brand"`
 	Category               string       `bson:"category" json:"category"`
+	CategoryIds            []string     `bson:"categoryIds" json:"category_ids"`
 	NutritionalInformation string       `bson:"nutritionalInformation" json:"nutritional_information"`
 }
 
 type ProductBasic struct {
 	Reference     string  `json:"reference" validate:"required"`
-	PriceVat      float64 `json:"price_vat" validate:"required"`
-	PriceNot      float64 `json:"price_not" validate:"required"`
+	PriceVat      Money   `json:"price_vat" validate:"required"` // The price without VAT follows from the VAT rate
 	StockQuantity float64 `json:"stock_quantity" validate:"required"`
+	// Manual details, used when no catalog knows the reference
+	Name     string `json:"name,omitempty"`
+	Brand    string `json:"brand,omitempty"`
+	Category string `json:"category,omitempty"`
 }
 
 type StatsProduct struct {
+	Id    string `bson:"_id" json:"id"`
 	Name  string `json:"name"`
 	Total int32  `json:"total"`
 }
@@ -0,0 +1,35 @@
+package entities
+
+// ProductImportRow is one line of a bulk product import or export (CSV or JSON)
+type ProductImportRow struct {
+	Reference     string  `json:"reference"`
+	PriceVat      Money   `json:"price_vat"`
+	PriceNot      Money   `json:"price_not"` // Exported only, imports derive it from the VAT rate
+	StockQuantity float64 `json:"stock_quantity"`
+	Name          string  `json:"name,omitempty"`
+	Brand         string  `json:"brand,omitempty"`
+	Category      string  `json:"category,omitempty"`
+	Line          int     `json:"-"` // position in the import file, for error reporting
+}
+
+type ImportRowResult struct {
+	Line      int    `bson:"line" json:"line"`
+	Reference string `bson:"reference" json:"reference"`
+	Action    string `bson:"action" json:"action"` // created, updated, unarchived, failed
+	Error     string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+type ImportJobStruct struct {
+	Id         string            `bson:"_id,omitempty" json:"id"`
+	Format     string            `bson:"format" json:"format"` // csv, json
+	DryRun     bool              `bson:"dryRun" json:"dry_run"`
+	Status     string            `bson:"status" json:"status"` // pending, running, completed, failed
+	Total      int               `bson:"total" json:"total"`
+	Succeeded  int               `bson:"succeeded" json:"succeeded"`
+	Failed     int               `bson:"failed" json:"failed"`
+	Rows       []ImportRowResult `bson:"rows" json:"rows"`
+	Error      string            `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedBy  string            `bson:"createdBy" json:"created_by"`
+	CreatedAt  string            `bson:"createdAt" json:"created_at"`
+	FinishedAt string            `bson:"finishedAt,omitempty" json:"finished_at,omitempty"`
+}
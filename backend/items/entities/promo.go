@@ -4,16 +4,25 @@ type PromotStruct struct {
 	Id            string         `json:"id" bson:"_id,omitempty"`
 	Name          string         `json:"name" bson:"name"`
 	Description   string         `json:"description" bson:"description"`
-	DiscountType  string         `json:"discount_type" bson:"discount_type"` // percentage, fixed, bogo
-	DiscountValue float64        `json:"discount_value" bson:"discount_value"`
-	Code          string         `json:"code" bson:"code"`
+	DiscountType  string         `json:"discount_type" bson:"discount_type"`   // percentage, fixed, bogo
+	DiscountValue int64          `json:"discount_value" bson:"discount_value"` // basis points (2000 = 20%), or minor units of the base currency for fixed discounts
+	Code          string         `json:"code" bson:"code"`                     // Promotions without code are applied automatically
 	StartDate     string         `json:"start_date" bson:"start_date"`
 	EndDate       string         `json:"end_date" bson:"end_date"`
-	Products      []ProductOrder `json:"products" bson:"products"`
-	MinPurchase   float64        `json:"min_purchase" bson:"min_purchase"`
-	Status        string         `json:"status" bson:"status"` // active, inactive, expired
-	CreatedAt     string         `json:"created_at" bson:"created_at"`
-	UpdatedAt     string         `json:"updated_at" bson:"updated_at"`
+	Products      []ProductOrder `json:"products" bson:"products"`                  // Every product when no product nor category is targeted
+	CategoryIds   []string       `json:"category_ids" bson:"categoryIds,omitempty"` // Products of these categories and their sub-categories
+	MinPurchase   Money          `json:"min_purchase" bson:"min_purchase"`          // Cart total before discounts, in the base currency
+	Status        string         `json:"status" bson:"status"`                      // scheduled, active, inactive (paused), expired
+	// Eligibility, limits of 0 mean unlimited
+	UsageLimit        int            `json:"usage_limit" bson:"usageLimit,omitempty"`                 // Redemptions allowed overall
+	UsageLimitPerUser int            `json:"usage_limit_per_user" bson:"usageLimitPerUser,omitempty"` // Redemptions allowed per user
+	RedemptionCount   int            `json:"redemption_count" bson:"redemptionCount"`                 // Orders that used the promotion, counted once created
+	UserRedemptions   map[string]int `json:"-" bson:"userRedemptions,omitempty"`                      // RedemptionCount per user ID
+	FirstOrderOnly    bool           `json:"first_order_only" bson:"firstOrderOnly,omitempty"`        // Only for users without a paid order yet
+	Segments          []string       `json:"segments" bson:"segments,omitempty"`                      // Only for users in one of these segments
+	MinLoyaltyPoints  int64          `json:"min_loyalty_points" bson:"minLoyaltyPoints,omitempty"`    // Only for users with at least this balance
+	CreatedAt         string         `json:"created_at" bson:"created_at"`
+	UpdatedAt         string         `json:"updated_at" bson:"updated_at"`
 }
 
 type PromoResponse struct {
@@ -21,10 +30,66 @@ type PromoResponse struct {
 	Name          string         `json:"name" bson:"name"`
 	Description   string         `json:"description" bson:"description"`
 	DiscountType  string         `json:"discount_type" bson:"discount_type"` // percentage, fixed, bogo
-	DiscountValue float64        `json:"discount_value" bson:"discount_value"`
+	DiscountValue int64          `json:"discount_value" bson:"discount_value"`
 	Code          string         `json:"code" bson:"code"`
 	StartDate     string         `json:"start_date" bson:"start_date"`
 	EndDate       string         `json:"end_date" bson:"end_date"`
 	Products      []ProductOrder `json:"products" bson:"products"`
-	MinPurchase   float64        `json:"min_purchase" bson:"min_purchase"`
+	CategoryIds   []string       `json:"category_ids" bson:"categoryIds,omitempty"`
+	MinPurchase   Money          `json:"min_purchase" bson:"min_purchase"`
+}
+
+// PromotionInputStruct creates or edits a promotion, products and categories are targeted by ID
+type PromotionInputStruct struct {
+	Name              string   `json:"name" validate:"required"`
+	Description       string   `json:"description"`
+	DiscountType      string   `json:"discount_type" validate:"required,oneof=percentage fixed bogo"`
+	DiscountValue     int64    `json:"discount_value" validate:"gte=0"` // basis points off, cents off, or basis points off the second unit for bogo
+	Code              string   `json:"code"`                            // Leave empty for a promotion applied automatically
+	StartDate         string   `json:"start_date" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	EndDate           string   `json:"end_date" validate:"required,datetime=2006-01-02T15:04:05Z07:00"`
+	ProductIds        []string `json:"product_ids"`
+	CategoryIds       []string `json:"category_ids"`
+	MinPurchase       Money    `json:"min_purchase" validate:"gte=0"`
+	UsageLimit        int      `json:"usage_limit" validate:"gte=0"`
+	UsageLimitPerUser int      `json:"usage_limit_per_user" validate:"gte=0"`
+	FirstOrderOnly    bool     `json:"first_order_only"`
+	Segments          []string `json:"segments"`
+	MinLoyaltyPoints  int64    `json:"min_loyalty_points" validate:"gte=0"`
+}
+
+// PromotionRedemptionStruct records a promotion used by an order, it is reserved when the
+// order is created and redeemed once paid
+type PromotionRedemptionStruct struct {
+	Id             string `bson:"_id,omitempty" json:"id"`
+	Status         string `bson:"status,omitempty" json:"status"` // reserved, redeemed, refunded
+	PromotionId    string `bson:"promotionId" json:"promotionId"`
+	Code           string `bson:"code,omitempty" json:"code,omitempty"`
+	UserId         string `bson:"userId" json:"userId"`
+	InvoiceId      string `bson:"invoiceId" json:"invoiceId"`
+	Discount       Money  `bson:"discount" json:"discount"`             // In the currency charged
+	BaseDiscount   Money  `bson:"baseDiscount" json:"baseDiscount"`     // In the base currency
+	BaseOrderTotal Money  `bson:"baseOrderTotal" json:"baseOrderTotal"` // What the order brought in, after discounts
+	RedeemedAt     string `bson:"redeemedAt" json:"redeemedAt"`
+}
+
+// PromotionStatsStruct sums the redemptions of a promotion, amounts are in the base currency
+type PromotionStatsStruct struct {
+	PromotionId   string `bson:"_id" json:"promotionId"`
+	Name          string `bson:"name" json:"name"`
+	Code          string `bson:"code,omitempty" json:"code,omitempty"`
+	Redemptions   int    `bson:"redemptions" json:"redemptions"`
+	Customers     int    `bson:"customers" json:"customers"`
+	TotalDiscount Money  `bson:"totalDiscount" json:"totalDiscount"`
+	Revenue       Money  `bson:"revenue" json:"revenue"` // Orders using the promotion, after discounts
+}
+
+// AppliedDiscountStruct records a promotion applied to an invoice
+type AppliedDiscountStruct struct {
+	PromotionId  string `bson:"promotionId" json:"promotionId"`
+	Name         string `bson:"name" json:"name"`
+	Code         string `bson:"code,omitempty" json:"code,omitempty"`
+	DiscountType string `bson:"discountType" json:"discountType"`
+	Amount       Money  `bson:"amount" json:"amount"`         // In the currency charged
+	BaseAmount   Money  `bson:"baseAmount" json:"baseAmount"` // In the base currency
 }
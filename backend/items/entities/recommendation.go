@@ -0,0 +1,23 @@
+package entities
+
+type ScoredProductStruct struct {
+	ProductId string  `bson:"productId" json:"productId"`
+	Score     float64 `bson:"score" json:"score"`
+	Orders    int     `bson:"orders,omitempty" json:"orders,omitempty"` // Orders containing both products, for related products
+}
+
+// RelatedProductsStruct lists the products most often bought along with a product
+type RelatedProductsStruct struct {
+	ProductId string                `bson:"_id" json:"productId"`
+	Related   []ScoredProductStruct `bson:"related" json:"related"`
+	UpdatedAt string                `bson:"updatedAt" json:"updatedAt"`
+}
+
+// UserRecommendationsStruct lists the products a user is likely to buy, from what they and
+// similar customers bought
+type UserRecommendationsStruct struct {
+	UserId        string                `bson:"_id" json:"userId"`
+	Products      []ScoredProductStruct `bson:"products" json:"products"`
+	TopCategories []string              `bson:"topCategories" json:"topCategories"`
+	UpdatedAt     string                `bson:"updatedAt" json:"updatedAt"`
+}
@@ -1,20 +1,28 @@
 package entities
 
 type UserStruct struct {
-	Id          string          `bson:"_id,omitempty"`
-	FirstName   string          `bson:"firstName" json:"firstName" form:"firstName" validate:"required"`
-	LastName    string          `bson:"lastName" json:"lastName" form:"lastName" validate:"required"`
-	Email       string          `bson:"email" json:"email" form:"email" validate:"required,email"`
-	Password    string          `bson:"password" json:"password" form:"password" validate:"required,min=8"`
-	PhoneNumber string          `bson:"phoneNumber" json:"phoneNumber" form:"phoneNumber"`
-	City        CityStruct      `bson:"city" json:"city" form:"city"`
-	Address     string          `bson:"address" json:"address" form:"address"`
-	Logs        []LogStruct     `bson:"logs,omitempty"`
-	Invoices    []InvoiceStruct `bson:"invoices,omitempty"`
-	Roles       []RoleStruct    `bson:"roles,omitempty"`
-	Reports     []ReportStruct  `bson:"reports,omitempty"`
-	DeviceToken string          `bson:"deviceToken,omitempty" json:"deviceToken,omitempty"`
-	Archived    bool            `bson:"archived,omitempty"`
+	Id            string          `bson:"_id,omitempty"`
+	FirstName     string          `bson:"firstName" json:"firstName" form:"firstName" validate:"required"`
+	LastName      string          `bson:"lastName" json:"lastName" form:"lastName" validate:"required"`
+	Email         string          `bson:"email" json:"email" form:"email" validate:"required,email"`
+	Password      string          `bson:"password" json:"password" form:"password" validate:"required,min=8"`
+	PhoneNumber   string          `bson:"phoneNumber" json:"phoneNumber" form:"phoneNumber"`
+	City          CityStruct      `bson:"city" json:"city" form:"city"`
+	Address       string          `bson:"address" json:"address" form:"address"`
+	Logs          []LogStruct     `bson:"logs,omitempty"`
+	Invoices      []InvoiceStruct `bson:"invoices,omitempty"`
+	Roles         []RoleStruct    `bson:"roles,omitempty"`
+	Reports       []ReportStruct  `bson:"reports,omitempty"`
+	Segments      []string        `bson:"segments,omitempty" json:"segments,omitempty"` // Set by admins, e.g. vip, student
+	LoyaltyPoints int64           `bson:"loyaltyPoints,omitempty" json:"loyaltyPoints"`
+	// DefaultNotificationPreferences apply until the user sets theirs
+	NotificationPreferences *NotificationPreferencesStruct `bson:"notificationPreferences,omitempty" json:"notificationPreferences,omitempty"`
+	Archived                bool                           `bson:"archived,omitempty"`
+}
+
+// UserSegmentsStruct replaces the segments of a user
+type UserSegmentsStruct struct {
+	Segments []string `json:"segments"`
 }
 
 type UserStructProtected struct {
@@ -0,0 +1,26 @@
+package entities
+
+// VatRateStruct is a configurable VAT rate. Products use their own rate, else the one of
+// their most specific category, else the default rate.
+type VatRateStruct struct {
+	Id      string `bson:"_id,omitempty" json:"id"`
+	Code    string `bson:"code" json:"code" validate:"required"` // e.g. standard, intermediate, reduced, super_reduced
+	Name    string `bson:"name" json:"name" validate:"required"`
+	Rate    int64  `bson:"rate" json:"rate" validate:"gte=0,lte=10000"` // basis points, 2000 = 20%
+	Default bool   `bson:"default" json:"default"`
+}
+
+// VatAssignmentStruct assigns a VAT rate to a product or a category, an empty id removes it
+type VatAssignmentStruct struct {
+	VatRateId string `json:"vat_rate_id"`
+}
+
+// TaxBreakdownStruct sums the invoice lines sharing a VAT rate
+type TaxBreakdownStruct struct {
+	VatRateId string `bson:"vatRateId" json:"vatRateId"`
+	Code      string `bson:"code" json:"code"`
+	Rate      int64  `bson:"rate" json:"rate"` // basis points, 2000 = 20%
+	Net       Money  `bson:"net" json:"net"`
+	Tax       Money  `bson:"tax" json:"tax"`
+	Gross     Money  `bson:"gross" json:"gross"`
+}
@@ -0,0 +1,446 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// campaignVariable matches the variables of campaign templates, e.g. {{firstName}}
+var campaignVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// campaignVariables tells the variables a template may use, and whether they need a promotion
+var campaignVariables = map[string]bool{
+	"firstName":     false,
+	"lastName":      false,
+	"promoCode":     true,
+	"promotionName": true,
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// buildCampaign checks a campaign input: its templates may only use known variables, and
+// the promotion ones need a promotion with a code
+func buildCampaign(input entities.CampaignInputStruct) (entities.CampaignStruct, error) {
+	campaign := entities.CampaignStruct{
+		Name:          input.Name,
+		Type:          input.Type,
+		Templates:     map[string]entities.CampaignTemplateStruct{},
+		DefaultLocale: normalizeLocale(input.DefaultLocale),
+		Target:        input.Target,
+		PromotionId:   input.PromotionId,
+		Data:          input.Data,
+		Status:        "draft",
+	}
+	if campaign.Type == "" {
+		campaign.Type = entities.NotificationMarketing
+	}
+	campaign.Target.Segments = normalizeSegments(campaign.Target.Segments)
+
+	var promotion entities.PromotStruct
+	if campaign.PromotionId != "" {
+		var err error
+		if promotion, err = GetPromotionById(campaign.PromotionId); err != nil {
+			return entities.CampaignStruct{}, err
+		}
+	}
+
+	for locale, template := range input.Templates {
+		for _, text := range []string{template.Title, template.Body} {
+			for _, match := range campaignVariable.FindAllStringSubmatch(text, -1) {
+				needsPromotion, known := campaignVariables[match[1]]
+				switch {
+				case !known:
+					return entities.CampaignStruct{}, fmt.Errorf("unknown template variable %s", match[0])
+				case needsPromotion && campaign.PromotionId == "":
+					return entities.CampaignStruct{}, fmt.Errorf("template variable %s needs a promotion", match[0])
+				case match[1] == "promoCode" && promotion.Code == "":
+					return entities.CampaignStruct{}, fmt.Errorf("promotion %s has no code", promotion.Id)
+				}
+			}
+		}
+		campaign.Templates[normalizeLocale(locale)] = template
+	}
+	if _, ok := campaign.Templates[campaign.DefaultLocale]; !ok {
+		return entities.CampaignStruct{}, fmt.Errorf("no template for the default locale %s", campaign.DefaultLocale)
+	}
+
+	if input.ScheduledAt != "" {
+		scheduledAt, err := time.Parse(time.RFC3339, input.ScheduledAt)
+		if err != nil {
+			return entities.CampaignStruct{}, fmt.Errorf("invalid scheduled date: %v", err)
+		}
+		campaign.ScheduledAt = scheduledAt.UTC()
+		campaign.Status = "scheduled"
+	}
+	return campaign, nil
+}
+
+// campaignTemplate picks the template of a locale, of its language, or the default one
+func campaignTemplate(campaign entities.CampaignStruct, locale string) entities.CampaignTemplateStruct {
+	locale = normalizeLocale(locale)
+	if template, ok := campaign.Templates[locale]; ok {
+		return template
+	}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		if template, ok := campaign.Templates[language]; ok {
+			return template
+		}
+	}
+	return campaign.Templates[campaign.DefaultLocale]
+}
+
+func renderCampaignText(text string, values map[string]string) string {
+	return campaignVariable.ReplaceAllStringFunc(text, func(variable string) string {
+		return values[campaignVariable.FindStringSubmatch(variable)[1]]
+	})
+}
+
+// GetCampaigns lists every campaign, or those with a given status
+func GetCampaigns(status string) ([]entities.CampaignStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	campaigns := []entities.CampaignStruct{}
+	if err := cursor.All(ctx, &campaigns); err != nil {
+		return nil, err
+	}
+	for i := range campaigns {
+		campaigns[i] = withDeliveryStats(ctx, campaigns[i])
+	}
+	return campaigns, nil
+}
+
+func GetCampaignById(id string) (entities.CampaignStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	var campaign entities.CampaignStruct
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&campaign)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.CampaignStruct{}, fmt.Errorf("no campaign found with id: %s", id)
+		}
+		return entities.CampaignStruct{}, err
+	}
+	return withDeliveryStats(ctx, campaign), nil
+}
+
+// withDeliveryStats fills the stats of a sent campaign with the delivery counts of its
+// dispatch, the opens are counted on the campaign itself
+func withDeliveryStats(ctx context.Context, campaign entities.CampaignStruct) entities.CampaignStruct {
+	if campaign.DispatchId == "" {
+		return campaign
+	}
+
+	var dispatch entities.NotificationDispatchStruct
+	err := db.GetDatabase().Collection("notification_dispatches").FindOne(ctx, bson.M{"_id": mustObjectID(campaign.DispatchId)}).Decode(&dispatch)
+	if err != nil {
+		return campaign
+	}
+	campaign.Stats.Targeted = dispatch.Targeted
+	campaign.Stats.OptedOut = dispatch.OptedOut
+	campaign.Stats.NoDevice = dispatch.NoDevice
+	campaign.Stats.Devices = dispatch.Devices
+	campaign.Stats.Sent = dispatch.Sent
+	campaign.Stats.Failed = dispatch.Failed
+	return campaign
+}
+
+func CreateCampaign(input entities.CampaignInputStruct) (entities.CampaignStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	campaign, err := buildCampaign(input)
+	if err != nil {
+		return entities.CampaignStruct{}, err
+	}
+
+	now := time.Now().UTC()
+	campaign.Id = primitive.NewObjectID().Hex()
+	campaign.CreatedAt = now
+	campaign.UpdatedAt = now
+
+	if _, err := collection.InsertOne(ctx, campaign); err != nil {
+		return entities.CampaignStruct{}, err
+	}
+	return campaign, nil
+}
+
+// UpdateCampaign edits a campaign that was not sent yet
+func UpdateCampaign(id string, input entities.CampaignInputStruct) (entities.CampaignStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	current, err := GetCampaignById(id)
+	if err != nil {
+		return entities.CampaignStruct{}, err
+	}
+
+	campaign, err := buildCampaign(input)
+	if err != nil {
+		return entities.CampaignStruct{}, err
+	}
+	campaign.Id = id
+	campaign.CreatedAt = current.CreatedAt
+	campaign.UpdatedAt = time.Now().UTC()
+
+	// Only matches while the scheduler has not picked the campaign up
+	result, err := collection.ReplaceOne(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": []string{"draft", "scheduled", "failed", "cancelled"}}},
+		campaign,
+	)
+	if err != nil {
+		return entities.CampaignStruct{}, err
+	}
+	if result.MatchedCount == 0 {
+		return entities.CampaignStruct{}, fmt.Errorf("campaign %s is already %s", id, current.Status)
+	}
+	return campaign, nil
+}
+
+// CancelCampaign stops a scheduled campaign from being sent, it can be scheduled again by
+// editing it
+func CancelCampaign(id string) (entities.CampaignStruct, error) {
+	return setCampaignStatus(id, []string{"draft", "scheduled"}, bson.M{"status": "cancelled"})
+}
+
+func DeleteCampaign(id string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	current, err := GetCampaignById(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "status": bson.M{"$in": []string{"draft", "scheduled", "failed", "cancelled"}}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("campaign %s is already %s", id, current.Status)
+	}
+	return nil
+}
+
+// setCampaignStatus moves a campaign from one of the statuses from, only one caller wins
+func setCampaignStatus(id string, from []string, set bson.M) (entities.CampaignStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	set["updatedAt"] = time.Now().UTC()
+
+	var campaign entities.CampaignStruct
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&campaign)
+	if err == mongo.ErrNoDocuments {
+		current, err := GetCampaignById(id)
+		if err != nil {
+			return entities.CampaignStruct{}, err
+		}
+		return entities.CampaignStruct{}, fmt.Errorf("campaign %s is already %s", id, current.Status)
+	}
+	if err != nil {
+		return entities.CampaignStruct{}, err
+	}
+	return campaign, nil
+}
+
+// campaignLock is how long sending a campaign may take, a campaign still sending after that
+// was interrupted and is taken over by the next run of DispatchDueCampaigns
+const campaignLock = 5 * time.Minute
+
+// SendCampaign sends a campaign now, whether it was scheduled or not
+func SendCampaign(id string) (entities.CampaignStruct, error) {
+	campaign, err := setCampaignStatus(id, []string{"draft", "scheduled", "failed"}, bson.M{"status": "sending", "lockedUntil": time.Now().UTC().Add(campaignLock)})
+	if err != nil {
+		return entities.CampaignStruct{}, err
+	}
+	return dispatchCampaign(campaign)
+}
+
+// DispatchDueCampaigns sends the campaigns whose time has come, and finishes those a crash
+// left sending. A campaign that cannot be sent is marked failed and the others still are.
+func DispatchDueCampaigns() error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("campaigns")
+
+	for {
+		now := time.Now().UTC()
+		var campaign entities.CampaignStruct
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"$or": []bson.M{
+				{"status": "scheduled", "scheduledAt": bson.M{"$lte": now}},
+				{"status": "sending", "lockedUntil": bson.M{"$not": bson.M{"$gt": now}}},
+			}},
+			bson.M{"$set": bson.M{"status": "sending", "lockedUntil": now.Add(campaignLock), "updatedAt": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.Before).SetSort(bson.M{"scheduledAt": 1}),
+		).Decode(&campaign)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		interrupted := campaign.Status == "sending"
+		campaign.Status = "sending"
+		if interrupted {
+			_, err = resumeCampaign(ctx, campaign)
+		} else {
+			_, err = dispatchCampaign(campaign)
+		}
+		if err != nil {
+			log.Printf("Failed to send campaign %s: %v", campaign.Id, err)
+		}
+	}
+}
+
+// resumeCampaign finishes sending a campaign that was interrupted. Its notifications are only
+// queued again if none were, so that no user gets the campaign twice.
+func resumeCampaign(ctx context.Context, campaign entities.CampaignStruct) (entities.CampaignStruct, error) {
+	conn := db.GetDatabase()
+
+	var dispatch entities.NotificationDispatchStruct
+	err := conn.Collection("notification_dispatches").FindOne(ctx, bson.M{"campaignId": campaign.Id}, options.FindOne().SetSort(bson.M{"createdAt": -1})).Decode(&dispatch)
+	if err == mongo.ErrNoDocuments {
+		return dispatchCampaign(campaign)
+	}
+	if err != nil {
+		return campaign, err
+	}
+
+	if dispatch.Status == "failed" {
+		return campaign, finishCampaign(ctx, &campaign, entities.NotificationDispatchStruct{},
+			fmt.Errorf("dispatch %s failed: %s", dispatch.Id, dispatch.Error))
+	}
+
+	queued, err := conn.Collection("notification_jobs").CountDocuments(ctx, bson.M{"dispatchId": dispatch.Id})
+	if err != nil {
+		return campaign, err
+	}
+	if queued != int64(dispatch.Devices) {
+		// Only part of the users would get it, the campaign is to be sent again
+		sendErr := failNotificationDispatch(ctx, dispatch.Id,
+			fmt.Errorf("interrupted while queuing its notifications, %d of %d queued", queued, dispatch.Devices))
+		return campaign, finishCampaign(ctx, &campaign, entities.NotificationDispatchStruct{},
+			fmt.Errorf("dispatch %s %v", dispatch.Id, sendErr))
+	}
+	return campaign, finishCampaign(ctx, &campaign, dispatch, nil)
+}
+
+// dispatchCampaign queues the notifications of a campaign being sent, written for each user
+// from the template of their locale. The campaign is sent once they are queued, the workers
+// deliver them afterwards, or failed if they could not be.
+func dispatchCampaign(campaign entities.CampaignStruct) (entities.CampaignStruct, error) {
+	ctx := context.TODO()
+
+	values := map[string]string{}
+	data := map[string]string{"campaignId": campaign.Id}
+	for key, value := range campaign.Data {
+		data[key] = value
+	}
+
+	var err error
+	if campaign.PromotionId != "" {
+		var promotion entities.PromotStruct
+		if promotion, err = GetPromotionById(campaign.PromotionId); err == nil {
+			values["promoCode"] = promotion.Code
+			values["promotionName"] = promotion.Name
+			data["promotionId"] = promotion.Id
+		}
+	}
+
+	var dispatch entities.NotificationDispatchStruct
+	if err == nil {
+		template := campaign.Templates[campaign.DefaultLocale]
+		dispatch, err = enqueueNotification(entities.NotificationRequestStruct{
+			Title:  template.Title,
+			Body:   template.Body,
+			Type:   campaign.Type,
+			Target: campaign.Target,
+			Data:   data,
+		}, campaign.Id, func(recipient notificationRecipient) (string, string) {
+			userValues := map[string]string{"firstName": recipient.FirstName, "lastName": recipient.LastName}
+			for key, value := range values {
+				userValues[key] = value
+			}
+			template := campaignTemplate(campaign, recipient.locale())
+			return renderCampaignText(template.Title, userValues), renderCampaignText(template.Body, userValues)
+		})
+	}
+
+	if err := finishCampaign(ctx, &campaign, dispatch, err); err != nil {
+		return campaign, err
+	}
+	return withDeliveryStats(ctx, campaign), nil
+}
+
+// finishCampaign marks a campaign sent with its dispatch, or failed with sendErr
+func finishCampaign(ctx context.Context, campaign *entities.CampaignStruct, dispatch entities.NotificationDispatchStruct, sendErr error) error {
+	collection := db.GetDatabase().Collection("campaigns")
+
+	if sendErr != nil {
+		campaign.Status = "failed"
+		campaign.Error = sendErr.Error()
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": campaign.Id}, bson.M{
+			"$set":   bson.M{"status": "failed", "error": campaign.Error},
+			"$unset": bson.M{"lockedUntil": ""},
+		})
+		if err != nil {
+			return err
+		}
+		return sendErr
+	}
+
+	campaign.Status = "sent"
+	campaign.Error = ""
+	campaign.DispatchId = dispatch.Id
+	campaign.SentAt = dispatch.CreatedAt
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": campaign.Id}, bson.M{
+		"$set":   bson.M{"status": campaign.Status, "dispatchId": campaign.DispatchId, "sentAt": campaign.SentAt},
+		"$unset": bson.M{"error": "", "lockedUntil": ""},
+	})
+	return err
+}
+
+// recordCampaignOpen counts a user opening a notification of a campaign
+func recordCampaignOpen(ctx context.Context, campaignId string) error {
+	collection := db.GetDatabase().Collection("campaigns")
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": campaignId}, bson.M{"$inc": bson.M{"stats.opened": 1}})
+	return err
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestInterruptedCampaignFailsWhenPartlyQueued(t *testing.T) {
+	dbtest.Setup(t)
+	ctx := context.Background()
+	conn := db.GetDatabase()
+
+	// The server sending it stopped after queuing the first of its two notifications
+	now := time.Now().UTC()
+	_, err := conn.Collection("campaigns").InsertOne(ctx, entities.CampaignStruct{
+		Id:          "campaign",
+		Status:      "sending",
+		ScheduledAt: now.Add(-time.Hour),
+		LockedUntil: now.Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted, err := conn.Collection("notification_dispatches").InsertOne(ctx, entities.NotificationDispatchStruct{
+		CampaignId: "campaign",
+		Status:     "sending",
+		Devices:    2,
+		CreatedAt:  now.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchId := inserted.InsertedID.(primitive.ObjectID)
+	_, err = conn.Collection("notification_jobs").InsertOne(ctx, entities.NotificationJobStruct{
+		DispatchId:    dispatchId.Hex(),
+		Status:        "pending",
+		NextAttemptAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DispatchDueCampaigns(); err != nil {
+		t.Fatal(err)
+	}
+
+	var campaign entities.CampaignStruct
+	if err := conn.Collection("campaigns").FindOne(ctx, bson.M{"_id": "campaign"}).Decode(&campaign); err != nil {
+		t.Fatal(err)
+	}
+	if campaign.Status != "failed" {
+		t.Errorf("got campaign %s, want failed", campaign.Status)
+	}
+	var dispatch entities.NotificationDispatchStruct
+	if err := conn.Collection("notification_dispatches").FindOne(ctx, bson.M{"_id": dispatchId}).Decode(&dispatch); err != nil {
+		t.Fatal(err)
+	}
+	if dispatch.Status != "failed" || dispatch.Error == "" {
+		t.Errorf("got dispatch %s (%q), want failed with the reason", dispatch.Status, dispatch.Error)
+	}
+	if queued, _ := conn.Collection("notification_jobs").CountDocuments(ctx, bson.M{"dispatchId": dispatchId.Hex()}); queued != 0 {
+		t.Errorf("got %d jobs left, want the partial queue dropped", queued)
+	}
+}
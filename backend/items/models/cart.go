@@ -0,0 +1,318 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"trinity/backend/pricing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// getCart returns the cart of a user, an empty one if they never filled it
+func getCart(ctx context.Context, userId string) (entities.CartStruct, error) {
+	collection := db.GetDatabase().Collection("carts")
+
+	cart := entities.CartStruct{UserId: userId}
+	err := collection.FindOne(ctx, bson.M{"_id": userId}).Decode(&cart)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return entities.CartStruct{}, err
+	}
+	if cart.Items == nil {
+		cart.Items = []entities.CartItemStruct{}
+	}
+	if cart.Codes == nil {
+		cart.Codes = []string{}
+	}
+	return cart, nil
+}
+
+// cartRetries is how many times a change is tried again when the cart changed meanwhile
+const cartRetries = 5
+
+var errCartConflict = errors.New("cart was changed meanwhile, try again")
+
+// saveCart stores a cart that changed, which is then no longer the one of its last order. The
+// cart is only saved over the version it was read from.
+func saveCart(ctx context.Context, cart entities.CartStruct) error {
+	collection := db.GetDatabase().Collection("carts")
+
+	filter := bson.M{"_id": cart.UserId, "version": cart.Version}
+	if cart.Version == 0 {
+		// A new cart, or one saved before carts had a version
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	cart.InvoiceId = ""
+	cart.Version++
+	cart.UpdatedAt = time.Now().Format(time.RFC3339)
+	result, err := collection.ReplaceOne(ctx, filter, cart, options.Replace().SetUpsert(true))
+	if err != nil {
+		// Another request created the cart first
+		if mongo.IsDuplicateKeyError(err) {
+			return errCartConflict
+		}
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return errCartConflict
+	}
+	return nil
+}
+
+// updateCart applies a change to the cart of a user, starting over from the cart as it is
+// stored when another request changed it meanwhile
+func updateCart(ctx context.Context, userId string, change func(cart *entities.CartStruct) error) error {
+	for attempt := 0; attempt < cartRetries; attempt++ {
+		cart, err := getCart(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if err := change(&cart); err != nil {
+			return err
+		}
+		if err := saveCart(ctx, cart); err != errCartConflict {
+			return err
+		}
+	}
+	return errCartConflict
+}
+
+// cartItemIssue tells why a product cannot be ordered in that quantity
+func cartItemIssue(product entities.ProductStruct, quantity int) string {
+	switch {
+	case product.Archived:
+		return "product is no longer available"
+	case product.StockQuantity <= 0:
+		return "product is out of stock"
+	case float64(quantity) > product.StockQuantity:
+		return fmt.Sprintf("only %v left in stock", product.StockQuantity)
+	}
+	return ""
+}
+
+// checkCartItem makes sure a product can be put in the cart in that quantity
+func checkCartItem(productId string, quantity int) error {
+	product, err := GetProductById(productId)
+	if err != nil {
+		return fmt.Errorf("no product found with id: %s", productId)
+	}
+	if issue := cartItemIssue(product, quantity); issue != "" {
+		return fmt.Errorf("%s", issue)
+	}
+	return nil
+}
+
+// GetCartDetails prices a cart with the current prices, stock and promotions
+func GetCartDetails(userId string) (entities.CartDetailsStruct, error) {
+	ctx := context.TODO()
+
+	cart, err := getCart(ctx, userId)
+	if err != nil {
+		return entities.CartDetailsStruct{}, err
+	}
+
+	currency := cart.Currency
+	if currency == "" {
+		currency = entities.DefaultCurrency
+	}
+	details := entities.CartDetailsStruct{
+		Lines:         []entities.CartLineStruct{},
+		Currency:      currency,
+		Codes:         cart.Codes,
+		LoyaltyPoints: cart.LoyaltyPoints,
+		UpdatedAt:     cart.UpdatedAt,
+	}
+
+	var orderable []entities.CartItemStruct
+	for _, item := range cart.Items {
+		line := entities.CartLineStruct{ProductId: item.ProductId, Quantity: item.Quantity}
+
+		product, err := GetProductById(item.ProductId)
+		if err != nil {
+			line.Issue = "product is no longer available"
+		} else {
+			converted, err := ConvertProductPrices([]entities.ProductStruct{product}, currency)
+			if err != nil {
+				return entities.CartDetailsStruct{}, err
+			}
+			line.Product = converted[0]
+			line.Issue = cartItemIssue(product, item.Quantity)
+		}
+
+		if line.Issue == "" {
+			orderable = append(orderable, item)
+		}
+		details.Lines = append(details.Lines, line)
+	}
+
+	if len(orderable) > 0 {
+		quote, err := PriceOrder(userId, entities.CheckoutStruct{
+			Cart:          orderable,
+			Currency:      currency,
+			Codes:         cart.Codes,
+			LoyaltyPoints: cart.LoyaltyPoints,
+		})
+		if err != nil {
+			details.Error = err.Error()
+		} else {
+			details.Quote = &quote
+		}
+	}
+	return details, nil
+}
+
+// AddCartItem puts a product in the cart, adding to its quantity if it is already there
+func AddCartItem(userId string, item entities.CartItemStruct) (entities.CartDetailsStruct, error) {
+	ctx := context.TODO()
+
+	err := updateCart(ctx, userId, func(cart *entities.CartStruct) error {
+		quantity := item.Quantity
+		found := false
+		for i := range cart.Items {
+			if cart.Items[i].ProductId == item.ProductId {
+				cart.Items[i].Quantity += item.Quantity
+				quantity = cart.Items[i].Quantity
+				found = true
+			}
+		}
+		if !found {
+			cart.Items = append(cart.Items, item)
+		}
+		return checkCartItem(item.ProductId, quantity)
+	})
+	if err != nil {
+		return entities.CartDetailsStruct{}, err
+	}
+	return GetCartDetails(userId)
+}
+
+// SetCartItemQuantity changes the quantity of a product already in the cart
+func SetCartItemQuantity(userId string, productId string, quantity int) (entities.CartDetailsStruct, error) {
+	ctx := context.TODO()
+
+	err := updateCart(ctx, userId, func(cart *entities.CartStruct) error {
+		found := false
+		for i := range cart.Items {
+			if cart.Items[i].ProductId == productId {
+				cart.Items[i].Quantity = quantity
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no cart item found with product id: %s", productId)
+		}
+		return checkCartItem(productId, quantity)
+	})
+	if err != nil {
+		return entities.CartDetailsStruct{}, err
+	}
+	return GetCartDetails(userId)
+}
+
+func RemoveCartItem(userId string, productId string) (entities.CartDetailsStruct, error) {
+	ctx := context.TODO()
+
+	err := updateCart(ctx, userId, func(cart *entities.CartStruct) error {
+		items := make([]entities.CartItemStruct, 0, len(cart.Items))
+		for _, item := range cart.Items {
+			if item.ProductId != productId {
+				items = append(items, item)
+			}
+		}
+		if len(items) == len(cart.Items) {
+			return fmt.Errorf("no cart item found with product id: %s", productId)
+		}
+		cart.Items = items
+		return nil
+	})
+	if err != nil {
+		return entities.CartDetailsStruct{}, err
+	}
+	return GetCartDetails(userId)
+}
+
+// SetCartOptions sets the currency, promo codes and loyalty points of the cart checkout
+func SetCartOptions(userId string, cartOptions entities.CartOptionsStruct) (entities.CartDetailsStruct, error) {
+	ctx := context.TODO()
+
+	currency := strings.ToUpper(cartOptions.Currency)
+	if currency != "" && !pricing.IsStoreCurrency(currency) {
+		return entities.CartDetailsStruct{}, fmt.Errorf("unsupported currency: %s", currency)
+	}
+
+	err := updateCart(ctx, userId, func(cart *entities.CartStruct) error {
+		cart.Currency = currency
+		cart.Codes = normalizeCodes(cartOptions.Codes)
+		cart.LoyaltyPoints = cartOptions.LoyaltyPoints
+		return nil
+	})
+	if err != nil {
+		return entities.CartDetailsStruct{}, err
+	}
+	return GetCartDetails(userId)
+}
+
+func ClearCart(userId string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("carts")
+
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": userId})
+	return err
+}
+
+// GetCartCheckout turns the cart into what the order is made from, as long as every line can
+// be ordered
+func GetCartCheckout(userId string) (entities.CheckoutStruct, error) {
+	ctx := context.TODO()
+
+	cart, err := getCart(ctx, userId)
+	if err != nil {
+		return entities.CheckoutStruct{}, err
+	}
+	if len(cart.Items) == 0 {
+		return entities.CheckoutStruct{}, fmt.Errorf("cart is empty")
+	}
+
+	for _, item := range cart.Items {
+		if err := checkCartItem(item.ProductId, item.Quantity); err != nil {
+			return entities.CheckoutStruct{}, fmt.Errorf("product %s: %v", item.ProductId, err)
+		}
+	}
+
+	return entities.CheckoutStruct{
+		Cart:          cart.Items,
+		Currency:      cart.Currency,
+		Codes:         cart.Codes,
+		LoyaltyPoints: cart.LoyaltyPoints,
+		ReplaceOrder:  cart.InvoiceId,
+	}, nil
+}
+
+// SetCartInvoice links the cart to the order made from it, so that it is emptied once paid
+func SetCartInvoice(userId string, invoiceId string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("carts")
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"invoiceId": invoiceId}})
+	return err
+}
+
+// ClearPaidCart empties the cart an order was made from once the order is paid, unless the
+// user changed it in the meantime
+func ClearPaidCart(userId string, invoiceId string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("carts")
+
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": userId, "invoiceId": invoiceId})
+	return err
+}
@@ -0,0 +1,329 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugifyCategory turns an OpenFoodFacts category label ("en:Sweet snacks",
+// "Boissons gazeuses") into a stable ASCII slug ("sweet-snacks").
+func slugifyCategory(name string) string {
+	name = strings.TrimSpace(name)
+	// Drop the language prefix OpenFoodFacts sometimes keeps on untranslated tags
+	if len(name) > 3 && name[2] == ':' {
+		name = name[3:]
+	}
+
+	var b strings.Builder
+	lastDash := true
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Skip combining accents left over by the NFD decomposition
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			lastDash = false
+		case !lastDash:
+			b.WriteByte('-')
+			lastDash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// displayCategoryName strips the language prefix from an OpenFoodFacts label
+func displayCategoryName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) > 3 && name[2] == ':' {
+		name = name[3:]
+	}
+	return name
+}
+
+// upsertCategory returns the category matching the name's slug, creating it under parent if needed.
+// An existing category keeps its parent, OpenFoodFacts files the same category under different
+// ones: admins choose where it goes with MoveCategory.
+func upsertCategory(ctx context.Context, name string, parent *entities.CategoryStruct) (entities.CategoryStruct, error) {
+	conn := db.GetDatabase()
+	collection := conn.Collection("categories")
+
+	slug := slugifyCategory(name)
+	if slug == "" {
+		return entities.CategoryStruct{}, fmt.Errorf("invalid category name: %q", name)
+	}
+
+	insert := bson.M{
+		"slug":      slug,
+		"name":      displayCategoryName(name),
+		"ancestors": []string{},
+		"archived":  false,
+	}
+	if parent != nil {
+		insert["parentId"] = parent.Id
+		insert["ancestors"] = append(append([]string{}, parent.Ancestors...), parent.Id)
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var category entities.CategoryStruct
+	err := collection.FindOneAndUpdate(ctx, bson.M{"slug": slug}, bson.M{"$setOnInsert": insert}, opts).Decode(&category)
+	if err != nil {
+		return entities.CategoryStruct{}, fmt.Errorf("failed to upsert category %s: %v", slug, err)
+	}
+
+	return category, nil
+}
+
+// MoveCategory puts a category under another one, or at the root when parentId is empty. Its
+// sub-categories follow it, and the products of the moved branch get their category chain
+// updated, along with their net prices as the VAT rate of a category follows its ancestors.
+func MoveCategory(id string, parentId string) (entities.CategoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("categories")
+
+	category, err := GetCategoryById(id)
+	if err != nil {
+		return entities.CategoryStruct{}, err
+	}
+
+	ancestors := []string{}
+	set := bson.M{"ancestors": ancestors}
+	update := bson.M{"$set": set, "$unset": bson.M{"parentId": ""}}
+	if parentId != "" {
+		parent, err := GetCategoryById(parentId)
+		if err != nil {
+			return entities.CategoryStruct{}, err
+		}
+		if parent.Id == category.Id || slices.Contains(parent.Ancestors, category.Id) {
+			return entities.CategoryStruct{}, fmt.Errorf("invalid parent: category %s cannot be moved under itself", id)
+		}
+		ancestors = append(append(ancestors, parent.Ancestors...), parent.Id)
+		set["ancestors"] = ancestors
+		set["parentId"] = parent.Id
+		delete(update, "$unset")
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": mustObjectID(category.Id)}, update); err != nil {
+		return entities.CategoryStruct{}, err
+	}
+
+	// Sub-categories keep the part of their ancestors below the moved category
+	chain := append(append([]string{}, ancestors...), category.Id)
+	_, err = collection.UpdateMany(ctx, bson.M{"ancestors": category.Id}, bson.A{
+		bson.M{"$set": bson.M{"ancestors": bson.M{"$concatArrays": bson.A{
+			chain,
+			bson.M{"$slice": bson.A{
+				"$ancestors",
+				bson.M{"$add": bson.A{bson.M{"$indexOfArray": bson.A{"$ancestors", category.Id}}, 1}},
+				bson.M{"$size": "$ancestors"},
+			}},
+		}}}},
+	})
+	if err != nil {
+		return entities.CategoryStruct{}, fmt.Errorf("failed to move sub-categories: %v", err)
+	}
+
+	// Products carry their whole category chain, the former ancestors give way to the new ones
+	stale := append(append([]string{}, category.Ancestors...), ancestors...)
+	_, err = conn.Collection("products").UpdateMany(ctx, bson.M{"categoryIds": category.Id}, bson.A{
+		bson.M{"$set": bson.M{"categoryIds": bson.M{"$concatArrays": bson.A{
+			ancestors,
+			bson.M{"$filter": bson.M{
+				"input": "$categoryIds",
+				"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$this", stale}}}},
+			}},
+		}}}},
+	})
+	if err != nil {
+		return entities.CategoryStruct{}, fmt.Errorf("failed to update the categories of products: %v", err)
+	}
+
+	if _, err := SyncNetPrices(); err != nil {
+		return entities.CategoryStruct{}, fmt.Errorf("category moved but failed to update net prices: %v", err)
+	}
+
+	return GetCategoryById(id)
+}
+
+// ImportOpenFoodFactsCategories maps an OpenFoodFacts comma-separated category string onto
+// the categories collection and returns the matching category IDs.
+// OpenFoodFacts lists categories from the most generic to the most specific, so each entry
+// is attached as a child of the previous one.
+func ImportOpenFoodFactsCategories(categories string) ([]string, error) {
+	ctx := context.TODO()
+
+	ids := []string{}
+	var parent *entities.CategoryStruct
+	for _, name := range strings.Split(categories, ",") {
+		if slugifyCategory(name) == "" {
+			continue
+		}
+
+		category, err := upsertCategory(ctx, name, parent)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, category.Id)
+		parent = &category
+	}
+
+	return ids, nil
+}
+
+// SyncProductCategories links every product that has an OpenFoodFacts category string
+// but no category IDs yet. It returns the number of products updated.
+func SyncProductCategories() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("products")
+
+	filter := bson.M{
+		"category": bson.M{"$nin": []any{nil, ""}},
+		"$or": []bson.M{
+			{"categoryIds": bson.M{"$exists": false}},
+			{"categoryIds": nil},
+			{"categoryIds": bson.M{"$size": 0}},
+		},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var products []entities.ProductStruct
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, product := range products {
+		categoryIds, err := ImportOpenFoodFactsCategories(product.Category)
+		if err != nil {
+			return updated, err
+		}
+
+		objID, err := primitive.ObjectIDFromHex(product.Id)
+		if err != nil {
+			return updated, fmt.Errorf("invalid ID format")
+		}
+
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"categoryIds": categoryIds}})
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+func GetCategories() ([]entities.CategoryStruct, error) {
+	return findCategories(bson.M{"archived": false})
+}
+
+func GetRootCategories() ([]entities.CategoryStruct, error) {
+	return findCategories(bson.M{
+		"archived": false,
+		"parentId": bson.M{"$exists": false},
+	})
+}
+
+func GetCategoryChildren(id string) ([]entities.CategoryStruct, error) {
+	return findCategories(bson.M{
+		"archived": false,
+		"parentId": id,
+	})
+}
+
+func findCategories(filter bson.M) ([]entities.CategoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("categories")
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	categories := []entities.CategoryStruct{}
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func GetCategoryById(id string) (entities.CategoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("categories")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.CategoryStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	var category entities.CategoryStruct
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&category)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.CategoryStruct{}, fmt.Errorf("no category found with id: %s", id)
+		}
+		return entities.CategoryStruct{}, err
+	}
+	return category, nil
+}
+
+func GetCategoryBySlug(slug string) (entities.CategoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("categories")
+
+	var category entities.CategoryStruct
+	err := collection.FindOne(ctx, bson.M{"slug": slug}).Decode(&category)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.CategoryStruct{}, fmt.Errorf("no category found with slug: %s", slug)
+		}
+		return entities.CategoryStruct{}, err
+	}
+	return category, nil
+}
+
+// GetProductsByCategory returns the non-archived products linked to a category.
+// Products carry their whole category chain, so sub-category products are included.
+func GetProductsByCategory(categoryId string) ([]entities.ProductStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("products")
+
+	cursor, err := collection.Find(ctx, bson.M{"categoryIds": categoryId, "archived": false})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	products := []entities.ProductStruct{}
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
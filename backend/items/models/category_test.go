@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMoveCategory(t *testing.T) {
+	dbtest.Setup(t)
+	ctx := context.Background()
+	if _, err := InitializeVatRates(); err != nil {
+		t.Fatal(err)
+	}
+
+	snacks, err := ImportOpenFoodFactsCategories("Snacks, Sweet snacks, Biscuits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desserts, err := ImportOpenFoodFactsCategories("Desserts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snacksId, sweetId, biscuitsId, dessertsId := snacks[0], snacks[1], snacks[2], desserts[0]
+
+	productId := primitive.NewObjectID()
+	_, err = db.GetDatabase().Collection("products").InsertOne(ctx, bson.M{
+		"_id":         productId,
+		"reference":   "3017620422003",
+		"priceVat":    entities.NewMoney(422, entities.DefaultCurrency),
+		"categoryIds": snacks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := MoveCategory(sweetId, dessertsId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ParentId != dessertsId || !reflect.DeepEqual(moved.Ancestors, []string{dessertsId}) {
+		t.Errorf("got parent %s and ancestors %v, want under %s", moved.ParentId, moved.Ancestors, dessertsId)
+	}
+
+	biscuits, err := GetCategoryById(biscuitsId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{dessertsId, sweetId}; !reflect.DeepEqual(biscuits.Ancestors, want) {
+		t.Errorf("got sub-category ancestors %v, want %v", biscuits.Ancestors, want)
+	}
+
+	product, err := GetProductById(productId.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{dessertsId, sweetId, biscuitsId}; !reflect.DeepEqual(product.CategoryIds, want) {
+		t.Errorf("got product categories %v, want %v without %s", product.CategoryIds, want, snacksId)
+	}
+
+	if _, err := MoveCategory(sweetId, biscuitsId); err == nil {
+		t.Error("moved a category under its own sub-category")
+	}
+
+	root, err := MoveCategory(sweetId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.ParentId != "" || len(root.Ancestors) != 0 {
+		t.Errorf("got parent %q and ancestors %v, want a root category", root.ParentId, root.Ancestors)
+	}
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"trinity/backend/items/entities"
+	"trinity/backend/pricing"
+)
+
+// PriceOrder builds the pending invoice of a user's cart. Catalog prices include VAT and are
+// in the base currency: every line is converted to the currency charged, discounted by the
+// promotions that apply then by the loyalty points spent, and split into net and tax, while
+// the base currency equivalents are computed from the catalog prices.
+func PriceOrder(userId string, checkout entities.CheckoutStruct) (entities.InvoiceStruct, error) {
+	currency := strings.ToUpper(checkout.Currency)
+	if currency == "" {
+		currency = entities.DefaultCurrency
+	}
+	if !pricing.IsStoreCurrency(currency) {
+		return entities.InvoiceStruct{}, fmt.Errorf("unsupported currency: %s", currency)
+	}
+
+	rate, err := GetExchangeRate(currency)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+
+	now := time.Now()
+	var products []entities.ProductStruct
+	var lineRates []entities.VatRateStruct
+	var cartLines, baseCartLines []pricing.CartLine
+	vatRates := map[string]entities.VatRateStruct{}
+	for _, item := range checkout.Cart {
+		product, err := GetProductById(item.ProductId)
+		if err != nil {
+			return entities.InvoiceStruct{}, fmt.Errorf("product not found: %s", item.ProductId)
+		}
+
+		vatRate, err := ResolveProductVatRate(product)
+		if err != nil {
+			return entities.InvoiceStruct{}, fmt.Errorf("failed to find VAT rate of product %s: %v", product.Id, err)
+		}
+		vatRates[vatRate.Id] = vatRate
+
+		unitPrice, err := pricing.Convert(product.PriceVat.Amount, rate)
+		if err != nil {
+			return entities.InvoiceStruct{}, err
+		}
+
+		products = append(products, product)
+		lineRates = append(lineRates, vatRate)
+		line := pricing.CartLine{ProductId: product.Id, CategoryIds: product.CategoryIds, Quantity: int64(item.Quantity)}
+		line.UnitPrice = unitPrice
+		cartLines = append(cartLines, line)
+		line.UnitPrice = product.PriceVat.Amount
+		baseCartLines = append(baseCartLines, line)
+	}
+
+	customer, err := getCustomerProfile(userId, checkout.ReplaceOrder)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+	promotions, err := getCheckoutPromotions(normalizeCodes(checkout.Codes), now)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+	applied, baseApplied, chargedApplied, err := applyPromotions(promotions, customer, baseCartLines, cartLines, rate)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+
+	baseDiscounts := map[string]pricing.AppliedPromotion{}
+	for _, result := range baseApplied {
+		baseDiscounts[result.Id] = result
+	}
+	chargedDiscounts := map[string]pricing.AppliedPromotion{}
+	for _, result := range chargedApplied {
+		chargedDiscounts[result.Id] = result
+	}
+
+	lineDiscounts := make([]int64, len(products))
+	baseLineDiscounts := make([]int64, len(products))
+	linePromotionIds := make([][]string, len(products))
+	for i := range products {
+		for _, promotion := range applied {
+			if chargedDiscounts[promotion.Id].Lines[i] == 0 && baseDiscounts[promotion.Id].Lines[i] == 0 {
+				continue
+			}
+			lineDiscounts[i] += chargedDiscounts[promotion.Id].Lines[i]
+			baseLineDiscounts[i] += baseDiscounts[promotion.Id].Lines[i]
+			linePromotionIds[i] = append(linePromotionIds[i], promotion.Id)
+		}
+	}
+
+	pointsRedeemed, loyaltyDiscount, err := applyLoyaltyPoints(checkout.LoyaltyPoints, customer, cartLines, baseCartLines, lineDiscounts, baseLineDiscounts, rate)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+
+	var orderProducts []entities.OrderProductStruct
+	var taxedLines, baseLines []pricing.TaxedLine
+	var subtotal, totalDiscount int64
+	for i, product := range products {
+		line, baseLine, vatRate := cartLines[i], baseCartLines[i], lineRates[i]
+		discount, baseDiscount, promotionIds := lineDiscounts[i], baseLineDiscounts[i], linePromotionIds[i]
+
+		subtotal += line.UnitPrice * line.Quantity
+		totalDiscount += discount
+
+		amounts := pricing.SplitGross(line.UnitPrice*line.Quantity-discount, vatRate.Rate)
+		baseAmounts := pricing.SplitGross(baseLine.UnitPrice*baseLine.Quantity-baseDiscount, vatRate.Rate)
+		taxedLines = append(taxedLines, pricing.TaxedLine{RateId: vatRate.Id, Rate: vatRate.Rate, Amounts: amounts})
+		baseLines = append(baseLines, pricing.TaxedLine{RateId: vatRate.Id, Rate: vatRate.Rate, Amounts: baseAmounts})
+
+		orderProducts = append(orderProducts, entities.OrderProductStruct{
+			ProductId:    product.Id,
+			Name:         product.Name,
+			Quantity:     int(line.Quantity),
+			Price:        entities.NewMoney(amounts.Gross, currency),
+			UnitPrice:    entities.NewMoney(line.UnitPrice, currency),
+			VatRateId:    vatRate.Id,
+			VatRate:      vatRate.Rate,
+			Net:          entities.NewMoney(amounts.Net, currency),
+			Tax:          entities.NewMoney(amounts.Tax, currency),
+			Gross:        entities.NewMoney(amounts.Gross, currency),
+			BasePrice:    entities.NewMoney(baseAmounts.Gross, entities.DefaultCurrency),
+			Discount:     entities.NewMoney(discount, currency),
+			PromotionIds: promotionIds,
+		})
+	}
+
+	discounts := make([]entities.AppliedDiscountStruct, 0, len(applied))
+	for _, promotion := range applied {
+		discounts = append(discounts, entities.AppliedDiscountStruct{
+			PromotionId:  promotion.Id,
+			Name:         promotion.Name,
+			Code:         promotion.Code,
+			DiscountType: promotion.DiscountType,
+			Amount:       entities.NewMoney(chargedDiscounts[promotion.Id].Total, currency),
+			BaseAmount:   entities.NewMoney(baseDiscounts[promotion.Id].Total, entities.DefaultCurrency),
+		})
+	}
+
+	groups, total := pricing.Summarize(taxedLines)
+	_, baseTotal := pricing.Summarize(baseLines)
+
+	taxBreakdown := make([]entities.TaxBreakdownStruct, 0, len(groups))
+	for _, group := range groups {
+		taxBreakdown = append(taxBreakdown, entities.TaxBreakdownStruct{
+			VatRateId: group.RateId,
+			Code:      vatRates[group.RateId].Code,
+			Rate:      group.Rate,
+			Net:       entities.NewMoney(group.Amounts.Net, currency),
+			Tax:       entities.NewMoney(group.Amounts.Tax, currency),
+			Gross:     entities.NewMoney(group.Amounts.Gross, currency),
+		})
+	}
+
+	order := entities.OrderStruct{
+		Date:          now,
+		Status:        "pending",
+		PaymentMethod: "PAYPAL",
+		Products:      orderProducts,
+	}
+
+	return entities.InvoiceStruct{
+		Date:                  now.Format(time.RFC3339),
+		TotalPrice:            entities.NewMoney(total.Gross, currency),
+		TotalNet:              entities.NewMoney(total.Net, currency),
+		TotalTax:              entities.NewMoney(total.Tax, currency),
+		TaxBreakdown:          taxBreakdown,
+		Currency:              currency,
+		ExchangeRate:          rate,
+		BaseTotalPrice:        entities.NewMoney(baseTotal.Gross, entities.DefaultCurrency),
+		BaseTotalNet:          entities.NewMoney(baseTotal.Net, entities.DefaultCurrency),
+		BaseTotalTax:          entities.NewMoney(baseTotal.Tax, entities.DefaultCurrency),
+		Subtotal:              entities.NewMoney(subtotal, currency),
+		TotalDiscount:         entities.NewMoney(totalDiscount, currency),
+		Discounts:             discounts,
+		LoyaltyPointsRedeemed: pointsRedeemed,
+		LoyaltyDiscount:       entities.NewMoney(loyaltyDiscount, currency),
+		Order:                 order,
+		Archived:              false,
+	}, nil
+}
+
+// applyLoyaltyPoints takes the points spent off what the promotions left of the lines, adding
+// to their discounts. Points beyond what the order is worth are not spent.
+func applyLoyaltyPoints(points int64, customer customerProfile, lines []pricing.CartLine, baseLines []pricing.CartLine, discounts []int64, baseDiscounts []int64, rate string) (int64, int64, error) {
+	if points <= 0 {
+		return 0, 0, nil
+	}
+	if points > customer.loyaltyPoints {
+		return 0, 0, fmt.Errorf("not enough loyalty points, %d available", customer.loyaltyPoints)
+	}
+
+	remaining := make([]int64, len(lines))
+	baseRemaining := make([]int64, len(lines))
+	var baseTotal int64
+	for i := range lines {
+		remaining[i] = lines[i].UnitPrice*lines[i].Quantity - discounts[i]
+		baseRemaining[i] = baseLines[i].UnitPrice*baseLines[i].Quantity - baseDiscounts[i]
+		baseTotal += baseRemaining[i]
+	}
+
+	pointValue := LoyaltyPointValue().Amount
+	points = min(points, baseTotal/pointValue)
+	if points == 0 {
+		return 0, 0, nil
+	}
+
+	baseAmount := points * pointValue
+	amount, err := pricing.Convert(baseAmount, rate)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for i, discount := range pricing.SpreadDiscount(remaining, amount) {
+		discounts[i] += discount
+		total += discount
+	}
+	for i, discount := range pricing.SpreadDiscount(baseRemaining, baseAmount) {
+		baseDiscounts[i] += discount
+	}
+	return points, total, nil
+}
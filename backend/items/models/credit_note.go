@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"trinity/backend/db"
+	"trinity/backend/invoicing"
+	"trinity/backend/items/entities"
+	"trinity/backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func getCreditNoteById(ctx context.Context, id string) (entities.CreditNoteStruct, error) {
+	collection := db.GetDatabase().Collection("credit_notes")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.CreditNoteStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	var note entities.CreditNoteStruct
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&note); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.CreditNoteStruct{}, fmt.Errorf("no credit note found with id: %s", id)
+		}
+		return entities.CreditNoteStruct{}, err
+	}
+	return note, nil
+}
+
+// commitCreditNoteNumber numbers a credit note, see commitDocumentNumber
+func commitCreditNoteNumber(ctx context.Context, counter documentCounter) error {
+	collection := db.GetDatabase().Collection("credit_notes")
+
+	objID, err := primitive.ObjectIDFromHex(counter.DocumentId)
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "number": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"number":   formatDocumentNumber(counter.Kind, counter.Year, counter.Seq),
+			"issuedAt": counter.IssuedAt,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to number credit note: %v", err)
+	}
+	return nil
+}
+
+// issueCreditNote credits an issued invoice in full. An invoice has a single credit note,
+// issuing it again returns the existing one.
+func issueCreditNote(userId string, invoice entities.InvoiceStruct, reason string) (entities.CreditNoteStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("credit_notes")
+
+	if invoice.Number == "" {
+		return entities.CreditNoteStruct{}, fmt.Errorf("invoice %s is not issued", invoice.Id)
+	}
+
+	var note entities.CreditNoteStruct
+	err := collection.FindOne(ctx, bson.M{"invoiceId": invoice.Id}).Decode(&note)
+	if err == mongo.ErrNoDocuments {
+		note = entities.CreditNoteStruct{
+			InvoiceId:      invoice.Id,
+			InvoiceNumber:  invoice.Number,
+			UserId:         userId,
+			Reason:         reason,
+			Seller:         invoice.Seller,
+			Buyer:          invoice.Buyer,
+			Lines:          invoice.Order.Products,
+			TaxBreakdown:   invoice.TaxBreakdown,
+			TotalPrice:     invoice.TotalPrice,
+			TotalNet:       invoice.TotalNet,
+			TotalTax:       invoice.TotalTax,
+			Currency:       invoice.Currency,
+			ExchangeRate:   invoice.ExchangeRate,
+			BaseTotalPrice: invoice.BaseTotalPrice,
+			BaseTotalNet:   invoice.BaseTotalNet,
+			BaseTotalTax:   invoice.BaseTotalTax,
+		}
+		inserted, err := collection.InsertOne(ctx, note)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return entities.CreditNoteStruct{}, fmt.Errorf("failed to create credit note: %v", err)
+		}
+		if err == nil {
+			note.Id = inserted.InsertedID.(primitive.ObjectID).Hex()
+		} else if err := collection.FindOne(ctx, bson.M{"invoiceId": invoice.Id}).Decode(&note); err != nil {
+			return entities.CreditNoteStruct{}, err
+		}
+	} else if err != nil {
+		return entities.CreditNoteStruct{}, err
+	}
+
+	if note.Number == "" {
+		err := allocateDocumentNumber(ctx, creditNoteDocument, userId, note.Id, func() (bool, error) {
+			note, err := getCreditNoteById(ctx, note.Id)
+			return note.Number != "", err
+		})
+		if err != nil {
+			return entities.CreditNoteStruct{}, err
+		}
+		if note, err = getCreditNoteById(ctx, note.Id); err != nil {
+			return entities.CreditNoteStruct{}, err
+		}
+	}
+
+	return renderCreditNotePDF(ctx, note)
+}
+
+// renderCreditNotePDF stores the PDF of a credit note, once: later calls return the stored one
+func renderCreditNotePDF(ctx context.Context, note entities.CreditNoteStruct) (entities.CreditNoteStruct, error) {
+	if note.PdfKey != "" || blobStore == nil {
+		return note, nil
+	}
+
+	data, err := invoicing.RenderCreditNotePDF(note)
+	if err != nil {
+		return note, err
+	}
+
+	key := fmt.Sprintf("credit-notes/%s.pdf", note.Number)
+	if err := blobStore.Put(ctx, key, data, "application/pdf"); err != nil {
+		return note, fmt.Errorf("failed to store credit note PDF: %v", err)
+	}
+
+	objID, err := primitive.ObjectIDFromHex(note.Id)
+	if err != nil {
+		return note, fmt.Errorf("invalid ID format")
+	}
+	_, err = db.GetDatabase().Collection("credit_notes").UpdateOne(ctx,
+		bson.M{"_id": objID, "pdfKey": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pdfKey": key}},
+	)
+	if err != nil {
+		return note, fmt.Errorf("failed to save credit note PDF: %v", err)
+	}
+	note.PdfKey = key
+	return note, nil
+}
+
+// GetCreditNotes lists the credit notes of a user, or of every user when userId is empty
+func GetCreditNotes(userId string) ([]entities.CreditNoteStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("credit_notes")
+
+	filter := bson.M{}
+	if userId != "" {
+		filter["userId"] = userId
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"issuedAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notes := []entities.CreditNoteStruct{}
+	if err := cursor.All(ctx, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// GetCreditNotePDF returns the PDF of a credit note of a user
+func GetCreditNotePDF(userId string, creditNoteId string) (entities.CreditNoteStruct, storage.Blob, error) {
+	ctx := context.TODO()
+
+	if blobStore == nil {
+		return entities.CreditNoteStruct{}, storage.Blob{}, fmt.Errorf("no blob store configured")
+	}
+
+	note, err := getCreditNoteById(ctx, creditNoteId)
+	if err != nil || note.UserId != userId {
+		return entities.CreditNoteStruct{}, storage.Blob{}, fmt.Errorf("no credit note found with id: %s", creditNoteId)
+	}
+	if note.Number == "" {
+		return entities.CreditNoteStruct{}, storage.Blob{}, fmt.Errorf("credit note %s is not issued yet", creditNoteId)
+	}
+	if note, err = renderCreditNotePDF(ctx, note); err != nil {
+		return entities.CreditNoteStruct{}, storage.Blob{}, err
+	}
+
+	blob, err := blobStore.Get(ctx, note.PdfKey)
+	if err != nil {
+		return entities.CreditNoteStruct{}, storage.Blob{}, err
+	}
+	return note, blob, nil
+}
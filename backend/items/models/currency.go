@@ -0,0 +1,160 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"trinity/backend/pricing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetExchangeRates() ([]entities.ExchangeRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("exchange_rates")
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rates := []entities.ExchangeRateStruct{}
+	if err := cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// GetExchangeRate returns how many units of currency one unit of the base currency is worth
+func GetExchangeRate(currency string) (string, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("exchange_rates")
+
+	if currency == entities.DefaultCurrency {
+		return "1", nil
+	}
+
+	var rate entities.ExchangeRateStruct
+	err := collection.FindOne(ctx, bson.M{"_id": currency}).Decode(&rate)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", fmt.Errorf("no exchange rate found for currency: %s", currency)
+		}
+		return "", err
+	}
+	return rate.Rate, nil
+}
+
+func SetExchangeRate(currency string, rate string, source string) (entities.ExchangeRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("exchange_rates")
+
+	currency = strings.ToUpper(currency)
+	if !pricing.ValidCurrencyCode(currency) {
+		return entities.ExchangeRateStruct{}, fmt.Errorf("invalid currency code: %s", currency)
+	}
+	if currency == entities.DefaultCurrency {
+		return entities.ExchangeRateStruct{}, fmt.Errorf("%s is the base currency", currency)
+	}
+	if _, err := pricing.ParseRate(rate); err != nil {
+		return entities.ExchangeRateStruct{}, err
+	}
+
+	exchangeRate := entities.ExchangeRateStruct{
+		Currency:  currency,
+		Rate:      rate,
+		Source:    source,
+		UpdatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": currency}, exchangeRate, options.Replace().SetUpsert(true))
+	if err != nil {
+		return entities.ExchangeRateStruct{}, fmt.Errorf("failed to save exchange rate: %v", err)
+	}
+	return exchangeRate, nil
+}
+
+// LoadExchangeRatesFile imports the rates of a JSON file, whose base must be the base currency
+func LoadExchangeRatesFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read exchange rates file: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var file entities.ExchangeRatesFileStruct
+	if err := decoder.Decode(&file); err != nil {
+		return 0, fmt.Errorf("invalid exchange rates file: %v", err)
+	}
+	if !strings.EqualFold(file.Base, entities.DefaultCurrency) {
+		return 0, fmt.Errorf("exchange rates file is based on %s, expected %s", file.Base, entities.DefaultCurrency)
+	}
+
+	loaded := 0
+	for currency, rate := range file.Rates {
+		if strings.EqualFold(currency, entities.DefaultCurrency) {
+			continue
+		}
+		if _, err := SetExchangeRate(currency, rate.String(), "file"); err != nil {
+			return loaded, err
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// checkBaseCurrency refuses catalog prices given in another currency than the base one
+func checkBaseCurrency(amounts ...entities.Money) error {
+	for _, amount := range amounts {
+		if amount.Currency != "" && amount.Currency != entities.DefaultCurrency {
+			return fmt.Errorf("prices must be in the base currency %s, got %s", entities.DefaultCurrency, amount.Currency)
+		}
+	}
+	return nil
+}
+
+// ConvertProductPrices shows catalog prices in one of the store currencies, the base one by default
+func ConvertProductPrices(products []entities.ProductStruct, currency string) ([]entities.ProductStruct, error) {
+	if currency == "" {
+		currency = entities.DefaultCurrency
+	}
+	if !pricing.IsStoreCurrency(currency) {
+		return nil, fmt.Errorf("unsupported currency: %s", currency)
+	}
+
+	rate, err := GetExchangeRate(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := make([]entities.ProductStruct, 0, len(products))
+	for _, product := range products {
+		priceVat, err := pricing.Convert(product.PriceVat.Amount, rate)
+		if err != nil {
+			return nil, err
+		}
+		priceNot, err := pricing.Convert(product.PriceNot.Amount, rate)
+		if err != nil {
+			return nil, err
+		}
+		product.PriceVat = entities.NewMoney(priceVat, currency)
+		product.PriceNot = entities.NewMoney(priceNot, currency)
+		product.Currency = currency
+		converted = append(converted, product)
+	}
+	return converted, nil
+}
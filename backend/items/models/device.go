@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RegisterDevice records a device of a user, or refreshes it when the app registers it again.
+// A token registered by another user before, e.g. on a shared phone, moves to this one.
+func RegisterDevice(userId string, device entities.DeviceStruct) (entities.DeviceStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("devices")
+
+	now := time.Now().UTC()
+	set := bson.M{"userId": userId, "lastSeenAt": now}
+	if device.Platform != "" {
+		set["platform"] = device.Platform
+	}
+	if device.AppVersion != "" {
+		set["appVersion"] = device.AppVersion
+	}
+
+	var registered entities.DeviceStruct
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": device.Token},
+		bson.M{"$set": set, "$setOnInsert": bson.M{"registeredAt": now}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&registered)
+	if err != nil {
+		return entities.DeviceStruct{}, fmt.Errorf("failed to register device: %v", err)
+	}
+	return registered, nil
+}
+
+// UnregisterDevice removes a device of a user, when they log out of the app
+func UnregisterDevice(userId string, token string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("devices")
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": token, "userId": userId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no device found with token: %s", token)
+	}
+	return nil
+}
+
+func GetUserDevices(userId string) ([]entities.DeviceStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("devices")
+
+	cursor, err := collection.Find(ctx, bson.M{"userId": userId}, options.Find().SetSort(bson.M{"lastSeenAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []entities.DeviceStruct{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// getUsersDevices groups the devices of users by user ID
+func getUsersDevices(ctx context.Context, userIds []string) (map[string][]entities.DeviceStruct, error) {
+	collection := db.GetDatabase().Collection("devices")
+
+	cursor, err := collection.Find(ctx, bson.M{"userId": bson.M{"$in": userIds}})
+	if err != nil {
+		return nil, err
+	}
+	var devices []entities.DeviceStruct
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+
+	byUser := map[string][]entities.DeviceStruct{}
+	for _, device := range devices {
+		byUser[device.UserId] = append(byUser[device.UserId], device)
+	}
+	return byUser, nil
+}
+
+// removeDevice forgets a token FCM no longer accepts, unless it was registered again since
+func removeDevice(ctx context.Context, token string, since time.Time) error {
+	collection := db.GetDatabase().Collection("devices")
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": token, "lastSeenAt": bson.M{"$lte": since}})
+	return err
+}
+
+// MigrateDeviceTokens moves the single device token users had into the devices collection
+func MigrateDeviceTokens() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	cursor, err := collection.Find(ctx,
+		bson.M{"deviceToken": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"deviceToken": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var users []struct {
+		Id          primitive.ObjectID `bson:"_id"`
+		DeviceToken string             `bson:"deviceToken"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, user := range users {
+		if user.DeviceToken != "" {
+			if _, err := RegisterDevice(user.Id.Hex(), entities.DeviceStruct{Token: user.DeviceToken}); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.Id}, bson.M{"$unset": bson.M{"deviceToken": ""}}); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
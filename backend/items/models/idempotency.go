@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotencyKeyTTL is how long a response is kept for replay, from IDEMPOTENCY_KEY_TTL
+// (e.g. "24h", the default)
+func IdempotencyKeyTTL() time.Duration {
+	ttl := 24 * time.Hour
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid IDEMPOTENCY_KEY_TTL %q, using %v: %v", value, ttl, err)
+		} else {
+			ttl = parsed
+		}
+	}
+	return ttl
+}
+
+// idempotencyLock is how long a request has to complete under its key. A retry sent after
+// that takes the key over, in case the server processing the first one stopped.
+const idempotencyLock = time.Minute
+
+// ReserveIdempotencyKey records that a request is being processed under a key. When the key
+// was already used, the existing record is returned instead and reserved is false.
+func ReserveIdempotencyKey(userId string, key string, fingerprint string) (entities.IdempotencyKeyStruct, bool, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("idempotency_keys")
+
+	// Stored with millisecond precision, the lock identifies who holds the key
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := entities.IdempotencyKeyStruct{
+		Id:          userId + ":" + key,
+		UserId:      userId,
+		Key:         key,
+		Fingerprint: fingerprint,
+		Status:      "processing",
+		LockedUntil: now.Add(idempotencyLock),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(IdempotencyKeyTTL()),
+	}
+
+	_, err := collection.InsertOne(ctx, record)
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return entities.IdempotencyKeyStruct{}, false, err
+	}
+
+	// Take over the same request left processing past its lock
+	var existing entities.IdempotencyKeyStruct
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": record.Id, "fingerprint": fingerprint, "status": "processing", "lockedUntil": bson.M{"$not": bson.M{"$gt": now}}},
+		bson.M{"$set": bson.M{"lockedUntil": record.LockedUntil}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&existing)
+	if err == nil {
+		return existing, true, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return entities.IdempotencyKeyStruct{}, false, err
+	}
+
+	if err := collection.FindOne(ctx, bson.M{"_id": record.Id}).Decode(&existing); err != nil {
+		// Expired or released in between
+		if err == mongo.ErrNoDocuments {
+			return ReserveIdempotencyKey(userId, key, fingerprint)
+		}
+		return entities.IdempotencyKeyStruct{}, false, err
+	}
+	return existing, false, nil
+}
+
+// heldIdempotencyKey matches a key only while the request that reserved it still holds it
+func heldIdempotencyKey(record entities.IdempotencyKeyStruct) bson.M {
+	return bson.M{"_id": record.Id, "status": "processing", "lockedUntil": record.LockedUntil}
+}
+
+// CompleteIdempotencyKey stores the response of a request, to replay it to later retries
+func CompleteIdempotencyKey(record entities.IdempotencyKeyStruct, status int, contentType string, body []byte) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("idempotency_keys")
+
+	result, err := collection.UpdateOne(ctx, heldIdempotencyKey(record), bson.M{
+		"$set": bson.M{
+			"status":         "completed",
+			"responseStatus": status,
+			"contentType":    contentType,
+			"responseBody":   body,
+		},
+		"$unset": bson.M{"lockedUntil": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no held idempotency key found with id: %s", record.Id)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a request that failed on the server side, so that it can be
+// retried with the same key. A key taken over by a retry is left to it.
+func ReleaseIdempotencyKey(record entities.IdempotencyKeyStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("idempotency_keys")
+
+	_, err := collection.DeleteOne(ctx, heldIdempotencyKey(record))
+	return err
+}
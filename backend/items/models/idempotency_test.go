@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIdempotencyKeyTakenOverAfterItsLock(t *testing.T) {
+	dbtest.Setup(t)
+
+	first, reserved, err := ReserveIdempotencyKey("user", "key", "fingerprint")
+	if err != nil || !reserved {
+		t.Fatalf("got reserved %v (%v), want the key reserved", reserved, err)
+	}
+	if _, reserved, _ := ReserveIdempotencyKey("user", "key", "fingerprint"); reserved {
+		t.Fatal("reserved a key still being processed")
+	}
+
+	// The server processing the first request stopped
+	_, err = db.GetDatabase().Collection("idempotency_keys").UpdateOne(context.Background(),
+		bson.M{"_id": first.Id},
+		bson.M{"$set": bson.M{"lockedUntil": time.Now().UTC().Add(-time.Second)}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, reserved, _ := ReserveIdempotencyKey("user", "key", "other"); reserved {
+		t.Fatal("another request took the key over")
+	}
+	retry, reserved, err := ReserveIdempotencyKey("user", "key", "fingerprint")
+	if err != nil || !reserved {
+		t.Fatalf("got reserved %v (%v), want the retry to take the key over", reserved, err)
+	}
+
+	// The first request can no longer complete nor release the key
+	if err := CompleteIdempotencyKey(first, 201, "application/json", []byte("{}")); err == nil {
+		t.Error("the first request completed a key taken over")
+	}
+	if err := CompleteIdempotencyKey(retry, 200, "application/json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	record, reserved, err := ReserveIdempotencyKey("user", "key", "fingerprint")
+	if err != nil || reserved {
+		t.Fatalf("got reserved %v (%v), want the stored response", reserved, err)
+	}
+	if record.Status != "completed" || record.ResponseStatus != 200 {
+		t.Errorf("got %s with %d, want the response of the retry", record.Status, record.ResponseStatus)
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"time"
 	"trinity/backend/db"
 	"trinity/backend/items/entities"
 
 	echo "github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func CreateInvoiceSelf(c echo.Context, i entities.InvoiceStruct) (entities.InvoiceStruct, error) {
@@ -21,6 +22,11 @@ func CreateInvoiceSelf(c echo.Context, i entities.InvoiceStruct) (entities.Invoi
 	// Generate a unique ID for the invoice
 	i.Id = primitive.NewObjectID().Hex()
 
+	// The order is cancelled if it is not paid in time
+	if i.Order.Status == "pending" {
+		i.ExpiresAt = time.Now().UTC().Add(PendingOrderTTL()).Format(time.RFC3339)
+	}
+
 	userId := c.Get("user").(entities.UserBasicStruct).Id
 
 	// Convert string ID to ObjectID
@@ -42,15 +48,38 @@ func CreateInvoiceSelf(c echo.Context, i entities.InvoiceStruct) (entities.Invoi
 		return entities.InvoiceStruct{}, fmt.Errorf("user not found or invoice not added")
 	}
 
-	invoiceCreated, err := getLastCreatedInvoiceForUserId(userId)
-	if err != nil {
-		return entities.InvoiceStruct{}, fmt.Errorf("failed to retrieve inserted invoice: %v", err)
+	return i, nil
+}
+
+// PendingOrderTTL is how long an order may wait for its payment, from PENDING_ORDER_TTL
+// (e.g. "30m", the default)
+func PendingOrderTTL() time.Duration {
+	ttl := 30 * time.Minute
+	if value := os.Getenv("PENDING_ORDER_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid PENDING_ORDER_TTL %q, using %v: %v", value, ttl, err)
+		} else {
+			ttl = parsed
+		}
 	}
+	return ttl
+}
 
-	return invoiceCreated, nil
+// GetUserInvoice finds an invoice of a user
+func GetUserInvoice(userId string, invoiceId string) (entities.InvoiceStruct, error) {
+	ownerId, invoice, err := GetInvoiceById(invoiceId)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+	if ownerId != userId {
+		return entities.InvoiceStruct{}, fmt.Errorf("no invoice found with id: %s", invoiceId)
+	}
+	return invoice, nil
 }
 
-func getLastCreatedInvoiceForUserId(userId string) (entities.InvoiceStruct, error) {
+// GetLastPendingInvoice returns the most recent order of a user still waiting for its payment
+func GetLastPendingInvoice(userId string) (entities.InvoiceStruct, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("users")
@@ -60,12 +89,12 @@ func getLastCreatedInvoiceForUserId(userId string) (entities.InvoiceStruct, erro
 		return entities.InvoiceStruct{}, fmt.Errorf("invalid user ID format: %v", err)
 	}
 
-	// Aggregate pipeline to get the most recent invoice by date
 	pipeline := []bson.M{
 		{"$match": bson.M{"_id": objUserId}},
 		{"$unwind": "$invoices"},
-		{"$sort": bson.M{"invoices.date": -1}}, // Sort by date in descending order
-		{"$limit": 1},                          // Get only the first (most recent) invoice
+		{"$match": bson.M{"invoices.order.status": "pending"}},
+		{"$sort": bson.M{"invoices.order.date": -1}},
+		{"$limit": 1},
 		{"$replaceRoot": bson.M{"newRoot": "$invoices"}},
 	}
 
@@ -82,56 +111,163 @@ func getLastCreatedInvoiceForUserId(userId string) (entities.InvoiceStruct, erro
 		}
 		return invoice, nil
 	}
+	return entities.InvoiceStruct{}, fmt.Errorf("no pending invoice found for user")
+}
 
-	return entities.InvoiceStruct{}, fmt.Errorf("no invoices found for user")
+// closePendingInvoice moves an order out of the pending status, only if it is still pending so
+// that an order paid meanwhile is never touched
+func closePendingInvoice(userId string, invoiceId string, set bson.M) error {
+	return closeInvoice(userId, invoiceId, "pending", set)
 }
 
-func CancelLastInvoice(c echo.Context) (entities.InvoiceStruct, error) {
+// closeInvoice moves an order out of status, only if it still has it
+func closeInvoice(userId string, invoiceId string, status string, set bson.M) error {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("users")
 
-	userId := c.Get("user").(entities.UserBasicStruct).Id
-
 	objUserId, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		return entities.InvoiceStruct{}, fmt.Errorf("invalid user ID format: %v", err)
+		return fmt.Errorf("invalid user ID format: %v", err)
 	}
 
-	// Aggregate pipeline to get the most recent invoice by date
-	pipeline := []bson.M{
-		{"$match": bson.M{"_id": objUserId}},
-		{"$unwind": "$invoices"},
-		{"$sort": bson.M{"invoices.date": -1}}, // Sort by date in descending order
-		{"$limit": 1},                          // Get only the first (most recent) invoice
-		{"$replaceRoot": bson.M{"newRoot": "$invoices"}},
+	update := bson.M{}
+	for field, value := range set {
+		update["invoices.$."+field] = value
 	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objUserId, "invoices": bson.M{"$elemMatch": bson.M{"_id": invoiceId, "order.status": status}}},
+		bson.M{"$set": update},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update invoice: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no %s invoice found with id: %s", status, invoiceId)
+	}
+	return nil
+}
 
-	cursor, err := collection.Aggregate(ctx, pipeline)
+// CancelPendingInvoice cancels an order that was not paid and gives back its loyalty points
+// and promotion redemptions
+func CancelPendingInvoice(userId string, invoice entities.InvoiceStruct) error {
+	if err := closePendingInvoice(userId, invoice.Id, bson.M{"order.status": "cancelled", "archived": true}); err != nil {
+		return err
+	}
+	if err := releaseLoyaltyPoints(userId, invoice); err != nil {
+		return fmt.Errorf("failed to give back loyalty points: %v", err)
+	}
+	if err := releasePromotionRedemptions(userId, invoice); err != nil {
+		return fmt.Errorf("failed to give back promotion redemptions: %v", err)
+	}
+	return nil
+}
+
+// MarkInvoicePaid records the payment of a pending order, or of an expired one whose
+// reservations were reclaimed
+func MarkInvoicePaid(userId string, invoice entities.InvoiceStruct) (entities.InvoiceStruct, error) {
+	status := "pending"
+	if invoice.Order.Status == "expired" {
+		status = "expired"
+	}
+	err := closeInvoice(userId, invoice.Id, status, bson.M{
+		"order.status":        "paid",
+		"loyaltyPointsEarned": invoice.LoyaltyPointsEarned,
+	})
 	if err != nil {
-		return entities.InvoiceStruct{}, fmt.Errorf("failed to query database: %v", err)
+		return entities.InvoiceStruct{}, err
 	}
-	defer cursor.Close(ctx)
+	previous := invoice.Order.Status
+	invoice.Order.Status = "paid"
+	orderStatusChanged(userId, invoice, previous)
+	return invoice, nil
+}
 
-	var invoice entities.InvoiceStruct
-	if cursor.Next(ctx) {
-		if err := cursor.Decode(&invoice); err != nil {
-			return entities.InvoiceStruct{}, fmt.Errorf("failed to decode invoice: %v", err)
+// invoiceExpiresAt is when a pending order expires
+func invoiceExpiresAt(invoice entities.InvoiceStruct) time.Time {
+	expiresAt, err := time.Parse(time.RFC3339, invoice.ExpiresAt)
+	if err != nil {
+		return invoice.Order.Date.Add(PendingOrderTTL())
+	}
+	return expiresAt
+}
+
+// lateCaptureWindow is how long after it expired an order can still be paid, for payments
+// approved on PayPal right before the expiry
+const lateCaptureWindow = 15 * time.Minute
+
+// ReclaimExpiredInvoice takes back the loyalty points and promotion uses an order gave back
+// when it expired, so that its payment can still be captured. It fails if the order expired
+// too long ago or if they are no longer available.
+func ReclaimExpiredInvoice(userId string, invoice entities.InvoiceStruct) error {
+	if invoice.Order.Status != "expired" {
+		return fmt.Errorf("no expired invoice found with id: %s", invoice.Id)
+	}
+	if time.Since(invoiceExpiresAt(invoice)) > lateCaptureWindow {
+		return fmt.Errorf("order %s expired at %s", invoice.Id, invoiceExpiresAt(invoice).Format(time.RFC3339))
+	}
+
+	if err := reclaimLoyaltyPoints(userId, invoice); err != nil {
+		return err
+	}
+	if err := ReservePromotionRedemptions(userId, invoice); err != nil {
+		if releaseErr := releasePromotionRedemptions(userId, invoice); releaseErr != nil {
+			log.Printf("Failed to give back promotion redemptions of expired invoice %s: %v", invoice.Id, releaseErr)
 		}
-	} else {
-		return entities.InvoiceStruct{}, fmt.Errorf("no invoices found for user")
+		if undoErr := undoReclaimLoyaltyPoints(userId, invoice); undoErr != nil {
+			log.Printf("Failed to give back loyalty points of expired invoice %s: %v", invoice.Id, undoErr)
+		}
+		return err
 	}
+	return nil
+}
 
-	// Update the invoice using the string ID
-	invoiceModified := invoice
-	invoiceModified.Order.Status = "cancelled"
-	invoiceModified.Archived = true
-	updatedInvoice, err := UpdateInvoiceSelf(c, invoiceModified)
+// ExpirePendingInvoices cancels the orders not paid in time, giving back their loyalty points
+// and promotion redemptions.
+// Orders created before they had an expiry date expire PendingOrderTTL after they were made.
+func ExpirePendingInvoices() error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"invoices.order.status": "pending"}},
+		{"$unwind": "$invoices"},
+		{"$match": bson.M{"invoices.order.status": "pending"}},
+		{"$project": bson.M{"invoice": "$invoices"}},
+	})
 	if err != nil {
-		return entities.InvoiceStruct{}, fmt.Errorf("failed to cancel invoice: %v", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var pending []struct {
+		UserId  primitive.ObjectID     `bson:"_id"`
+		Invoice entities.InvoiceStruct `bson:"invoice"`
+	}
+	if err := cursor.All(ctx, &pending); err != nil {
+		return err
 	}
 
-	return updatedInvoice, nil
+	now := time.Now()
+	for _, order := range pending {
+		if now.Before(invoiceExpiresAt(order.Invoice)) {
+			continue
+		}
+
+		userId := order.UserId.Hex()
+		if err := closePendingInvoice(userId, order.Invoice.Id, bson.M{"order.status": "expired"}); err != nil {
+			// Paid or cancelled meanwhile
+			continue
+		}
+		if err := releaseLoyaltyPoints(userId, order.Invoice); err != nil {
+			log.Printf("Failed to give back loyalty points of expired invoice %s: %v", order.Invoice.Id, err)
+		}
+		if err := releasePromotionRedemptions(userId, order.Invoice); err != nil {
+			log.Printf("Failed to give back promotion redemptions of expired invoice %s: %v", order.Invoice.Id, err)
+		}
+	}
+	return nil
 }
 
 func GetInvoices(start int, quantity int) ([]entities.InvoiceStruct, error) {
@@ -155,17 +291,29 @@ func GetInvoices(start int, quantity int) ([]entities.InvoiceStruct, error) {
 	return invoices, nil
 }
 
-func GetEarnings() (float64, error) {
+// baseTotalPriceAmount is an invoice total in base currency cents. Invoices issued before
+// multi-currency support have no base equivalent and were charged in the base currency.
+var baseTotalPriceAmount = bson.M{"$ifNull": []any{"$invoices.baseTotalPrice.amount", "$invoices.totalPrice.amount"}}
+
+// paidInvoicesMatch keeps the paid orders, and those cancelled or refunded once paid: only
+// paid orders are issued, and their credit notes are to be subtracted
+var paidInvoicesMatch = bson.M{"$or": []bson.M{
+	{"invoices.order.status": bson.M{"$exists": true, "$nin": unpaidOrderStatuses}},
+	{"invoices.number": bson.M{"$exists": true, "$ne": ""}},
+}}
+
+func GetEarnings() (entities.Money, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("users")
 	cursor, err := collection.Aggregate(ctx, []bson.M{
 		// Unwind the invoices array from each user document
 		{"$unwind": "$invoices"},
-		// Group all invoices (using a null _id) and sum the TotalPrice field
+		{"$match": paidInvoicesMatch},
+		// Group all invoices (using a null _id) and sum their cents in the base currency
 		{"$group": bson.M{
 			"_id":           nil,
-			"totalEarnings": bson.M{"$sum": "$invoices.totalPrice"},
+			"totalEarnings": bson.M{"$sum": baseTotalPriceAmount},
 		}},
 	})
 	if err != nil {
@@ -177,13 +325,44 @@ func GetEarnings() (float64, error) {
 		log.Fatalf("Cursor error: %v", err)
 	}
 
-	if len(results) > 0 {
-		fmt.Println("Total Earnings:", results[0]["totalEarnings"])
-	} else {
+	if len(results) == 0 {
 		fmt.Println("No invoices found")
+		return entities.NewMoney(0, entities.DefaultCurrency), nil
 	}
+	fmt.Println("Total Earnings:", results[0]["totalEarnings"])
 
-	return results[0]["totalEarnings"].(float64), nil
+	credited, _, err := getCreditedTotal(ctx)
+	if err != nil {
+		return entities.Money{}, err
+	}
+
+	return entities.NewMoney(toInt64(results[0]["totalEarnings"])-credited, entities.DefaultCurrency), nil
+}
+
+// getCreditedTotal sums the credit notes in base currency cents and counts them, the invoices
+// they cancel stay in the earnings so that both are netted out
+func getCreditedTotal(ctx context.Context) (int64, int64, error) {
+	collection := db.GetDatabase().Collection("credit_notes")
+
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$group": bson.M{
+			"_id":           nil,
+			"totalCredited": bson.M{"$sum": bson.M{"$ifNull": []any{"$baseTotalPrice.amount", "$totalPrice.amount"}}},
+			"count":         bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var results []bson.M
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, 0, err
+	}
+	if len(results) == 0 {
+		return 0, 0, nil
+	}
+	return toInt64(results[0]["totalCredited"]), toInt64(results[0]["count"]), nil
 }
 
 // GetUserInvoiceDateAndVAT retrieves the date and total VAT price of all invoices for a specific user
@@ -204,6 +383,7 @@ func GetUserInvoiceDateAndVAT(userId string) ([]entities.UserInvoiceSummary, err
 			"_id":        "$invoices._id",
 			"date":       "$invoices.date",
 			"totalPrice": "$invoices.totalPrice",
+			"currency":   "$invoices.currency",
 		}},
 	}
 
@@ -221,17 +401,19 @@ func GetUserInvoiceDateAndVAT(userId string) ([]entities.UserInvoiceSummary, err
 	return summaries, nil
 }
 
-func GetAverageSpending() (float64, error) {
+func GetAverageSpending() (entities.Money, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("users")
 	pipeline := []bson.M{
 		// Unwind the invoices array from each user document
 		{"$unwind": "$invoices"},
-		// Group all invoices together (using _id: nil) and calculate the average TotalPrice
+		{"$match": paidInvoicesMatch},
+		// Group all invoices together (using _id: nil) to sum and count them
 		{"$group": bson.M{
-			"_id":            nil,
-			"averageInvoice": bson.M{"$avg": "$invoices.totalPrice"},
+			"_id":   nil,
+			"total": bson.M{"$sum": baseTotalPriceAmount},
+			"count": bson.M{"$sum": 1},
 		}},
 	}
 
@@ -245,12 +427,25 @@ func GetAverageSpending() (float64, error) {
 		log.Fatalf("Cursor error: %v", err)
 	}
 
-	if len(results) > 0 {
-		fmt.Println("Average per invoice:", results[0]["averageInvoice"])
-	} else {
+	if len(results) == 0 {
 		fmt.Println("No invoices found")
+		return entities.NewMoney(0, entities.DefaultCurrency), nil
+	}
+
+	// Credit notes cancel whole invoices, which no longer count as spending
+	credited, creditedCount, err := getCreditedTotal(ctx)
+	if err != nil {
+		return entities.Money{}, err
+	}
+	total := toInt64(results[0]["total"]) - credited
+	count := toInt64(results[0]["count"]) - creditedCount
+	if count <= 0 {
+		return entities.NewMoney(0, entities.DefaultCurrency), nil
 	}
-	return results[0]["averageInvoice"].(float64), nil
+	fmt.Println("Average per invoice:", float64(total)/float64(count)/100)
+
+	// The average of cents is rarely a whole number of cents
+	return entities.MoneyFromFloat(float64(total)/float64(count)/100, entities.DefaultCurrency), nil
 }
 
 func GetTotalProductSold() (int32, error) {
@@ -287,96 +482,26 @@ func GetTotalProductSold() (int32, error) {
 	return results[0]["totalProductSold"].(int32), nil
 }
 
+// ArchiveInvoiceById hides an invoice that was never issued, an issued one is corrected with
+// a credit note instead
 func ArchiveInvoiceById(id string) error {
-	conn := db.GetDatabase()
-	ctx := context.TODO()
-	collection := conn.Collection("invoices")
-
-	// Convert string ID to ObjectID
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return fmt.Errorf("invalid ID format")
-	}
-
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"archived": true}})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func UpdateInvoiceSelf(c echo.Context, i entities.InvoiceStruct) (entities.InvoiceStruct, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("users")
 
-	// Get user ID from context
-	userId := c.Get("user").(entities.UserBasicStruct).Id
-
-	// Convert user ID string to ObjectID
-	userObjID, err := primitive.ObjectIDFromHex(userId)
-	if err != nil {
-		return entities.InvoiceStruct{}, fmt.Errorf("invalid user ID format: %v", err)
-	}
-
-	// Filter to find the user document
-	filter := bson.M{
-		"_id": userObjID,
-	}
-
-	// Prepare update operation with the entire invoice struct
-	update := bson.M{
-		"$set": bson.M{
-			"invoices.$[elem]": i,
-		},
-	}
-
-	// Use ArrayFilters to match the specific invoice by its ID
-	opts := options.UpdateOptions{
-		ArrayFilters: &options.ArrayFilters{
-			Filters: []interface{}{
-				bson.M{"elem._id": i.Id},
-			},
-		},
-	}
-
-	// Perform the update
-	result, err := collection.UpdateOne(ctx, filter, update, &opts)
+	_, invoice, err := GetInvoiceById(id)
 	if err != nil {
-		return entities.InvoiceStruct{}, fmt.Errorf("failed to update invoice: %v", err)
+		return err
 	}
-
-	// Check if any document was modified
-	if result.ModifiedCount == 0 {
-		// Add debug information to help diagnose the issue
-		fmt.Printf("Update failed: User ID: %s, Invoice ID: %s\n", userId, i.Id)
-
-		// Check if the invoice exists for this user
-		var user struct {
-			Invoices []entities.InvoiceStruct `bson:"invoices"`
-		}
-
-		err := collection.FindOne(ctx, bson.M{"_id": userObjID}).Decode(&user)
-		if err != nil {
-			return entities.InvoiceStruct{}, fmt.Errorf("failed to find user: %v", err)
-		}
-
-		found := false
-		for _, inv := range user.Invoices {
-			if inv.Id == i.Id {
-				found = true
-				break
-			}
-		}
-
-		if found {
-			return entities.InvoiceStruct{}, fmt.Errorf("invoice found but update failed for ID %s", i.Id)
-		} else {
-			return entities.InvoiceStruct{}, fmt.Errorf("no invoice found with ID %s for user %s", i.Id, userId)
-		}
+	if invoice.Number != "" {
+		return fmt.Errorf("invoice %s is issued, cancel or refund it instead", invoice.Number)
 	}
 
-	return i, nil
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"invoices": bson.M{"$elemMatch": bson.M{"_id": id, "number": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"invoices.$.archived": true}},
+	)
+	return err
 }
 
 // GetUserInvoiceById retrieves a specific invoice by its ID from a specific user
@@ -434,8 +559,17 @@ func GetUserInvoiceById(userId string, invoiceId string) (entities.InvoiceOrderS
 									},
 								},
 							},
-							"quantity": "$$prod.quantity",
-							"price":    "$$prod.price",
+							"quantity":     "$$prod.quantity",
+							"price":        "$$prod.price",
+							"unitPrice":    "$$prod.unitPrice",
+							"vatRateId":    "$$prod.vatRateId",
+							"vatRate":      "$$prod.vatRate",
+							"net":          "$$prod.net",
+							"tax":          "$$prod.tax",
+							"gross":        "$$prod.gross",
+							"basePrice":    "$$prod.basePrice",
+							"discount":     "$$prod.discount",
+							"promotionIds": "$$prod.promotionIds",
 						},
 					},
 				},
@@ -463,3 +597,100 @@ func GetUserInvoiceById(userId string, invoiceId string) (entities.InvoiceOrderS
 
 	return results[0], nil
 }
+
+// GetInvoiceById finds an invoice of any user, along with the id of that user
+func GetInvoiceById(invoiceId string) (string, entities.InvoiceStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"invoices._id": invoiceId}},
+		{"$unwind": "$invoices"},
+		{"$match": bson.M{"invoices._id": invoiceId}},
+		{"$project": bson.M{"invoice": "$invoices"}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return "", entities.InvoiceStruct{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		UserId  primitive.ObjectID     `bson:"_id"`
+		Invoice entities.InvoiceStruct `bson:"invoice"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return "", entities.InvoiceStruct{}, err
+	}
+	if len(results) == 0 {
+		return "", entities.InvoiceStruct{}, fmt.Errorf("no invoice found with id: %s", invoiceId)
+	}
+	return results[0].UserId.Hex(), results[0].Invoice, nil
+}
+
+// RefundInvoice marks a paid order as refunded, reverses its loyalty points and issues its
+// credit note. The money itself is sent back through the payment provider.
+func RefundInvoice(invoiceId string) (entities.InvoiceStruct, error) {
+	return creditInvoice(invoiceId, "refunded", entities.CreditNoteRefund)
+}
+
+// CancelPaidInvoice cancels a paid order that will not be delivered, the same way as a refund
+func CancelPaidInvoice(invoiceId string) (entities.InvoiceStruct, error) {
+	return creditInvoice(invoiceId, "cancelled", entities.CreditNoteCancellation)
+}
+
+// creditInvoice moves a paid order to status and credits its invoice. The invoice is issued
+// first so that the credit note has a number to refer to. Calling it again for an order
+// already in status finishes what a failure left behind.
+func creditInvoice(invoiceId string, status string, reason string) (entities.InvoiceStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	userId, invoice, err := GetInvoiceById(invoiceId)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+
+	if invoice.Order.Status != status {
+		if !isOrderPaid(invoice.Order.Status) {
+			return entities.InvoiceStruct{}, fmt.Errorf("only paid orders can be %s, order is %s", status, invoice.Order.Status)
+		}
+
+		if invoice, err = IssueInvoice(userId, invoiceId); err != nil {
+			return entities.InvoiceStruct{}, fmt.Errorf("failed to issue invoice: %v", err)
+		}
+
+		// Only the first of concurrent updates matches the paid status
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"invoices": bson.M{"$elemMatch": bson.M{"_id": invoiceId, "order.status": invoice.Order.Status}}},
+			bson.M{"$set": bson.M{"invoices.$.order.status": status}},
+		)
+		if err != nil {
+			return entities.InvoiceStruct{}, fmt.Errorf("failed to update invoice: %v", err)
+		}
+		if result.ModifiedCount == 0 {
+			return entities.InvoiceStruct{}, fmt.Errorf("invoice %s was updated meanwhile", invoiceId)
+		}
+		previous := invoice.Order.Status
+		invoice.Order.Status = status
+		orderStatusChanged(userId, invoice, previous)
+	}
+
+	if err := reverseLoyaltyPoints(userId, invoice); err != nil {
+		return invoice, fmt.Errorf("invoice %s but failed to reverse loyalty points: %v", status, err)
+	}
+	if err := refundPromotionRedemptions(invoice); err != nil {
+		return invoice, fmt.Errorf("invoice %s but failed to update promotion stats: %v", status, err)
+	}
+	note, err := issueCreditNote(userId, invoice, reason)
+	if err != nil {
+		return invoice, fmt.Errorf("invoice %s but failed to issue credit note: %v", status, err)
+	}
+	if err := queueRefundNotice(userId, invoice, note); err != nil {
+		log.Printf("Failed to queue the refund notice of invoice %s: %v", invoiceId, err)
+	}
+	return invoice, nil
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/invoicing"
+	"trinity/backend/items/entities"
+	"trinity/backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Documents numbered in their own sequence every year
+const (
+	invoiceDocument    = "invoice"
+	creditNoteDocument = "credit-note"
+)
+
+// documentCounter hands out the numbers of a kind of document for a year. A number is
+// allocated by moving seq to the document being issued and only committed once that document
+// holds it, so that a number is never skipped nor given twice, even when issuing is
+// interrupted.
+type documentCounter struct {
+	Id         string `bson:"_id"`
+	Kind       string `bson:"kind"`
+	Year       int    `bson:"year"`
+	Seq        int64  `bson:"seq"`
+	Committed  bool   `bson:"committed"`
+	UserId     string `bson:"userId,omitempty"`
+	DocumentId string `bson:"documentId,omitempty"`
+	IssuedAt   string `bson:"issuedAt,omitempty"`
+}
+
+const documentNumberAttempts = 20
+
+func formatDocumentNumber(kind string, year int, seq int64) string {
+	if kind == creditNoteDocument {
+		return fmt.Sprintf("CN-%d-%06d", year, seq)
+	}
+	return fmt.Sprintf("%d-%06d", year, seq)
+}
+
+// invoiceSeller is the company issuing the invoices, from the INVOICE_SELLER_* variables
+func invoiceSeller() entities.InvoicePartyStruct {
+	return entities.InvoicePartyStruct{
+		Name:       os.Getenv("INVOICE_SELLER_NAME"),
+		Address:    os.Getenv("INVOICE_SELLER_ADDRESS"),
+		PostalCode: os.Getenv("INVOICE_SELLER_POSTAL_CODE"),
+		City:       os.Getenv("INVOICE_SELLER_CITY"),
+		Country:    os.Getenv("INVOICE_SELLER_COUNTRY"),
+		Email:      os.Getenv("INVOICE_SELLER_EMAIL"),
+		VatNumber:  os.Getenv("INVOICE_SELLER_VAT_NUMBER"),
+		CompanyId:  os.Getenv("INVOICE_SELLER_COMPANY_ID"),
+	}
+}
+
+func invoiceBuyer(user entities.UserStruct) entities.InvoicePartyStruct {
+	return entities.InvoicePartyStruct{
+		Name:       user.FirstName + " " + user.LastName,
+		Address:    user.Address,
+		PostalCode: user.City.PostalCode,
+		City:       user.City.Name,
+		Country:    user.City.Country,
+		Email:      user.Email,
+	}
+}
+
+// commitDocumentNumber gives the document the number allocated to it, then marks the number
+// as used
+func commitDocumentNumber(ctx context.Context, counter documentCounter) error {
+	counters := db.GetDatabase().Collection("counters")
+
+	var err error
+	switch counter.Kind {
+	case creditNoteDocument:
+		err = commitCreditNoteNumber(ctx, counter)
+	default:
+		err = commitInvoiceNumber(ctx, counter)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = counters.UpdateOne(ctx, bson.M{"_id": counter.Id, "seq": counter.Seq}, bson.M{"$set": bson.M{"committed": true}})
+	return err
+}
+
+// commitInvoiceNumber numbers an invoice, along with the parties and product names as they
+// are when it is issued
+func commitInvoiceNumber(ctx context.Context, counter documentCounter) error {
+	users := db.GetDatabase().Collection("users")
+
+	userObjID, err := primitive.ObjectIDFromHex(counter.UserId)
+	if err != nil {
+		return fmt.Errorf("invalid user ID format")
+	}
+	user, err := getUserById(counter.UserId)
+	if err != nil {
+		return fmt.Errorf("no user found with id: %s", counter.UserId)
+	}
+
+	var invoice entities.InvoiceStruct
+	for _, i := range user.Invoices {
+		if i.Id == counter.DocumentId {
+			invoice = i
+		}
+	}
+	if invoice.Id == "" {
+		return fmt.Errorf("no invoice found with id: %s", counter.DocumentId)
+	}
+	if invoice.Number != "" {
+		return nil
+	}
+
+	products := invoice.Order.Products
+	for i := range products {
+		if products[i].Name == "" {
+			if product, err := GetProductById(products[i].ProductId); err == nil {
+				products[i].Name = product.Name
+			}
+		}
+	}
+
+	_, err = users.UpdateOne(ctx,
+		bson.M{"_id": userObjID, "invoices": bson.M{"$elemMatch": bson.M{"_id": counter.DocumentId, "number": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{
+			"invoices.$.number":         formatDocumentNumber(counter.Kind, counter.Year, counter.Seq),
+			"invoices.$.issuedAt":       counter.IssuedAt,
+			"invoices.$.seller":         invoiceSeller(),
+			"invoices.$.buyer":          invoiceBuyer(user),
+			"invoices.$.order.products": products,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to number invoice: %v", err)
+	}
+	return nil
+}
+
+// allocateDocumentNumber gives a document the next number of the year, unless numbered tells
+// it already has one
+func allocateDocumentNumber(ctx context.Context, kind string, userId string, documentId string, numbered func() (bool, error)) error {
+	counters := db.GetDatabase().Collection("counters")
+
+	now := time.Now().UTC()
+	counterId := fmt.Sprintf("%s-%d", kind, now.Year())
+	_, err := counters.UpdateOne(ctx,
+		bson.M{"_id": counterId},
+		bson.M{"$setOnInsert": bson.M{"kind": kind, "year": now.Year(), "seq": int64(0), "committed": true}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to create %s counter: %v", kind, err)
+	}
+
+	for attempt := 0; attempt < documentNumberAttempts; attempt++ {
+		var counter documentCounter
+		if err := counters.FindOne(ctx, bson.M{"_id": counterId}).Decode(&counter); err != nil {
+			return err
+		}
+
+		// Finish the allocation left by an interrupted or concurrent issue first
+		if !counter.Committed {
+			if err := commitDocumentNumber(ctx, counter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		done, err := numbered()
+		if err != nil || done {
+			return err
+		}
+
+		next := documentCounter{
+			Id:         counterId,
+			Kind:       kind,
+			Year:       now.Year(),
+			Seq:        counter.Seq + 1,
+			UserId:     userId,
+			DocumentId: documentId,
+			IssuedAt:   now.Format(time.RFC3339),
+		}
+		result, err := counters.UpdateOne(ctx,
+			bson.M{"_id": counterId, "seq": counter.Seq, "committed": true},
+			bson.M{"$set": bson.M{
+				"seq":        next.Seq,
+				"committed":  false,
+				"userId":     next.UserId,
+				"documentId": next.DocumentId,
+				"issuedAt":   next.IssuedAt,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			// Another document took this number
+			continue
+		}
+		if err := commitDocumentNumber(ctx, next); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("could not allocate a %s number, try again", kind)
+}
+
+// IssueInvoice gives a paid order the next invoice number of the year and renders its PDF.
+// Issuing an invoice again changes nothing.
+func IssueInvoice(userId string, invoiceId string) (entities.InvoiceStruct, error) {
+	ctx := context.TODO()
+
+	invoice, err := GetUserInvoice(userId, invoiceId)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+
+	if invoice.Number == "" {
+		if !isOrderPaid(invoice.Order.Status) {
+			return entities.InvoiceStruct{}, fmt.Errorf("only paid orders are invoiced, order is %s", invoice.Order.Status)
+		}
+
+		err := allocateDocumentNumber(ctx, invoiceDocument, userId, invoiceId, func() (bool, error) {
+			invoice, err := GetUserInvoice(userId, invoiceId)
+			return invoice.Number != "", err
+		})
+		if err != nil {
+			return entities.InvoiceStruct{}, err
+		}
+		if invoice, err = GetUserInvoice(userId, invoiceId); err != nil {
+			return entities.InvoiceStruct{}, err
+		}
+	}
+
+	return renderInvoicePDF(ctx, userId, invoice)
+}
+
+// renderInvoicePDF stores the PDF of an issued invoice, once: later calls return the stored one
+func renderInvoicePDF(ctx context.Context, userId string, invoice entities.InvoiceStruct) (entities.InvoiceStruct, error) {
+	if invoice.PdfKey != "" || blobStore == nil {
+		return invoice, nil
+	}
+
+	data, err := invoicing.RenderPDF(invoice)
+	if err != nil {
+		return invoice, err
+	}
+
+	key := fmt.Sprintf("invoices/%s.pdf", invoice.Number)
+	if err := blobStore.Put(ctx, key, data, "application/pdf"); err != nil {
+		return invoice, fmt.Errorf("failed to store invoice PDF: %v", err)
+	}
+
+	userObjID, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return invoice, fmt.Errorf("invalid user ID format")
+	}
+	_, err = db.GetDatabase().Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userObjID, "invoices": bson.M{"$elemMatch": bson.M{"_id": invoice.Id, "pdfKey": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"invoices.$.pdfKey": key}},
+	)
+	if err != nil {
+		return invoice, fmt.Errorf("failed to save invoice PDF: %v", err)
+	}
+	invoice.PdfKey = key
+	return invoice, nil
+}
+
+// GetInvoicePDF returns the PDF of an invoice of a user, issuing the invoice first if the
+// order was paid before invoices were numbered
+func GetInvoicePDF(userId string, invoiceId string) (entities.InvoiceStruct, storage.Blob, error) {
+	if blobStore == nil {
+		return entities.InvoiceStruct{}, storage.Blob{}, fmt.Errorf("no blob store configured")
+	}
+
+	invoice, err := GetUserInvoice(userId, invoiceId)
+	if err != nil {
+		return entities.InvoiceStruct{}, storage.Blob{}, err
+	}
+	if invoice.Number == "" || invoice.PdfKey == "" {
+		if invoice, err = IssueInvoice(userId, invoiceId); err != nil {
+			return entities.InvoiceStruct{}, storage.Blob{}, err
+		}
+	}
+
+	blob, err := blobStore.Get(context.TODO(), invoice.PdfKey)
+	if err != nil {
+		return entities.InvoiceStruct{}, storage.Blob{}, err
+	}
+	return invoice, blob, nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertExpiredOrder stores a user with 150 points and an order that spent 100 of them, and
+// that expired at expiresAt giving them back
+func insertExpiredOrder(t *testing.T, expiresAt time.Time) (string, entities.InvoiceStruct) {
+	t.Helper()
+
+	userId := primitive.NewObjectID()
+	invoice := entities.InvoiceStruct{
+		Id:                    primitive.NewObjectID().Hex(),
+		TotalPrice:            entities.NewMoney(900, entities.DefaultCurrency),
+		LoyaltyPointsRedeemed: 100,
+		Order:                 entities.OrderStruct{Date: expiresAt.Add(-time.Hour), Status: "expired"},
+		ExpiresAt:             expiresAt.UTC().Format(time.RFC3339),
+	}
+
+	conn := db.GetDatabase()
+	ctx := context.Background()
+	_, err := conn.Collection("users").InsertOne(ctx, bson.M{
+		"_id":           userId,
+		"loyaltyPoints": int64(150),
+		"invoices":      []entities.InvoiceStruct{invoice},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []entities.LoyaltyEntryStruct{
+		{UserId: userId.Hex(), Type: "redeem", Points: -100, InvoiceId: invoice.Id},
+		{UserId: userId.Hex(), Type: "release", Points: 100, InvoiceId: invoice.Id},
+	} {
+		if _, err := conn.Collection("loyalty_ledger").InsertOne(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return userId.Hex(), invoice
+}
+
+func TestReclaimExpiredInvoiceLetsItBePaid(t *testing.T) {
+	dbtest.Setup(t)
+	userId, invoice := insertExpiredOrder(t, time.Now().Add(-time.Minute))
+
+	if err := ReclaimExpiredInvoice(userId, invoice); err != nil {
+		t.Fatal(err)
+	}
+	user, err := getUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LoyaltyPoints != 50 {
+		t.Errorf("got %d points after reclaiming the order, want 50", user.LoyaltyPoints)
+	}
+
+	paid, err := MarkInvoicePaid(userId, invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid.Order.Status != "paid" {
+		t.Errorf("got status %s, want paid", paid.Order.Status)
+	}
+}
+
+func TestReclaimExpiredInvoiceRefusesOldOrders(t *testing.T) {
+	dbtest.Setup(t)
+	userId, invoice := insertExpiredOrder(t, time.Now().Add(-lateCaptureWindow-time.Minute))
+
+	if err := ReclaimExpiredInvoice(userId, invoice); err == nil {
+		t.Fatal("reclaimed an order expired too long ago")
+	}
+	user, err := getUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LoyaltyPoints != 150 {
+		t.Errorf("got %d points, want the 150 left untouched", user.LoyaltyPoints)
+	}
+}
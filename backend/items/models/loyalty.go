@@ -0,0 +1,430 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoyaltyPointValue is the discount one point gives at checkout, in the base currency, from
+// LOYALTY_POINT_VALUE (0.01 by default)
+func LoyaltyPointValue() entities.Money {
+	value, err := entities.ParseMoney(os.Getenv("LOYALTY_POINT_VALUE"), entities.DefaultCurrency)
+	if err != nil || value.Amount <= 0 {
+		return entities.NewMoney(1, entities.DefaultCurrency)
+	}
+	return value
+}
+
+func GetLoyaltyRules() ([]entities.LoyaltyRuleStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("loyalty_rules")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []entities.LoyaltyRuleStruct{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func checkLoyaltyRuleTarget(rule entities.LoyaltyRuleStruct) error {
+	if rule.ProductId != "" {
+		if _, err := GetProductById(rule.ProductId); err != nil {
+			return fmt.Errorf("no product found with id: %s", rule.ProductId)
+		}
+	}
+	if rule.CategoryId != "" {
+		if _, err := GetCategoryById(rule.CategoryId); err != nil {
+			return fmt.Errorf("no category found with id: %s", rule.CategoryId)
+		}
+	}
+	return nil
+}
+
+func CreateLoyaltyRule(rule entities.LoyaltyRuleStruct) (entities.LoyaltyRuleStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("loyalty_rules")
+
+	if err := checkLoyaltyRuleTarget(rule); err != nil {
+		return entities.LoyaltyRuleStruct{}, err
+	}
+
+	rule.Id = ""
+	inserted, err := collection.InsertOne(ctx, rule)
+	if err != nil {
+		return entities.LoyaltyRuleStruct{}, err
+	}
+
+	insertedID, ok := inserted.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return entities.LoyaltyRuleStruct{}, fmt.Errorf("failed to convert inserted ID to ObjectID")
+	}
+	rule.Id = insertedID.Hex()
+	return rule, nil
+}
+
+func UpdateLoyaltyRule(id string, rule entities.LoyaltyRuleStruct) (entities.LoyaltyRuleStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("loyalty_rules")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.LoyaltyRuleStruct{}, fmt.Errorf("invalid ID format")
+	}
+	if err := checkLoyaltyRuleTarget(rule); err != nil {
+		return entities.LoyaltyRuleStruct{}, err
+	}
+
+	rule.Id = ""
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": objID}, rule)
+	if err != nil {
+		return entities.LoyaltyRuleStruct{}, err
+	}
+	if result.MatchedCount == 0 {
+		return entities.LoyaltyRuleStruct{}, fmt.Errorf("no loyalty rule found with id: %s", id)
+	}
+
+	rule.Id = id
+	return rule, nil
+}
+
+func DeleteLoyaltyRule(id string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("loyalty_rules")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no loyalty rule found with id: %s", id)
+	}
+	return nil
+}
+
+// ComputeLoyaltyPoints counts the points a paid invoice earns, from what each line cost in
+// the base currency once discounted
+func ComputeLoyaltyPoints(invoice entities.InvoiceStruct) (int64, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("loyalty_rules")
+
+	cursor, err := collection.Find(ctx, bson.M{"active": true})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []entities.LoyaltyRuleStruct
+	if err := cursor.All(ctx, &rules); err != nil {
+		return 0, err
+	}
+
+	ruleCategories, err := getLoyaltyRuleCategories(ctx, rules)
+	if err != nil {
+		return 0, err
+	}
+
+	var points int64
+	for _, line := range invoice.Order.Products {
+		var categoryIds []string
+		if product, err := GetProductById(line.ProductId); err == nil {
+			categoryIds = product.CategoryIds
+		}
+
+		var pointsPerUnit int64
+		for _, rule := range rules {
+			if loyaltyRuleMatches(rule, line.ProductId, categoryIds, ruleCategories[rule.CategoryId]) {
+				pointsPerUnit += rule.PointsPerUnit
+			}
+		}
+
+		amount := line.BasePrice.Amount
+		if line.BasePrice.Currency == "" {
+			amount = line.Price.Amount
+		}
+		points += amount * pointsPerUnit / 100
+	}
+	return points, nil
+}
+
+// getLoyaltyRuleCategories maps the category of each rule to the IDs of that category and of
+// its sub-categories, a rule on a category also applies to the products of its sub-categories
+func getLoyaltyRuleCategories(ctx context.Context, rules []entities.LoyaltyRuleStruct) (map[string]map[string]bool, error) {
+	ruleCategories := map[string]map[string]bool{}
+	var categoryIds []string
+	for _, rule := range rules {
+		if rule.CategoryId != "" && ruleCategories[rule.CategoryId] == nil {
+			ruleCategories[rule.CategoryId] = map[string]bool{rule.CategoryId: true}
+			categoryIds = append(categoryIds, rule.CategoryId)
+		}
+	}
+	if len(categoryIds) == 0 {
+		return ruleCategories, nil
+	}
+
+	cursor, err := db.GetDatabase().Collection("categories").Find(ctx,
+		bson.M{"ancestors": bson.M{"$in": categoryIds}},
+		options.Find().SetProjection(bson.M{"_id": 1, "ancestors": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var subcategories []entities.CategoryStruct
+	if err := cursor.All(ctx, &subcategories); err != nil {
+		return nil, err
+	}
+
+	for _, category := range subcategories {
+		for _, ancestor := range category.Ancestors {
+			if ids := ruleCategories[ancestor]; ids != nil {
+				ids[category.Id] = true
+			}
+		}
+	}
+	return ruleCategories, nil
+}
+
+// loyaltyRuleMatches tells whether a rule applies to a product, ruleCategories holds the
+// category of the rule and its sub-categories
+func loyaltyRuleMatches(rule entities.LoyaltyRuleStruct, productId string, categoryIds []string, ruleCategories map[string]bool) bool {
+	if rule.ProductId != "" && rule.ProductId != productId {
+		return false
+	}
+	if rule.CategoryId != "" {
+		for _, id := range categoryIds {
+			if ruleCategories[id] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// addLoyaltyEntry writes a ledger entry and applies it to the user balance. An invoice has at
+// most one entry of each type, so recording it twice changes nothing. With requireBalance,
+// the points are only taken if the user has enough of them: the balance is then changed
+// first, and restored if the entry cannot be written.
+func addLoyaltyEntry(entry entities.LoyaltyEntryStruct, requireBalance bool) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	ledger := conn.Collection("loyalty_ledger")
+	users := conn.Collection("users")
+
+	userObjID, err := primitive.ObjectIDFromHex(entry.UserId)
+	if err != nil {
+		return fmt.Errorf("invalid user ID format")
+	}
+
+	entry.Id = ""
+	entry.CreatedAt = time.Now().Format(time.RFC3339)
+
+	if !requireBalance {
+		if _, err := ledger.InsertOne(ctx, entry); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil
+			}
+			return err
+		}
+		_, err := users.UpdateOne(ctx, bson.M{"_id": userObjID}, bson.M{"$inc": bson.M{"loyaltyPoints": entry.Points}})
+		return err
+	}
+
+	result, err := users.UpdateOne(ctx,
+		bson.M{"_id": userObjID, "loyaltyPoints": bson.M{"$gte": -entry.Points}},
+		bson.M{"$inc": bson.M{"loyaltyPoints": entry.Points}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("not enough loyalty points")
+	}
+
+	if _, err := ledger.InsertOne(ctx, entry); err != nil {
+		_, undoErr := users.UpdateOne(ctx, bson.M{"_id": userObjID}, bson.M{"$inc": bson.M{"loyaltyPoints": -entry.Points}})
+		if undoErr != nil {
+			return fmt.Errorf("failed to restore loyalty balance: %v", undoErr)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// RedeemLoyaltyPoints spends the points of an order when it is created, they are given back
+// if the order is cancelled before being paid
+func RedeemLoyaltyPoints(userId string, invoice entities.InvoiceStruct) error {
+	if invoice.LoyaltyPointsRedeemed == 0 {
+		return nil
+	}
+
+	// Already spent by a previous attempt, whose balance check must not be done twice
+	conn := db.GetDatabase()
+	redeemed, err := conn.Collection("loyalty_ledger").CountDocuments(context.TODO(), bson.M{"invoiceId": invoice.Id, "type": "redeem"})
+	if err != nil || redeemed > 0 {
+		return err
+	}
+	return addLoyaltyEntry(entities.LoyaltyEntryStruct{
+		UserId:      userId,
+		Type:        "redeem",
+		Points:      -invoice.LoyaltyPointsRedeemed,
+		InvoiceId:   invoice.Id,
+		Description: fmt.Sprintf("Discount of %s on order %s", invoice.LoyaltyDiscount.String(), invoice.Id),
+	}, true)
+}
+
+func releaseLoyaltyPoints(userId string, invoice entities.InvoiceStruct) error {
+	if invoice.LoyaltyPointsRedeemed == 0 {
+		return nil
+	}
+
+	// Nothing to give back when the order was cancelled because the points could not be spent
+	conn := db.GetDatabase()
+	redeemed, err := conn.Collection("loyalty_ledger").CountDocuments(context.TODO(), bson.M{"invoiceId": invoice.Id, "type": "redeem"})
+	if err != nil || redeemed == 0 {
+		return err
+	}
+	return addLoyaltyEntry(entities.LoyaltyEntryStruct{
+		UserId:      userId,
+		Type:        "release",
+		Points:      invoice.LoyaltyPointsRedeemed,
+		InvoiceId:   invoice.Id,
+		Description: fmt.Sprintf("Order %s cancelled", invoice.Id),
+	}, false)
+}
+
+// reclaimLoyaltyPoints spends again the points given back when an order expired
+func reclaimLoyaltyPoints(userId string, invoice entities.InvoiceStruct) error {
+	if invoice.LoyaltyPointsRedeemed == 0 {
+		return nil
+	}
+
+	// Nothing to take back if the points were never given back
+	conn := db.GetDatabase()
+	released, err := conn.Collection("loyalty_ledger").CountDocuments(context.TODO(), bson.M{"invoiceId": invoice.Id, "type": "release"})
+	if err != nil || released == 0 {
+		return err
+	}
+	return addLoyaltyEntry(entities.LoyaltyEntryStruct{
+		UserId:      userId,
+		Type:        "reclaim",
+		Points:      -invoice.LoyaltyPointsRedeemed,
+		InvoiceId:   invoice.Id,
+		Description: fmt.Sprintf("Order %s paid after it expired", invoice.Id),
+	}, true)
+}
+
+// undoReclaimLoyaltyPoints gives back the points of reclaimLoyaltyPoints when the order
+// cannot be paid after all
+func undoReclaimLoyaltyPoints(userId string, invoice entities.InvoiceStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+
+	userObjID, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return fmt.Errorf("invalid user ID format")
+	}
+
+	result, err := conn.Collection("loyalty_ledger").DeleteOne(ctx, bson.M{"invoiceId": invoice.Id, "type": "reclaim"})
+	if err != nil || result.DeletedCount == 0 {
+		return err
+	}
+	_, err = conn.Collection("users").UpdateOne(ctx, bson.M{"_id": userObjID}, bson.M{"$inc": bson.M{"loyaltyPoints": invoice.LoyaltyPointsRedeemed}})
+	return err
+}
+
+// AwardLoyaltyPoints credits the points earned by a paid invoice
+func AwardLoyaltyPoints(userId string, invoice entities.InvoiceStruct) error {
+	if invoice.LoyaltyPointsEarned == 0 {
+		return nil
+	}
+	return addLoyaltyEntry(entities.LoyaltyEntryStruct{
+		UserId:      userId,
+		Type:        "earn",
+		Points:      invoice.LoyaltyPointsEarned,
+		InvoiceId:   invoice.Id,
+		Description: fmt.Sprintf("Order %s", invoice.Id),
+	}, false)
+}
+
+// reverseLoyaltyPoints takes back the points a refunded order earned and gives back those
+// spent on it. The balance may go below zero when the points earned were already spent.
+func reverseLoyaltyPoints(userId string, invoice entities.InvoiceStruct) error {
+	if invoice.LoyaltyPointsEarned > 0 {
+		err := addLoyaltyEntry(entities.LoyaltyEntryStruct{
+			UserId:      userId,
+			Type:        "revoke",
+			Points:      -invoice.LoyaltyPointsEarned,
+			InvoiceId:   invoice.Id,
+			Description: fmt.Sprintf("Order %s refunded", invoice.Id),
+		}, false)
+		if err != nil {
+			return err
+		}
+	}
+	if invoice.LoyaltyPointsRedeemed > 0 {
+		return addLoyaltyEntry(entities.LoyaltyEntryStruct{
+			UserId:      userId,
+			Type:        "restore",
+			Points:      invoice.LoyaltyPointsRedeemed,
+			InvoiceId:   invoice.Id,
+			Description: fmt.Sprintf("Order %s refunded", invoice.Id),
+		}, false)
+	}
+	return nil
+}
+
+func GetLoyaltyAccount(userId string) (entities.LoyaltyAccountStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("loyalty_ledger")
+
+	user, err := getUserById(userId)
+	if err != nil {
+		return entities.LoyaltyAccountStruct{}, fmt.Errorf("no user found with id: %s", userId)
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"userId": userId}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return entities.LoyaltyAccountStruct{}, err
+	}
+	defer cursor.Close(ctx)
+
+	history := []entities.LoyaltyEntryStruct{}
+	if err := cursor.All(ctx, &history); err != nil {
+		return entities.LoyaltyAccountStruct{}, err
+	}
+
+	return entities.LoyaltyAccountStruct{
+		Balance:    user.LoyaltyPoints,
+		PointValue: LoyaltyPointValue(),
+		History:    history,
+	}, nil
+}
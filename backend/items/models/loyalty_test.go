@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"trinity/backend/items/entities"
+)
+
+func TestLoyaltyRuleMatchesSubcategories(t *testing.T) {
+	rule := entities.LoyaltyRuleStruct{CategoryId: "beverages", PointsPerUnit: 2}
+	ruleCategories := map[string]bool{"beverages": true, "sodas": true}
+
+	cases := []struct {
+		name        string
+		categoryIds []string
+		want        bool
+	}{
+		{"category of the rule", []string{"beverages"}, true},
+		{"sub-category only", []string{"sodas"}, true},
+		{"other category", []string{"snacks"}, false},
+		{"no category", nil, false},
+	}
+	for _, c := range cases {
+		if got := loyaltyRuleMatches(rule, "product", c.categoryIds, ruleCategories); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLoyaltyRuleMatchesProduct(t *testing.T) {
+	rule := entities.LoyaltyRuleStruct{ProductId: "product", PointsPerUnit: 1}
+
+	if !loyaltyRuleMatches(rule, "product", nil, nil) {
+		t.Error("rule should match its product")
+	}
+	if loyaltyRuleMatches(rule, "other", nil, nil) {
+		t.Error("rule should not match another product")
+	}
+}
@@ -0,0 +1,285 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"trinity/backend/mailer"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mailLock is how long an attempt may take before another one is made
+const mailLock = time.Minute
+
+var mailTransport mailer.Transport
+
+func SetMailTransport(t mailer.Transport) {
+	mailTransport = t
+}
+
+// MailMaxAttempts is how many times an email is tried before being marked failed, from
+// MAIL_MAX_ATTEMPTS (8 by default, about 2 hours of retries)
+func MailMaxAttempts() int {
+	attempts := 8
+	if value := os.Getenv("MAIL_MAX_ATTEMPTS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid MAIL_MAX_ATTEMPTS %q, using %d: %v", value, attempts, err)
+		} else {
+			attempts = parsed
+		}
+	}
+	return attempts
+}
+
+// mailRetryDelay doubles after every failed attempt, from one minute up to an hour
+func mailRetryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+// mailData is what the email templates are filled with
+type mailData struct {
+	FirstName  string
+	Invoice    entities.InvoiceStruct
+	CreditNote entities.CreditNoteStruct
+}
+
+// queueMail renders a template for a user and adds it to the outbox, then tries to send it
+// right away. An email already queued under id is not queued again.
+func queueMail(id string, userId string, template string, data mailData, attachments []entities.MailAttachmentStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("mail_outbox")
+
+	user, err := getUserById(userId)
+	if err != nil {
+		return fmt.Errorf("no user found with id: %s", userId)
+	}
+	data.FirstName = user.FirstName
+
+	subject, text, html, err := mailer.Render(template, data)
+	if err != nil {
+		return fmt.Errorf("failed to render email %s: %v", template, err)
+	}
+
+	now := time.Now().UTC()
+	to := mail.Address{Name: strings.TrimSpace(user.FirstName + " " + user.LastName), Address: user.Email}
+	_, err = collection.InsertOne(ctx, entities.MailStruct{
+		Id:            id,
+		UserId:        userId,
+		To:            to.String(),
+		Template:      template,
+		Subject:       subject,
+		Text:          text,
+		Html:          html,
+		Attachments:   attachments,
+		Status:        "pending",
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to queue email: %v", err)
+	}
+
+	go func() {
+		if err := deliverMail(context.Background(), bson.M{"_id": id}); err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("Failed to send email %s: %v", id, err)
+		}
+	}()
+	return nil
+}
+
+// QueueOrderMails confirms a paid order to its customer and sends the receipt, with the
+// invoice PDF once it is issued
+func QueueOrderMails(userId string, invoice entities.InvoiceStruct) error {
+	if err := queueMail(mailer.OrderConfirmation+":"+invoice.Id, userId, mailer.OrderConfirmation, mailData{Invoice: invoice}, nil); err != nil {
+		return err
+	}
+
+	var attachments []entities.MailAttachmentStruct
+	if invoice.PdfKey != "" {
+		attachments = append(attachments, entities.MailAttachmentStruct{
+			Filename:    fmt.Sprintf("invoice-%s.pdf", invoice.Number),
+			ContentType: "application/pdf",
+			BlobKey:     invoice.PdfKey,
+		})
+	}
+	return queueMail(mailer.PaymentReceipt+":"+invoice.Id, userId, mailer.PaymentReceipt, mailData{Invoice: invoice}, attachments)
+}
+
+// queueRefundNotice tells a customer that a paid order was refunded or cancelled, with the
+// credit note PDF
+func queueRefundNotice(userId string, invoice entities.InvoiceStruct, note entities.CreditNoteStruct) error {
+	var attachments []entities.MailAttachmentStruct
+	if note.PdfKey != "" {
+		attachments = append(attachments, entities.MailAttachmentStruct{
+			Filename:    fmt.Sprintf("credit-note-%s.pdf", note.Number),
+			ContentType: "application/pdf",
+			BlobKey:     note.PdfKey,
+		})
+	}
+	return queueMail(mailer.RefundNotice+":"+invoice.Id, userId, mailer.RefundNotice, mailData{Invoice: invoice, CreditNote: note}, attachments)
+}
+
+// mailAttemptError is a failed attempt to send an email, which was scheduled for a retry or
+// marked failed
+type mailAttemptError struct {
+	id      string
+	attempt int
+	err     error
+}
+
+func (e *mailAttemptError) Error() string {
+	return fmt.Sprintf("attempt %d of email %s failed: %v", e.attempt, e.id, e.err)
+}
+
+// SendPendingMails sends the emails of the outbox that are due, one at a time. An email that
+// cannot be sent is left for its next attempt, any other error stops until the next run.
+func SendPendingMails() error {
+	ctx := context.TODO()
+
+	for {
+		err := deliverMail(ctx, bson.M{"status": "pending", "nextAttemptAt": bson.M{"$lte": time.Now().UTC()}})
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		var attemptErr *mailAttemptError
+		if errors.As(err, &attemptErr) {
+			log.Printf("Failed to send email: %v", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// deliverMail locks a pending email matching filter and makes an attempt to send it. Only one
+// attempt runs at a time: the lock is taken by the first, and left to expire if it crashes.
+func deliverMail(ctx context.Context, filter bson.M) error {
+	collection := db.GetDatabase().Collection("mail_outbox")
+
+	now := time.Now().UTC()
+	filter["status"] = "pending"
+	filter["lockedUntil"] = bson.M{"$not": bson.M{"$gt": now}}
+
+	var email entities.MailStruct
+	err := collection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"lockedUntil": now.Add(mailLock)}, "$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetSort(bson.M{"nextAttemptAt": 1}),
+	).Decode(&email)
+	if err != nil {
+		return err
+	}
+
+	sendErr := sendMail(ctx, email)
+
+	update := bson.M{"$unset": bson.M{"lockedUntil": ""}}
+	switch {
+	case sendErr == nil:
+		update["$set"] = bson.M{"status": "sent", "sentAt": time.Now().UTC()}
+	case email.Attempts >= MailMaxAttempts():
+		update["$set"] = bson.M{"status": "failed", "lastError": sendErr.Error()}
+	default:
+		update["$set"] = bson.M{"lastError": sendErr.Error(), "nextAttemptAt": time.Now().UTC().Add(mailRetryDelay(email.Attempts))}
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": email.Id}, update); err != nil {
+		return fmt.Errorf("failed to update email %s: %v", email.Id, err)
+	}
+	if sendErr != nil {
+		return &mailAttemptError{id: email.Id, attempt: email.Attempts, err: sendErr}
+	}
+	return nil
+}
+
+func sendMail(ctx context.Context, email entities.MailStruct) error {
+	if mailTransport == nil {
+		return fmt.Errorf("no mail transport configured")
+	}
+
+	msg := mailer.Message{
+		From:    mailer.Sender(),
+		To:      email.To,
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.Html,
+	}
+	for _, attachment := range email.Attachments {
+		if blobStore == nil {
+			return fmt.Errorf("no blob store configured")
+		}
+		blob, err := blobStore.Get(ctx, attachment.BlobKey)
+		if err != nil {
+			return fmt.Errorf("failed to read attachment %s: %v", attachment.BlobKey, err)
+		}
+		msg.Attachments = append(msg.Attachments, mailer.Attachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Data:        blob.Data,
+		})
+	}
+
+	return mailTransport.Send(ctx, msg)
+}
+
+// GetMails lists the last emails of the outbox, optionally with a given status
+func GetMails(status string, limit int) ([]entities.MailStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("mail_outbox")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	mails := []entities.MailStruct{}
+	if err := cursor.All(ctx, &mails); err != nil {
+		return nil, err
+	}
+	return mails, nil
+}
+
+// RetryMail queues a failed email again, with all its attempts
+func RetryMail(id string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("mail_outbox")
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": "failed"},
+		bson.M{"$set": bson.M{"status": "pending", "attempts": 0, "nextAttemptAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no failed email found with id: %s", id)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+	"trinity/backend/items/entities"
+	"trinity/backend/mailer"
+	"trinity/backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func queueTestMail(t *testing.T, email entities.MailStruct) {
+	t.Helper()
+
+	now := time.Now().UTC()
+	email.UserId = "user"
+	email.To = "Ada Lovelace <ada@example.com>"
+	email.Subject = "Your order"
+	email.Text = "Thank you for your order"
+	email.Status = "pending"
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+	if _, err := db.GetDatabase().Collection("mail_outbox").InsertOne(context.Background(), email); err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+}
+
+func getTestMail(t *testing.T, id string) entities.MailStruct {
+	t.Helper()
+
+	var email entities.MailStruct
+	if err := db.GetDatabase().Collection("mail_outbox").FindOne(context.Background(), bson.M{"_id": id}).Decode(&email); err != nil {
+		t.Fatalf("failed to read email %s: %v", id, err)
+	}
+	return email
+}
+
+func sentMails(t *testing.T, dir string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mails []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mails = append(mails, string(data))
+	}
+	return mails
+}
+
+func TestSendPendingMailsRetriesUntilSent(t *testing.T) {
+	dbtest.Setup(t)
+	queueTestMail(t, entities.MailStruct{Id: "retry"})
+
+	// Without a transport the attempt fails and is put off, the next run has nothing due
+	SetMailTransport(nil)
+	for run := 0; run < 2; run++ {
+		if err := SendPendingMails(); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+	email := getTestMail(t, "retry")
+	if email.Status != "pending" || email.Attempts != 1 || email.LastError == "" {
+		t.Fatalf("got status %s after %d attempts (%q), want pending after 1 failed attempt", email.Status, email.Attempts, email.LastError)
+	}
+	if delay := time.Until(email.NextAttemptAt); delay < 30*time.Second || delay > mailRetryDelay(1) {
+		t.Errorf("next attempt in %v, want about %v", delay, mailRetryDelay(1))
+	}
+
+	dir := t.TempDir()
+	transport, err := mailer.NewFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetMailTransport(transport)
+	t.Cleanup(func() { SetMailTransport(nil) })
+
+	_, err = db.GetDatabase().Collection("mail_outbox").UpdateOne(context.Background(), bson.M{"_id": "retry"}, bson.M{"$set": bson.M{"nextAttemptAt": time.Now().UTC()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SendPendingMails(); err != nil {
+		t.Fatal(err)
+	}
+
+	email = getTestMail(t, "retry")
+	if email.Status != "sent" || email.Attempts != 2 || email.SentAt.IsZero() {
+		t.Errorf("got status %s after %d attempts, want sent after 2", email.Status, email.Attempts)
+	}
+	if mails := sentMails(t, dir); len(mails) != 1 || !strings.Contains(mails[0], "ada@example.com") {
+		t.Errorf("got %d emails written, want the one to ada@example.com", len(mails))
+	}
+}
+
+func TestSendPendingMailsGivesUp(t *testing.T) {
+	dbtest.Setup(t)
+	t.Setenv("MAIL_MAX_ATTEMPTS", "1")
+	SetMailTransport(nil)
+	queueTestMail(t, entities.MailStruct{Id: "give-up"})
+
+	if err := SendPendingMails(); err != nil {
+		t.Fatal(err)
+	}
+	if email := getTestMail(t, "give-up"); email.Status != "failed" {
+		t.Errorf("got status %s, want failed", email.Status)
+	}
+}
+
+func TestSendPendingMailsAttachments(t *testing.T) {
+	dbtest.Setup(t)
+
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf := []byte("%PDF-1.4 invoice")
+	if err := store.Put(context.Background(), "invoices/2026-000042.pdf", pdf, "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	SetBlobStore(store)
+	t.Cleanup(func() { SetBlobStore(nil) })
+
+	dir := t.TempDir()
+	transport, err := mailer.NewFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetMailTransport(transport)
+	t.Cleanup(func() { SetMailTransport(nil) })
+
+	queueTestMail(t, entities.MailStruct{Id: "receipt", Attachments: []entities.MailAttachmentStruct{{
+		Filename:    "invoice-2026-000042.pdf",
+		ContentType: "application/pdf",
+		BlobKey:     "invoices/2026-000042.pdf",
+	}}})
+	if err := SendPendingMails(); err != nil {
+		t.Fatal(err)
+	}
+
+	mails := sentMails(t, dir)
+	if len(mails) != 1 {
+		t.Fatalf("got %d emails written, want 1", len(mails))
+	}
+	if !strings.Contains(mails[0], "invoice-2026-000042.pdf") {
+		t.Error("attachment filename missing from the email")
+	}
+	if !strings.Contains(mails[0], base64.StdEncoding.EncodeToString(pdf)) {
+		t.Error("attachment content missing from the email")
+	}
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// toInt64 reads an aggregation result that MongoDB may return as int32, int64 or double
+func toInt64(value any) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+func toFloat64(value any) float64 {
+	switch v := value.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func numericFilter(paths []string) bson.M {
+	or := make([]bson.M, 0, len(paths))
+	for _, path := range paths {
+		or = append(or, bson.M{path: bson.M{"$type": "number"}})
+	}
+	return bson.M{"$or": or}
+}
+
+// migrateMoneyFields converts top level amounts stored as decimal numbers
+func migrateMoneyFields(ctx context.Context, collection *mongo.Collection, fields ...string) (int, error) {
+	cursor, err := collection.Find(ctx, numericFilter(fields))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		set := bson.M{}
+		for _, field := range fields {
+			value, err := cursor.Current.LookupErr(field)
+			if err != nil || value.Type == bson.TypeEmbeddedDocument {
+				continue
+			}
+			var amount entities.Money
+			if err := amount.UnmarshalBSONValue(value.Type, value.Value); err != nil {
+				return migrated, fmt.Errorf("failed to convert %s.%s: %v", collection.Name(), field, err)
+			}
+			set[field] = amount
+		}
+
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": cursor.Current.Lookup("_id")}, bson.M{"$set": bson.M(set)})
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// migrateMoneyArray converts the amounts of an embedded array by decoding it with its
+// entity, whose Money fields read the legacy numbers, and writing it back
+func migrateMoneyArray[T any](ctx context.Context, collection *mongo.Collection, field string, paths ...string) (int, error) {
+	cursor, err := collection.Find(ctx, numericFilter(paths))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var items []T
+		if err := cursor.Current.Lookup(field).Unmarshal(&items); err != nil {
+			return migrated, fmt.Errorf("failed to convert %s.%s: %v", collection.Name(), field, err)
+		}
+
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": cursor.Current.Lookup("_id")}, bson.M{"$set": bson.M{field: items}})
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// migrateDiscountValues turns the discount values stored as decimals (20.00 for 20%, 5.00
+// for 5 EUR off) or as Money into the plain integers promotions hold now, the two meaning
+// basis points or cents alike. Integers are already converted.
+func migrateDiscountValues(ctx context.Context, collection *mongo.Collection) (int, error) {
+	result, err := collection.UpdateMany(ctx,
+		bson.M{"discount_value": bson.M{"$type": []string{"object", "double", "decimal"}}},
+		bson.A{
+			bson.M{"$set": bson.M{"discount_value": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$discount_value"}, "object"}},
+				bson.M{"$toLong": "$discount_value.amount"},
+				bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{"$discount_value", 100}}, 0}}},
+			}}}},
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert promotions.discount_value: %v", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// MigrateMoneyFields rewrites the amounts stored as decimal numbers before the Money type
+// existed as {amount, currency} documents, so aggregations can sum exact cents.
+// Documents already migrated are not matched, it is safe to run on every start.
+func MigrateMoneyFields() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+
+	steps := []func() (int, error){
+		func() (int, error) {
+			return migrateMoneyFields(ctx, conn.Collection("products"), "priceVat", "priceNot")
+		},
+		func() (int, error) {
+			return migrateMoneyFields(ctx, conn.Collection("price_history"), "priceVat", "priceNot")
+		},
+		func() (int, error) {
+			return migrateMoneyFields(ctx, conn.Collection("promotions"), "min_purchase")
+		},
+		func() (int, error) {
+			return migrateDiscountValues(ctx, conn.Collection("promotions"))
+		},
+		func() (int, error) {
+			return migrateMoneyArray[entities.ProductOrder](ctx, conn.Collection("promotions"), "products",
+				"products.priceVat", "products.priceNot")
+		},
+		func() (int, error) {
+			return migrateMoneyArray[entities.InvoiceStruct](ctx, conn.Collection("users"), "invoices",
+				"invoices.totalPrice", "invoices.order.products.price")
+		},
+		func() (int, error) {
+			return migrateMoneyArray[entities.InvoiceStruct](ctx, conn.Collection("suppliers"), "invoices",
+				"invoices.totalPrice", "invoices.order.products.price")
+		},
+	}
+
+	total := 0
+	for _, step := range steps {
+		migrated, err := step()
+		total += migrated
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -2,57 +2,382 @@ package models
 
 import (
 	"context"
-	fcm "google.golang.org/api/fcm/v1"
-	"google.golang.org/api/option"
+	"fmt"
 	"log"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var fcmService *fcm.Service
+// notificationRecipient is what sending a notification needs to know of a user
+type notificationRecipient struct {
+	Id                      primitive.ObjectID                      `bson:"_id"`
+	FirstName               string                                  `bson:"firstName"`
+	LastName                string                                  `bson:"lastName"`
+	NotificationPreferences *entities.NotificationPreferencesStruct `bson:"notificationPreferences"`
+}
+
+func (r notificationRecipient) allows(notificationType string) bool {
+	preferences := entities.DefaultNotificationPreferences
+	if r.NotificationPreferences != nil {
+		preferences = *r.NotificationPreferences
+	}
+	if notificationType == entities.NotificationTransactional {
+		return preferences.Transactional
+	}
+	return preferences.Marketing
+}
+
+// notificationTargetFilter selects the users of a target
+func notificationTargetFilter(ctx context.Context, target entities.NotificationTargetStruct) (bson.M, error) {
+	filter := bson.M{"archived": bson.M{"$ne": true}}
+
+	if len(target.UserIds) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(target.UserIds))
+		for _, id := range target.UserIds {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user ID format: %s", id)
+			}
+			ids = append(ids, objID)
+		}
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	if len(target.Roles) > 0 {
+		filter["roles.name"] = bson.M{"$in": target.Roles}
+	}
+	if len(target.Cities) > 0 {
+		filter["city.name"] = bson.M{"$in": target.Cities}
+	}
+	if len(target.Segments) > 0 {
+		filter["segments"] = bson.M{"$in": normalizeSegments(target.Segments)}
+	}
+	if len(target.CategoryIds) > 0 {
+		productIds, err := getCategoriesProductIds(ctx, target.CategoryIds)
+		if err != nil {
+			return nil, err
+		}
+		filter["invoices"] = bson.M{"$elemMatch": bson.M{
+			"order.status":             bson.M{"$exists": true, "$nin": unpaidOrderStatuses},
+			"order.products.productId": bson.M{"$in": productIds},
+		}}
+	}
+	return filter, nil
+}
+
+// getCategoriesProductIds lists the products of categories and of their subcategories,
+// archived ones included since they may have been bought before
+func getCategoriesProductIds(ctx context.Context, categoryIds []string) ([]string, error) {
+	conn := db.GetDatabase()
+
+	cursor, err := conn.Collection("categories").Find(ctx,
+		bson.M{"ancestors": bson.M{"$in": categoryIds}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var subcategories []entities.CategoryStruct
+	if err := cursor.All(ctx, &subcategories); err != nil {
+		return nil, err
+	}
+	ids := append([]string{}, categoryIds...)
+	for _, category := range subcategories {
+		ids = append(ids, category.Id)
+	}
+
+	cursor, err = conn.Collection("products").Find(ctx,
+		bson.M{"categoryIds": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var products []entities.ProductStruct
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+
+	productIds := make([]string, 0, len(products))
+	for _, product := range products {
+		productIds = append(productIds, product.Id)
+	}
+	return productIds, nil
+}
+
+func (r notificationRecipient) locale() string {
+	if r.NotificationPreferences == nil {
+		return ""
+	}
+	return r.NotificationPreferences.Locale
+}
 
-func SendNotification(title, body string) error {
+// notificationContent writes the title and the body of the notification a user gets
+type notificationContent func(recipient notificationRecipient) (string, string)
 
-	// Fetch all device tokens from MongoDB
-	tokens, err := GetDeviceTokens()
+// EnqueueNotification queues a notification for the devices of the users of its target who
+// agreed to receive its type, and adds it to their history. The workers send it afterwards,
+// the dispatch returned follows the delivery.
+func EnqueueNotification(req entities.NotificationRequestStruct) (entities.NotificationDispatchStruct, error) {
+	return enqueueNotification(req, "", nil)
+}
+
+// enqueueNotification queues a notification, written for each user by content when given.
+// The app is sent the ID of the notification in the history to report when it is opened.
+func enqueueNotification(req entities.NotificationRequestStruct, campaignId string, content notificationContent) (entities.NotificationDispatchStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	if req.Type == "" {
+		req.Type = entities.NotificationMarketing
+	}
+
+	filter, err := notificationTargetFilter(ctx, req.Target)
 	if err != nil {
-		return err
+		return entities.NotificationDispatchStruct{}, err
 	}
 
-	// Create the FCM message
-	msg := &fcm.Message{
-		Notification: &fcm.Notification{
-			Title: title,
-			Body:  body,
-		},
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{
+		"firstName":               1,
+		"lastName":                1,
+		"notificationPreferences": 1,
+	}))
+	if err != nil {
+		return entities.NotificationDispatchStruct{}, err
+	}
+	var recipients []notificationRecipient
+	if err := cursor.All(ctx, &recipients); err != nil {
+		return entities.NotificationDispatchStruct{}, err
+	}
+
+	userIds := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		userIds = append(userIds, recipient.Id.Hex())
+	}
+	devices, err := getUsersDevices(ctx, userIds)
+	if err != nil {
+		return entities.NotificationDispatchStruct{}, err
 	}
 
-	// Send the message to each token individually (FCM v1 supports multicast but requires a different approach)
-	ctx := context.Background()
-	for _, token := range tokens {
-		msg.Token = token
-		sendReq := &fcm.SendMessageRequest{
-			Message: msg,
+	now := time.Now().UTC()
+	dispatch := entities.NotificationDispatchStruct{
+		CampaignId: campaignId,
+		Request:    req,
+		Status:     "sending",
+		Targeted:   len(recipients),
+		CreatedAt:  now,
+	}
+	var notified []notificationRecipient
+	for _, recipient := range recipients {
+		switch {
+		case !recipient.allows(req.Type):
+			dispatch.OptedOut++
+		case len(devices[recipient.Id.Hex()]) == 0:
+			dispatch.NoDevice++
+		default:
+			notified = append(notified, recipient)
+			dispatch.Devices += len(devices[recipient.Id.Hex()])
 		}
+	}
+	if dispatch.Devices == 0 {
+		dispatch.Status = "completed"
+		dispatch.CompletedAt = now
+	}
 
-		// Send the message using the FCM service
-		_, err := fcmService.Projects.Messages.Send("projects/com-baptistegrimaldi-trinity", sendReq).Context(ctx).Do()
-		if err != nil {
-			log.Printf("Error sending notification to token %s: %v", token, err)
+	inserted, err := conn.Collection("notification_dispatches").InsertOne(ctx, dispatch)
+	if err != nil {
+		return entities.NotificationDispatchStruct{}, fmt.Errorf("failed to save notification dispatch: %v", err)
+	}
+	dispatch.Id = inserted.InsertedID.(primitive.ObjectID).Hex()
+	if len(notified) == 0 {
+		return dispatch, nil
+	}
+
+	history := make([]entities.NotificationStruct, 0, len(notified))
+	documents := make([]any, 0, len(notified))
+	for _, recipient := range notified {
+		notification := entities.NotificationStruct{
+			DispatchId: dispatch.Id,
+			CampaignId: campaignId,
+			UserId:     recipient.Id.Hex(),
+			Title:      req.Title,
+			Body:       req.Body,
+			Type:       req.Type,
+			Data:       req.Data,
+			Status:     "pending",
+			SentAt:     now,
+		}
+		if content != nil {
+			notification.Title, notification.Body = content(recipient)
+		}
+		history = append(history, notification)
+		documents = append(documents, notification)
+	}
+	insertedHistory, err := conn.Collection("notifications").InsertMany(ctx, documents)
+	if err != nil {
+		return entities.NotificationDispatchStruct{}, failNotificationDispatch(ctx, dispatch.Id, fmt.Errorf("failed to save notification history: %v", err))
+	}
+
+	var jobs []any
+	for i, recipient := range notified {
+		notificationId := insertedHistory.InsertedIDs[i].(primitive.ObjectID).Hex()
+		data := map[string]string{"notificationId": notificationId}
+		for key, value := range req.Data {
+			data[key] = value
+		}
+
+		for _, device := range devices[recipient.Id.Hex()] {
+			jobs = append(jobs, entities.NotificationJobStruct{
+				DispatchId:     dispatch.Id,
+				NotificationId: notificationId,
+				UserId:         recipient.Id.Hex(),
+				Token:          device.Token,
+				Title:          history[i].Title,
+				Body:           history[i].Body,
+				Data:           data,
+				Status:         "pending",
+				NextAttemptAt:  now,
+				CreatedAt:      now,
+			})
 		}
-		log.Printf("Notification sent to token %s", token)
+	}
+	if _, err := conn.Collection("notification_jobs").InsertMany(ctx, jobs); err != nil {
+		return entities.NotificationDispatchStruct{}, failNotificationDispatch(ctx, dispatch.Id, fmt.Errorf("failed to queue notification: %v", err))
 	}
 
-	return nil
+	wakeNotificationWorkers()
+	return dispatch, nil
 }
 
-func InitFCMService(configPath string) (*fcm.Service, error) {
-	ctx := context.Background()
-	service, err := fcm.NewService(ctx, option.WithCredentialsFile(configPath), option.WithScopes(fcm.FirebaseMessagingScope))
+// failNotificationDispatch stops a dispatch whose notifications could not all be queued, so
+// that it is not left sending. The jobs queued but not sent yet are dropped, the dispatch can
+// then be sent again without notifying most of its users twice. It returns cause.
+func failNotificationDispatch(ctx context.Context, dispatchId string, cause error) error {
+	conn := db.GetDatabase()
+
+	objID, err := primitive.ObjectIDFromHex(dispatchId)
 	if err != nil {
+		return fmt.Errorf("%v, and invalid dispatch id %s", cause, dispatchId)
+	}
+
+	if _, err := conn.Collection("notification_jobs").DeleteMany(ctx, bson.M{"dispatchId": dispatchId, "status": "pending"}); err != nil {
+		log.Printf("Failed to drop the jobs of notification dispatch %s: %v", dispatchId, err)
+	}
+	_, err = conn.Collection("notifications").UpdateMany(ctx,
+		bson.M{"dispatchId": dispatchId, "status": "pending"},
+		bson.M{"$set": bson.M{"status": "failed", "error": cause.Error()}},
+	)
+	if err != nil {
+		log.Printf("Failed to mark the notifications of dispatch %s failed: %v", dispatchId, err)
+	}
+	_, err = conn.Collection("notification_dispatches").UpdateOne(ctx,
+		bson.M{"_id": objID},
+		bson.M{"$set": bson.M{"status": "failed", "error": cause.Error(), "completedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		log.Printf("Failed to mark notification dispatch %s failed: %v", dispatchId, err)
+	}
+	return cause
+}
+
+func GetNotificationPreferences(userId string) (entities.NotificationPreferencesStruct, error) {
+	user, err := getUserById(userId)
+	if err != nil {
+		return entities.NotificationPreferencesStruct{}, fmt.Errorf("no user found with id: %s", userId)
+	}
+	if user.NotificationPreferences == nil {
+		return entities.DefaultNotificationPreferences, nil
+	}
+	return *user.NotificationPreferences, nil
+}
+
+func SetNotificationPreferences(userId string, preferences entities.NotificationPreferencesStruct) (entities.NotificationPreferencesStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	objID, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return entities.NotificationPreferencesStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"notificationPreferences": preferences}})
+	if err != nil {
+		return entities.NotificationPreferencesStruct{}, err
+	}
+	if result.MatchedCount == 0 {
+		return entities.NotificationPreferencesStruct{}, fmt.Errorf("no user found with id: %s", userId)
+	}
+	return preferences, nil
+}
+
+// GetNotificationHistory lists the last notifications sent to a user, optionally of one type
+func GetNotificationHistory(userId string, notificationType string, limit int) ([]entities.NotificationStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("notifications")
+
+	filter := bson.M{"userId": userId}
+	if notificationType = strings.ToLower(notificationType); notificationType != "" {
+		filter["type"] = notificationType
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"sentAt": -1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []entities.NotificationStruct{}
+	if err := cursor.All(ctx, &notifications); err != nil {
 		return nil, err
 	}
-	return service, nil
+	return notifications, nil
 }
 
-func SetFCMService(s *fcm.Service) {
-	fcmService = s
+// MarkNotificationOpened records that a user opened a notification, counted once in the stats
+// of its campaign
+func MarkNotificationOpened(userId string, notificationId string) (entities.NotificationStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("notifications")
+
+	objID, err := primitive.ObjectIDFromHex(notificationId)
+	if err != nil {
+		return entities.NotificationStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	var notification entities.NotificationStruct
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": objID, "userId": userId, "openedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"openedAt": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&notification)
+	if err == mongo.ErrNoDocuments {
+		// Already opened, or not a notification of this user
+		if err := collection.FindOne(ctx, bson.M{"_id": objID, "userId": userId}).Decode(&notification); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return entities.NotificationStruct{}, fmt.Errorf("no notification found with id: %s", notificationId)
+			}
+			return entities.NotificationStruct{}, err
+		}
+		return notification, nil
+	}
+	if err != nil {
+		return entities.NotificationStruct{}, err
+	}
+
+	if notification.CampaignId != "" {
+		if err := recordCampaignOpen(ctx, notification.CampaignId); err != nil {
+			return notification, err
+		}
+	}
+	return notification, nil
 }
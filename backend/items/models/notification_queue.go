@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"trinity/backend/push"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/time/rate"
+	fcm "google.golang.org/api/fcm/v1"
+)
+
+const (
+	// notificationLock is how long a worker may take to send a message before another one does
+	notificationLock = time.Minute
+	// notificationPollInterval is how often idle workers look for messages queued elsewhere
+	notificationPollInterval = 5 * time.Second
+)
+
+var pushSender push.Sender
+
+func SetPushSender(s push.Sender) {
+	pushSender = s
+}
+
+// InitFCMService connects to FCM with the service account in configPath, see SetFCMService
+func InitFCMService(configPath string) (*fcm.Service, error) {
+	return push.NewFCMService(context.Background(), configPath)
+}
+
+// SetFCMService sends the notifications through an FCM service, of the FCM_PROJECT_ID
+// project. It is the same as SetPushSender with a push.FCMSender.
+func SetFCMService(s *fcm.Service) {
+	SetPushSender(push.NewFCMSenderFromService(s, push.FCMProjectId()))
+}
+
+// notificationWake lets an idle worker start right away when messages are queued
+var notificationWake = make(chan struct{}, 1)
+
+func wakeNotificationWorkers() {
+	select {
+	case notificationWake <- struct{}{}:
+	default:
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid %s %q, using %d: %v", name, value, fallback, err)
+		return fallback
+	}
+	return parsed
+}
+
+// NotificationWorkers is how many messages are sent at the same time, from NOTIFICATION_WORKERS
+// (4 by default)
+func NotificationWorkers() int {
+	return envInt("NOTIFICATION_WORKERS", 4)
+}
+
+// NotificationRate is how many messages are sent per second at most, by all the workers,
+// from NOTIFICATION_RATE (20 by default)
+func NotificationRate() int {
+	return envInt("NOTIFICATION_RATE", 20)
+}
+
+// NotificationMaxAttempts is how many times a message is tried before being marked failed,
+// from NOTIFICATION_MAX_ATTEMPTS (5 by default)
+func NotificationMaxAttempts() int {
+	return envInt("NOTIFICATION_MAX_ATTEMPTS", 5)
+}
+
+// notificationRetryDelay doubles after every failed attempt, from 30 seconds up to 30 minutes
+func notificationRetryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < 30*time.Minute; i++ {
+		delay *= 2
+	}
+	return min(delay, 30*time.Minute)
+}
+
+// StartNotificationWorkers starts the workers sending the queued notifications, until ctx is done
+func StartNotificationWorkers(ctx context.Context) {
+	workers := NotificationWorkers()
+	limiter := rate.NewLimiter(rate.Limit(NotificationRate()), 1)
+	for i := 0; i < workers; i++ {
+		go notificationWorker(ctx, limiter)
+	}
+	log.Printf("%d notification workers started, sending up to %d messages per second", workers, NotificationRate())
+}
+
+func notificationWorker(ctx context.Context, limiter *rate.Limiter) {
+	for {
+		if err := limiter.Wait(ctx); err != nil {
+			return
+		}
+
+		job, err := claimNotificationJob(ctx)
+		if err == nil {
+			// More may be waiting, let another idle worker look
+			wakeNotificationWorkers()
+			runNotificationJob(ctx, job)
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to fetch queued notifications: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-notificationWake:
+		case <-time.After(notificationPollInterval):
+		}
+	}
+}
+
+// claimNotificationJob locks the next message due, so that no other worker sends it
+func claimNotificationJob(ctx context.Context) (entities.NotificationJobStruct, error) {
+	collection := db.GetDatabase().Collection("notification_jobs")
+
+	now := time.Now().UTC()
+	var job entities.NotificationJobStruct
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":        "pending",
+			"nextAttemptAt": bson.M{"$lte": now},
+			"lockedUntil":   bson.M{"$not": bson.M{"$gt": now}},
+		},
+		bson.M{"$set": bson.M{"lockedUntil": now.Add(notificationLock)}, "$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetSort(bson.M{"nextAttemptAt": 1}),
+	).Decode(&job)
+	return job, err
+}
+
+func runNotificationJob(ctx context.Context, job entities.NotificationJobStruct) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Sending notification %s panicked: %v", job.Id, r)
+		}
+	}()
+
+	if err := processNotificationJob(ctx, job); err != nil {
+		log.Printf("Failed to update notification %s: %v", job.Id, err)
+	}
+}
+
+// processNotificationJob sends a message and records the outcome. A token FCM no longer
+// accepts fails at once and its device is removed, other failures are retried later.
+func processNotificationJob(ctx context.Context, job entities.NotificationJobStruct) error {
+	var sendErr error
+	if pushSender == nil {
+		sendErr = fmt.Errorf("push notifications are not configured")
+	} else {
+		sendErr = pushSender.Send(ctx, push.Message{Token: job.Token, Title: job.Title, Body: job.Body, Data: job.Data})
+	}
+
+	switch {
+	case sendErr == nil:
+		if err := finishNotificationJob(ctx, job, "sent", ""); err != nil {
+			return err
+		}
+		return recordNotificationDelivery(ctx, job, bson.M{"sent": 1}, nil)
+	case errors.Is(sendErr, push.ErrUnregistered):
+		if err := removeDevice(ctx, job.Token, job.CreatedAt); err != nil {
+			log.Printf("Failed to remove stale device of user %s: %v", job.UserId, err)
+		}
+		if err := finishNotificationJob(ctx, job, "failed", sendErr.Error()); err != nil {
+			return err
+		}
+		return recordNotificationDelivery(ctx, job, bson.M{"failed": 1, "pruned": 1}, sendErr)
+	case job.Attempts >= NotificationMaxAttempts():
+		log.Printf("Giving up on notification %s after %d attempts: %v", job.Id, job.Attempts, sendErr)
+		if err := finishNotificationJob(ctx, job, "failed", sendErr.Error()); err != nil {
+			return err
+		}
+		return recordNotificationDelivery(ctx, job, bson.M{"failed": 1}, sendErr)
+	default:
+		_, err := db.GetDatabase().Collection("notification_jobs").UpdateOne(ctx,
+			bson.M{"_id": mustObjectID(job.Id)},
+			bson.M{
+				"$set":   bson.M{"lastError": sendErr.Error(), "nextAttemptAt": time.Now().UTC().Add(notificationRetryDelay(job.Attempts))},
+				"$unset": bson.M{"lockedUntil": ""},
+			},
+		)
+		return err
+	}
+}
+
+func finishNotificationJob(ctx context.Context, job entities.NotificationJobStruct, status string, lastError string) error {
+	set := bson.M{"status": status}
+	if lastError != "" {
+		set["lastError"] = lastError
+	}
+	_, err := db.GetDatabase().Collection("notification_jobs").UpdateOne(ctx,
+		bson.M{"_id": mustObjectID(job.Id)},
+		bson.M{"$set": set, "$unset": bson.M{"lockedUntil": ""}},
+	)
+	return err
+}
+
+// recordNotificationDelivery counts a finished message in its dispatch, completing it with the
+// last one, and updates the history of the user: a notification is sent as soon as it reached
+// one of their devices.
+func recordNotificationDelivery(ctx context.Context, job entities.NotificationJobStruct, counts bson.M, sendErr error) error {
+	conn := db.GetDatabase()
+
+	notificationFilter := bson.M{"_id": mustObjectID(job.NotificationId)}
+	update := bson.M{"$set": bson.M{"status": "sent"}, "$unset": bson.M{"error": ""}}
+	if sendErr != nil {
+		notificationFilter["status"] = bson.M{"$ne": "sent"}
+		update = bson.M{"$set": bson.M{"status": "failed", "error": sendErr.Error()}}
+	}
+	if _, err := conn.Collection("notifications").UpdateOne(ctx, notificationFilter, update); err != nil {
+		return err
+	}
+
+	dispatches := conn.Collection("notification_dispatches")
+	dispatchId := mustObjectID(job.DispatchId)
+	if _, err := dispatches.UpdateOne(ctx, bson.M{"_id": dispatchId}, bson.M{"$inc": counts}); err != nil {
+		return err
+	}
+	_, err := dispatches.UpdateOne(ctx,
+		bson.M{
+			"_id":    dispatchId,
+			"status": "sending",
+			"$expr":  bson.M{"$gte": []any{bson.M{"$add": []any{"$sent", "$failed"}}, "$devices"}},
+		},
+		bson.M{"$set": bson.M{"status": "completed", "completedAt": time.Now().UTC()}},
+	)
+	return err
+}
+
+// mustObjectID converts the IDs read back from the database, which are always valid
+func mustObjectID(id string) primitive.ObjectID {
+	objID, _ := primitive.ObjectIDFromHex(id)
+	return objID
+}
+
+func GetNotificationDispatch(id string) (entities.NotificationDispatchStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("notification_dispatches")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.NotificationDispatchStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	var dispatch entities.NotificationDispatchStruct
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&dispatch); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.NotificationDispatchStruct{}, fmt.Errorf("no notification dispatch found with id: %s", id)
+		}
+		return entities.NotificationDispatchStruct{}, err
+	}
+	return dispatch, nil
+}
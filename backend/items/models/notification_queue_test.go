@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+	"trinity/backend/items/entities"
+	"trinity/backend/push"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// setupNotificationTest sends the notifications to a fake sender, for a user with the devices
+// of tokens
+func setupNotificationTest(t *testing.T, tokens ...string) (*push.FakeSender, string) {
+	t.Helper()
+	dbtest.Setup(t)
+	ctx := context.Background()
+	conn := db.GetDatabase()
+
+	sender := push.NewFakeSender()
+	SetPushSender(sender)
+	t.Cleanup(func() { SetPushSender(nil) })
+
+	userId := primitive.NewObjectID()
+	if _, err := conn.Collection("users").InsertOne(ctx, bson.M{"_id": userId, "firstName": "Ada", "lastName": "Lovelace"}); err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Now().UTC().Add(-time.Hour)
+	for _, token := range tokens {
+		device := entities.DeviceStruct{Token: token, UserId: userId.Hex(), RegisteredAt: seen, LastSeenAt: seen}
+		if _, err := conn.Collection("devices").InsertOne(ctx, device); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sender, userId.Hex()
+}
+
+func enqueueTestNotification(t *testing.T, userId string) entities.NotificationDispatchStruct {
+	t.Helper()
+
+	dispatch, err := EnqueueNotification(entities.NotificationRequestStruct{
+		Title:  "Your order is ready",
+		Body:   "Come and get it",
+		Type:   entities.NotificationTransactional,
+		Target: entities.NotificationTargetStruct{UserIds: []string{userId}},
+	})
+	if err != nil {
+		t.Fatalf("failed to enqueue notification: %v", err)
+	}
+	return dispatch
+}
+
+func getTestNotification(t *testing.T, dispatchId string) entities.NotificationStruct {
+	t.Helper()
+
+	var notification entities.NotificationStruct
+	err := db.GetDatabase().Collection("notifications").FindOne(context.Background(), bson.M{"dispatchId": dispatchId}).Decode(&notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+func TestNotificationWorkersSendAndPrune(t *testing.T) {
+	sender, userId := setupNotificationTest(t, "phone", "old-tablet")
+	sender.Errors["old-tablet"] = push.ErrUnregistered
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartNotificationWorkers(ctx)
+
+	dispatch := enqueueTestNotification(t, userId)
+	if dispatch.Devices != 2 {
+		t.Fatalf("got %d devices, want 2", dispatch.Devices)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for dispatch.Status != "completed" {
+		if time.Now().After(deadline) {
+			t.Fatalf("dispatch not completed in time: %+v", dispatch)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		var err error
+		if dispatch, err = GetNotificationDispatch(dispatch.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dispatch.Sent != 1 || dispatch.Failed != 1 || dispatch.Pruned != 1 || dispatch.CompletedAt.IsZero() {
+		t.Errorf("got sent %d, failed %d, pruned %d, want 1 of each", dispatch.Sent, dispatch.Failed, dispatch.Pruned)
+	}
+
+	sent := sender.Sent()
+	if len(sent) != 1 || sent[0].Token != "phone" {
+		t.Fatalf("got messages %+v, want one to phone", sent)
+	}
+	notification := getTestNotification(t, dispatch.Id)
+	if sent[0].Data["notificationId"] != notification.Id {
+		t.Errorf("got notificationId %q, want %q", sent[0].Data["notificationId"], notification.Id)
+	}
+	// Reaching one of the devices is enough
+	if notification.Status != "sent" {
+		t.Errorf("got notification status %s, want sent", notification.Status)
+	}
+
+	devices, err := GetUserDevices(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Token != "phone" {
+		t.Errorf("got devices %+v, want only phone left", devices)
+	}
+}
+
+func TestNotificationRetriesUntilMaxAttempts(t *testing.T) {
+	t.Setenv("NOTIFICATION_MAX_ATTEMPTS", "3")
+	sender, userId := setupNotificationTest(t, "phone")
+	sender.Errors["phone"] = errors.New("service unavailable")
+	ctx := context.Background()
+	jobs := db.GetDatabase().Collection("notification_jobs")
+
+	dispatch := enqueueTestNotification(t, userId)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		job, err := claimNotificationJob(ctx)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", attempt, err)
+		}
+		if job.Attempts != attempt {
+			t.Fatalf("got attempt %d, want %d", job.Attempts, attempt)
+		}
+		if err := processNotificationJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := jobs.FindOne(ctx, bson.M{"_id": mustObjectID(job.Id)}).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		if attempt == 3 {
+			if job.Status != "failed" {
+				t.Errorf("got status %s after the last attempt, want failed", job.Status)
+			}
+			break
+		}
+
+		// The next attempt waits for the backoff
+		if job.Status != "pending" || job.LastError == "" {
+			t.Fatalf("got status %s (%q) after attempt %d, want pending with the error", job.Status, job.LastError, attempt)
+		}
+		delay := time.Until(job.NextAttemptAt)
+		if want := notificationRetryDelay(attempt); delay <= want-5*time.Second || delay > want {
+			t.Errorf("attempt %d: next attempt in %v, want %v", attempt, delay, want)
+		}
+		if _, err := claimNotificationJob(ctx); err != mongo.ErrNoDocuments {
+			t.Fatalf("claimed a job before its next attempt: %v", err)
+		}
+
+		_, err = jobs.UpdateOne(ctx, bson.M{"_id": mustObjectID(job.Id)}, bson.M{"$set": bson.M{"nextAttemptAt": time.Now().UTC()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dispatch, err := GetNotificationDispatch(dispatch.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dispatch.Status != "completed" || dispatch.Sent != 0 || dispatch.Failed != 1 || dispatch.Pruned != 0 {
+		t.Errorf("got dispatch %s with sent %d, failed %d, pruned %d, want completed with 1 failed", dispatch.Status, dispatch.Sent, dispatch.Failed, dispatch.Pruned)
+	}
+	if notification := getTestNotification(t, dispatch.Id); notification.Status != "failed" {
+		t.Errorf("got notification status %s, want failed", notification.Status)
+	}
+	if devices, err := GetUserDevices(userId); err != nil || len(devices) != 1 {
+		t.Errorf("got devices %+v (%v), want the device kept", devices, err)
+	}
+}
+
+func TestNotificationRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		6:  16 * time.Minute,
+		7:  30 * time.Minute,
+		20: 30 * time.Minute,
+	}
+	for attempts, want := range cases {
+		if got := notificationRetryDelay(attempts); got != want {
+			t.Errorf("notificationRetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
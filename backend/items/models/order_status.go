@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AppLinkScheme opens the app on a screen, e.g. com.baptistegrimaldi.trinity://order/<id>
+const AppLinkScheme = "com.baptistegrimaldi.trinity://"
+
+// OrderStatusHook is called once the order of an invoice moved from previous to its status
+type OrderStatusHook func(userId string, invoice entities.InvoiceStruct, previous string)
+
+var orderStatusHooks []OrderStatusHook
+
+// OnOrderStatusChange registers a hook on the order status transitions, before the server
+// starts
+func OnOrderStatusChange(hook OrderStatusHook) {
+	orderStatusHooks = append(orderStatusHooks, hook)
+}
+
+func orderStatusChanged(userId string, invoice entities.InvoiceStruct, previous string) {
+	for _, hook := range orderStatusHooks {
+		hook(userId, invoice, previous)
+	}
+}
+
+// MarkOrderReady tells that a paid order is ready to be picked up
+func MarkOrderReady(invoiceId string) (entities.InvoiceStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("users")
+
+	userId, invoice, err := GetInvoiceById(invoiceId)
+	if err != nil {
+		return entities.InvoiceStruct{}, err
+	}
+	if invoice.Order.Status != "paid" {
+		return entities.InvoiceStruct{}, fmt.Errorf("only paid orders can be ready, order is %s", invoice.Order.Status)
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"invoices": bson.M{"$elemMatch": bson.M{"_id": invoiceId, "order.status": "paid"}}},
+		bson.M{"$set": bson.M{"invoices.$.order.status": "ready"}},
+	)
+	if err != nil {
+		return entities.InvoiceStruct{}, fmt.Errorf("failed to update invoice: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return entities.InvoiceStruct{}, fmt.Errorf("invoice %s was updated meanwhile", invoiceId)
+	}
+
+	invoice.Order.Status = "ready"
+	orderStatusChanged(userId, invoice, "paid")
+	return invoice, nil
+}
+
+// orderStatusMessages are the notifications of the order statuses customers are told about
+var orderStatusMessages = map[string]struct{ title, body string }{
+	"paid":      {"Order confirmed", "We received your payment of %s, your order is being prepared."},
+	"ready":     {"Order ready", "Your order of %s is ready to be picked up."},
+	"cancelled": {"Order cancelled", "Your order of %s was cancelled, you will be refunded."},
+	"refunded":  {"Order refunded", "Your order of %s was refunded."},
+}
+
+// NotifyOrderStatus sends the owner of an order a transactional push notification about its
+// new status, opening the order in the app
+func NotifyOrderStatus(userId string, invoice entities.InvoiceStruct, previous string) {
+	message, ok := orderStatusMessages[invoice.Order.Status]
+	if !ok {
+		return
+	}
+
+	_, err := EnqueueNotification(entities.NotificationRequestStruct{
+		Title:  message.title,
+		Body:   fmt.Sprintf(message.body, invoice.TotalPrice.String()),
+		Type:   entities.NotificationTransactional,
+		Target: entities.NotificationTargetStruct{UserIds: []string{userId}},
+		Data: map[string]string{
+			"invoiceId": invoice.Id,
+			"status":    invoice.Order.Status,
+			"link":      AppLinkScheme + "order/" + invoice.Id,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to notify the %s status of order %s: %v", invoice.Order.Status, invoice.Id, err)
+	}
+}
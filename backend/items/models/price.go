@@ -0,0 +1,318 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordPriceChange appends an already applied price to a product's history
+func recordPriceChange(ctx context.Context, productId string, priceVat entities.Money, priceNot entities.Money, source string, createdBy string) error {
+	collection := db.GetDatabase().Collection("price_history")
+
+	now := time.Now()
+	_, err := collection.InsertOne(ctx, entities.PriceHistoryStruct{
+		ProductId:   productId,
+		PriceVat:    priceVat,
+		PriceNot:    priceNot,
+		EffectiveAt: now,
+		Status:      "applied",
+		Source:      source,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record price history: %v", err)
+	}
+	return nil
+}
+
+// ChangeProductPrice applies a price right away, or schedules it when its effective date is in the future
+func ChangeProductPrice(productId string, change entities.PriceChangeStruct, userId string) (entities.PriceHistoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("price_history")
+
+	if err := checkBaseCurrency(change.PriceVat); err != nil {
+		return entities.PriceHistoryStruct{}, err
+	}
+
+	product, err := GetProductById(productId)
+	if err != nil {
+		return entities.PriceHistoryStruct{}, fmt.Errorf("no product found with id: %s", productId)
+	}
+	// At the current VAT rate, a scheduled change gets the rate of the day it is applied
+	priceNot, err := netPrice(product, change.PriceVat)
+	if err != nil {
+		return entities.PriceHistoryStruct{}, err
+	}
+
+	now := time.Now()
+	effectiveAt := now
+	if change.EffectiveAt != "" {
+		parsed, err := time.Parse(time.RFC3339, change.EffectiveAt)
+		if err != nil {
+			return entities.PriceHistoryStruct{}, fmt.Errorf("invalid effective_at, expected RFC3339: %v", err)
+		}
+		if parsed.After(now) {
+			effectiveAt = parsed
+		}
+	}
+
+	entry := entities.PriceHistoryStruct{
+		ProductId:   productId,
+		PriceVat:    change.PriceVat,
+		PriceNot:    priceNot,
+		EffectiveAt: effectiveAt,
+		Status:      "scheduled",
+		Source:      "manual",
+		CreatedBy:   userId,
+		CreatedAt:   now,
+	}
+
+	if !effectiveAt.After(now) {
+		if err := setProductPrice(ctx, productId, change.PriceVat, priceNot); err != nil {
+			return entities.PriceHistoryStruct{}, err
+		}
+		entry.Status = "applied"
+	}
+
+	inserted, err := collection.InsertOne(ctx, entry)
+	if err != nil {
+		return entities.PriceHistoryStruct{}, fmt.Errorf("failed to record price history: %v", err)
+	}
+
+	insertedID, ok := inserted.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return entities.PriceHistoryStruct{}, fmt.Errorf("failed to convert inserted ID to ObjectID")
+	}
+	entry.Id = insertedID.Hex()
+
+	return entry, nil
+}
+
+func setProductPrice(ctx context.Context, productId string, priceVat entities.Money, priceNot entities.Money) error {
+	collection := db.GetDatabase().Collection("products")
+
+	objID, err := primitive.ObjectIDFromHex(productId)
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"priceVat": priceVat,
+		"priceNot": priceNot,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to update product price: %v", err)
+	}
+	return nil
+}
+
+// GetProductPriceHistory lists a product's prices, the most recent (or furthest scheduled) first
+func GetProductPriceHistory(productId string) ([]entities.PriceHistoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("price_history")
+
+	opts := options.Find().SetSort(bson.D{{Key: "effectiveAt", Value: -1}, {Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"productId": productId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	history := []entities.PriceHistoryStruct{}
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// GetProductPriceAt reconstructs the price a product had at a given date
+func GetProductPriceAt(productId string, at time.Time) (entities.PriceHistoryStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("price_history")
+
+	filter := bson.M{
+		"productId":   productId,
+		"status":      "applied",
+		"effectiveAt": bson.M{"$lte": at},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "effectiveAt", Value: -1}, {Key: "createdAt", Value: -1}})
+
+	var entry entities.PriceHistoryStruct
+	err := collection.FindOne(ctx, filter, opts).Decode(&entry)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.PriceHistoryStruct{}, fmt.Errorf("no price known for product %s at %s", productId, at.Format(time.RFC3339))
+		}
+		return entities.PriceHistoryStruct{}, err
+	}
+	return entry, nil
+}
+
+// CancelScheduledPriceChange drops a price change that has not been applied yet
+func CancelScheduledPriceChange(productId string, changeId string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("price_history")
+
+	objID, err := primitive.ObjectIDFromHex(changeId)
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "productId": productId, "status": "scheduled"},
+		bson.M{"$set": bson.M{"status": "cancelled"}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("no scheduled price change found with id: %s", changeId)
+	}
+	return nil
+}
+
+// scheduledNetPrice derives the price without VAT of a scheduled change at the VAT rate of
+// the product when it is applied
+func scheduledNetPrice(entry entities.PriceHistoryStruct) (entities.Money, error) {
+	product, err := GetProductById(entry.ProductId)
+	if err != nil {
+		return entities.Money{}, err
+	}
+	return netPrice(product, entry.PriceVat)
+}
+
+// priceChangeLock is how long the scheduler has to apply a change it claimed, after which
+// another run takes it over
+const priceChangeLock = time.Minute
+
+// ApplyScheduledPriceChanges applies every scheduled price whose effective date has passed,
+// oldest first so the latest change wins. Run periodically by the scheduler.
+func ApplyScheduledPriceChanges() error {
+	conn := db.GetDatabase()
+	ctx := context.Background()
+	collection := conn.Collection("price_history")
+
+	applied := 0
+	for {
+		// Claim one due change at a time so concurrent runs never apply it twice. It is only
+		// applied once the product has the price, a crashed run leaves it to the next one.
+		now := time.Now()
+		var entry entities.PriceHistoryStruct
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"effectiveAt": bson.M{"$lte": now}, "$or": []bson.M{
+				{"status": "scheduled"},
+				{"status": "applying", "lockedUntil": bson.M{"$lte": now}},
+			}},
+			bson.M{"$set": bson.M{"status": "applying", "lockedUntil": now.Add(priceChangeLock)}},
+			options.FindOneAndUpdate().SetSort(bson.M{"effectiveAt": 1}),
+		).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim scheduled price change: %v", err)
+		}
+
+		objID, err := primitive.ObjectIDFromHex(entry.Id)
+		if err != nil {
+			return fmt.Errorf("invalid price change id %s: %v", entry.Id, err)
+		}
+
+		priceNot, err := scheduledNetPrice(entry)
+		if err != nil {
+			// The product is gone or has no VAT rate, retrying will not help and must not hold
+			// back the changes of the other products
+			log.Printf("Failed to apply price change %s: %v", entry.Id, err)
+			_, markErr := collection.UpdateOne(ctx,
+				bson.M{"_id": objID, "status": "applying"},
+				bson.M{"$set": bson.M{"status": "failed", "lastError": err.Error()}, "$unset": bson.M{"lockedUntil": ""}},
+			)
+			if markErr != nil {
+				return fmt.Errorf("failed to mark price change %s failed: %v", entry.Id, markErr)
+			}
+			continue
+		}
+
+		if err := setProductPrice(ctx, entry.ProductId, entry.PriceVat, priceNot); err != nil {
+			// Later changes wait for this one, so that they are still applied in order
+			_, releaseErr := collection.UpdateOne(ctx,
+				bson.M{"_id": objID, "status": "applying"},
+				bson.M{"$set": bson.M{"status": "scheduled"}, "$unset": bson.M{"lockedUntil": ""}},
+			)
+			if releaseErr != nil {
+				log.Printf("Failed to release price change %s: %v", entry.Id, releaseErr)
+			}
+			return fmt.Errorf("failed to apply price change %s: %v", entry.Id, err)
+		}
+
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": objID, "status": "applying"},
+			bson.M{"$set": bson.M{"status": "applied", "source": "schedule", "priceNot": priceNot}, "$unset": bson.M{"lockedUntil": ""}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to mark price change %s applied: %v", entry.Id, err)
+		}
+		applied++
+	}
+
+	if applied > 0 {
+		log.Printf("Applied %d scheduled price changes", applied)
+	}
+	return nil
+}
+
+// InitializePriceHistory records the current price of every product that has no history yet
+func InitializePriceHistory() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+
+	known, err := conn.Collection("price_history").Distinct(ctx, "productId", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool, len(known))
+	for _, id := range known {
+		if productId, ok := id.(string); ok {
+			seen[productId] = true
+		}
+	}
+
+	cursor, err := conn.Collection("products").Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var products []entities.ProductStruct
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+	for _, product := range products {
+		if seen[product.Id] {
+			continue
+		}
+		if err := recordPriceChange(ctx, product.Id, product.PriceVat, product.PriceNot, "initial", ""); err != nil {
+			return recorded, err
+		}
+		recorded++
+	}
+
+	return recorded, nil
+}
@@ -2,11 +2,10 @@ package models
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"time"
+	"trinity/backend/catalog"
 	"trinity/backend/db"
 	"trinity/backend/items/entities"
 
@@ -15,105 +14,26 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func getOpenFoodFactsData(reference string) (entities.ProductStruct, error) {
-	url := fmt.Sprintf("https://world.openfoodfacts.org/api/v0/product/%s.json", reference)
+// catalogSource provides product details from a barcode, see SetCatalogSource
+var catalogSource catalog.CatalogSource = catalog.NewCachedSource(catalog.NewOpenFoodFactsClient())
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return entities.ProductStruct{}, fmt.Errorf("error fetching product data: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return entities.ProductStruct{}, fmt.Errorf("Openfood API returned non-200 status code: %d", resp.StatusCode)
-	}
-
-	var openFoodFactsResp struct {
-		Status  int `json:"status"`
-		Product struct {
-			ProductName   string `json:"product_name"`
-			Brands        string `json:"brands"`
-			Categories    string `json:"categories"`
-			ImageURL      string `json:"image_url"`
-			ImageThumbURL string `json:"image_thumb_url"`
-			Nutriments    struct {
-				Energy100g   float64 `json:"energy-kcal_100g"`
-				Proteins100g float64 `json:"proteins_100g"`
-				Fat100g      float64 `json:"fat_100g"`
-				Carbs100g    float64 `json:"carbohydrates_100g"`
-			} `json:"nutriments"`
-		} `json:"product"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&openFoodFactsResp); err != nil {
-		return entities.ProductStruct{}, fmt.Errorf("error decoding response: %v", err)
-	}
-
-	if openFoodFactsResp.Status != 1 {
-		return entities.ProductStruct{}, fmt.Errorf("product not found")
-	}
-
-	nutritionalInfo := fmt.Sprintf(
-		"Energy: %.1f kcal/100g, Proteins: %.1fg/100g, Fat: %.1fg/100g, Carbohydrates: %.1fg/100g",
-		openFoodFactsResp.Product.Nutriments.Energy100g,
-		openFoodFactsResp.Product.Nutriments.Proteins100g,
-		openFoodFactsResp.Product.Nutriments.Fat100g,
-		openFoodFactsResp.Product.Nutriments.Carbs100g,
-	)
-
-	product := entities.ProductStruct{
-		Reference: reference,
-		Name:      openFoodFactsResp.Product.ProductName,
-		Brand:     openFoodFactsResp.Product.Brands,
-		Category:  openFoodFactsResp.Product.Categories,
-		Images: entities.ImagesStruct{
-			S:  openFoodFactsResp.Product.ImageThumbURL,
-			XL: openFoodFactsResp.Product.ImageURL,
-		},
-		NutritionalInformation: nutritionalInfo,
-		Archived:               false,
-	}
-
-	return product, nil
+// SetCatalogSource replaces the catalog used to enrich products (e.g. a fake in tests)
+func SetCatalogSource(s catalog.CatalogSource) {
+	catalogSource = s
 }
 
 func GetTotalCategories() (int32, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
-	collection := conn.Collection("products")
-	pipeline := []bson.M{
-		// 1. Project a new field "categories" by splitting the category string into an array.
-		{"$project": bson.M{
-			"categories": bson.M{
-				"$split": []any{"$category", ", "},
-			},
-		}},
-		// 2. Unwind the "categories" array so each element becomes its own document.
-		{"$unwind": "$categories"},
-		// 3. Group by the category (each distinct category becomes a group).
-		{"$group": bson.M{
-			"_id": "$categories",
-		}},
-		// 4. Count the number of distinct categories.
-		{"$count": "distinctCategoryCount"},
-	}
+	collection := conn.Collection("categories")
 
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	count, err := collection.CountDocuments(ctx, bson.M{"archived": false})
 	if err != nil {
-		log.Fatalf("Aggregate error: %v", err)
-	}
-
-	var results []bson.M
-	if err := cursor.All(ctx, &results); err != nil {
-		log.Fatalf("Cursor error: %v", err)
+		return 0, err
 	}
 
-	if len(results) > 0 {
-		fmt.Printf("Distinct category count: %v\n", results[0]["distinctCategoryCount"])
-	} else {
-		fmt.Println("No categories found.")
-	}
-	return results[0]["distinctCategoryCount"].(int32), nil
+	fmt.Printf("Distinct category count: %v\n", count)
+	return int32(count), nil
 }
 
 func GetTotalProductStock() (float64, error) {
@@ -146,7 +66,7 @@ func GetTotalProductStock() (float64, error) {
 	return results[0]["totalStock"].(float64), nil
 }
 
-func GetAverageProductCost() (float64, error) {
+func GetAverageProductCost() (entities.Money, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("products")
@@ -154,7 +74,7 @@ func GetAverageProductCost() (float64, error) {
 		{
 			"$group": bson.M{
 				"_id":     nil,
-				"avgCost": bson.M{"$avg": "$priceVat"},
+				"avgCost": bson.M{"$avg": "$priceVat.amount"},
 			},
 		},
 	}
@@ -169,12 +89,13 @@ func GetAverageProductCost() (float64, error) {
 		log.Fatalf("Cursor error: %v", err)
 	}
 
-	if len(results) > 0 {
-		fmt.Printf("Average Product Cost: %v\n", results[0]["avgCost"])
-	} else {
+	if len(results) == 0 {
 		fmt.Println("No products found.")
+		return entities.NewMoney(0, entities.DefaultCurrency), nil
 	}
-	return results[0]["avgCost"].(float64), nil
+	fmt.Printf("Average Product Cost: %v\n", results[0]["avgCost"])
+
+	return entities.MoneyFromFloat(toFloat64(results[0]["avgCost"])/100, entities.DefaultCurrency), nil
 }
 
 func GetProductsPerCategory() ([]entities.StatsProduct, error) {
@@ -182,30 +103,40 @@ func GetProductsPerCategory() ([]entities.StatsProduct, error) {
 	ctx := context.TODO()
 	collection := conn.Collection("products")
 	pipeline := []bson.M{
-		// 1. Split the "category" string into an array of categories
-		{"$project": bson.M{
-			"categories": bson.M{
-				"$split": []any{"$category", ", "},
-			},
-		}},
-		// 2. Unwind the "categories" array so each category becomes a separate document
-		{"$unwind": "$categories"},
-		// 3. Group by the individual category and count the number of products
+		// 1. Only count products still on sale
+		{"$match": bson.M{"archived": false}},
+		// 2. Unwind the "categoryIds" array so each category becomes a separate document
+		{"$unwind": "$categoryIds"},
+		// 3. Group by category ID and count the number of products
 		{"$group": bson.M{
-			"_id":   "$categories",
-			"name":  bson.M{"$first": "$categories"},
+			"_id":   "$categoryIds",
 			"total": bson.M{"$sum": 1},
 		}},
+		// 4. Lookup the category to get its display name
+		{"$lookup": bson.M{
+			"from": "categories",
+			"let":  bson.M{"categoryId": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []any{"$_id", bson.M{"$toObjectId": "$$categoryId"}}}}},
+			},
+			"as": "category",
+		}},
+		{"$unwind": "$category"},
+		{"$project": bson.M{
+			"name":  "$category.name",
+			"total": 1,
+		}},
+		{"$sort": bson.M{"total": -1}},
 	}
 
 	cursor, err := collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		log.Fatalf("Aggregate error: %v", err)
+		return nil, fmt.Errorf("error aggregating products per category: %v", err)
 	}
 
-	var stats []entities.StatsProduct
+	stats := []entities.StatsProduct{}
 	if err := cursor.All(ctx, &stats); err != nil {
-		log.Fatalf("Error decoding results: %v", err)
+		return nil, fmt.Errorf("error decoding results: %v", err)
 	}
 
 	for _, stat := range stats {
@@ -218,17 +149,38 @@ func CreateProduct(p entities.ProductBasic) (entities.ProductStruct, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 
-	product, err := getOpenFoodFactsData(p.Reference)
+	if err := checkBaseCurrency(p.PriceVat); err != nil {
+		return entities.ProductStruct{}, err
+	}
 
+	sources := catalog.Chain{catalogSource, catalog.NewManualSource(p.Name, p.Brand, p.Category)}
+	product, source, err := sources.LookupWithSource(ctx, p.Reference)
 	if err != nil {
-		return entities.ProductStruct{}, err
+		// Nobody knows this barcode yet: keep a placeholder that can be re-synced later
+		log.Printf("No catalog data for product %s, creating a placeholder: %v", p.Reference, err)
+		product = entities.ProductStruct{
+			Reference: p.Reference,
+			Name:      p.Reference,
+		}
+		source = "pending"
+	} else {
+		product.CatalogSyncedAt = time.Now().Format(time.RFC3339)
 	}
+	product.CatalogSource = source
 
 	product.PriceVat = p.PriceVat
-	product.PriceNot = p.PriceNot
 
 	product.StockQuantity = p.StockQuantity
 
+	product.CategoryIds, err = ImportOpenFoodFactsCategories(product.Category)
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+
+	if product.PriceNot, err = netPrice(product, product.PriceVat); err != nil {
+		return entities.ProductStruct{}, err
+	}
+
 	collection := conn.Collection("products")
 	allReadyArchived := false
 	dupProduct := entities.ProductStruct{}
@@ -253,6 +205,9 @@ func CreateProduct(p entities.ProductBasic) (entities.ProductStruct, error) {
 		if err != nil {
 			return entities.ProductStruct{}, fmt.Errorf("archived product with reference %s already exists, failed to update archived product: %v", p.Reference, err)
 		}
+		if err := recordPriceChange(ctx, dupProduct.Id, product.PriceVat, product.PriceNot, "manual", ""); err != nil {
+			log.Printf("Failed to record price of product %s: %v", p.Reference, err)
+		}
 		return dupProduct, nil
 	}
 
@@ -263,6 +218,10 @@ func CreateProduct(p entities.ProductBasic) (entities.ProductStruct, error) {
 
 	product.Id = insertedID.Hex()
 
+	if err := recordPriceChange(ctx, product.Id, product.PriceVat, product.PriceNot, "initial", ""); err != nil {
+		log.Printf("Failed to record price of product %s: %v", p.Reference, err)
+	}
+
 	return product, nil
 }
 
@@ -377,14 +336,89 @@ func UpdateProduct(productId string, p entities.ProductStruct) (entities.Product
 	return p, nil
 }
 
-func GetProductBySearchProducts(productName string) ([]entities.ProductStruct, error) {
+// SyncProductCatalog re-fetches a product's catalog details, bypassing the cache.
+// Prices, stock and archive status are left untouched.
+func SyncProductCatalog(productId string) (entities.ProductStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("products")
+
+	product, err := GetProductById(productId)
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+
+	var fresh entities.ProductStruct
+	if refresher, ok := catalogSource.(catalog.Refresher); ok {
+		fresh, err = refresher.Refresh(ctx, product.Reference)
+	} else {
+		fresh, err = catalogSource.Lookup(ctx, product.Reference)
+	}
+	if err != nil {
+		return entities.ProductStruct{}, fmt.Errorf("failed to sync product %s: %w", product.Reference, err)
+	}
+
+	categoryIds, err := ImportOpenFoodFactsCategories(fresh.Category)
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+
+	product.Name = fresh.Name
+	product.Brand = fresh.Brand
+	product.Category = fresh.Category
+	product.CategoryIds = categoryIds
+	if product.Images.Source == "upload" {
+		// Keep our own photos, only refresh the fallback URLs
+		product.Images.OriginalS = fresh.Images.S
+		product.Images.OriginalXL = fresh.Images.XL
+	} else {
+		product.Images = fresh.Images
+	}
+	product.NutritionalInformation = fresh.NutritionalInformation
+	product.Nutrition = fresh.Nutrition
+	product.Allergens = fresh.Allergens
+	product.NutriScore = fresh.NutriScore
+	product.NovaGroup = fresh.NovaGroup
+	product.Ingredients = fresh.Ingredients
+	product.Labels = fresh.Labels
+	product.CatalogSource = catalogSource.Name()
+	product.CatalogSyncedAt = time.Now().Format(time.RFC3339)
+
+	objID, _ := primitive.ObjectIDFromHex(productId)
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"name":                   product.Name,
+		"brand":                  product.Brand,
+		"category":               product.Category,
+		"categoryIds":            product.CategoryIds,
+		"images":                 product.Images,
+		"nutritionalInformation": product.NutritionalInformation,
+		"nutrition":              product.Nutrition,
+		"allergens":              product.Allergens,
+		"nutriScore":             product.NutriScore,
+		"novaGroup":              product.NovaGroup,
+		"ingredients":            product.Ingredients,
+		"labels":                 product.Labels,
+		"catalogSource":          product.CatalogSource,
+		"catalogSyncedAt":        product.CatalogSyncedAt,
+	}})
+	if err != nil {
+		return entities.ProductStruct{}, err
+	}
+
+	return product, nil
+}
+
+func GetProductBySearchProducts(productName string, f entities.ProductFilterStruct) ([]entities.ProductStruct, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("products")
 
 	var products []entities.ProductStruct
 
-	filter := bson.M{"name": bson.M{"$regex": productName, "$options": "i"}}
+	filter := productSearchFilter(f)
+	if productName != "" {
+		filter["name"] = bson.M{"$regex": productName, "$options": "i"}
+	}
 
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
@@ -403,8 +437,32 @@ func GetProductBySearchProducts(productName string) ([]entities.ProductStruct, e
 	return products, nil
 }
 
+// productSearchFilter translates nutrition search filters into a MongoDB filter
+func productSearchFilter(f entities.ProductFilterStruct) bson.M {
+	filter := bson.M{}
+
+	// Products whose allergens are unknown are never listed as free of any
+	if len(f.AllergenFree) > 0 {
+		filter["allergens"] = bson.M{"$exists": true, "$ne": nil, "$nin": f.AllergenFree}
+	}
+	if len(f.NutriScores) > 0 {
+		filter["nutriScore"] = bson.M{"$in": f.NutriScores}
+	}
+	if f.MaxNova > 0 {
+		filter["novaGroup"] = bson.M{"$gte": 1, "$lte": f.MaxNova}
+	}
+	if len(f.Labels) > 0 {
+		filter["labels"] = bson.M{"$all": f.Labels}
+	}
+	if f.CategoryId != "" {
+		filter["categoryIds"] = f.CategoryId
+	}
+
+	return filter
+}
+
 // getUserTopCategories retrieves a user's most frequently ordered product categories
-func getUserTopCategories(ctx context.Context, userObjID primitive.ObjectID) ([]bson.M, error) {
+func getUserTopCategories(ctx context.Context, userObjID primitive.ObjectID) ([]string, error) {
 	conn := db.GetDatabase()
 	userCollection := conn.Collection("users")
 
@@ -429,15 +487,9 @@ func getUserTopCategories(ctx context.Context, userObjID primitive.ObjectID) ([]
 		},
 		// Unwind product details
 		{"$unwind": "$productDetails"},
-		// Extract categories
+		// Extract category IDs
 		{"$project": bson.M{
-			"category": bson.M{
-				"$map": bson.M{
-					"input": bson.M{"$split": []any{"$productDetails.category", ", "}},
-					"as":    "item",
-					"in":    bson.M{"$trim": bson.M{"input": "$$item"}},
-				},
-			},
+			"category": "$productDetails.categoryIds",
 			"quantity": "$invoices.order.products.quantity",
 		}},
 		// Unwind categories to count them individually
@@ -464,12 +516,18 @@ func getUserTopCategories(ctx context.Context, userObjID primitive.ObjectID) ([]
 	}
 	defer cursor.Close(ctx)
 
-	var results []bson.M
+	var results []struct {
+		Category string `bson:"category"`
+	}
 	if err = cursor.All(ctx, &results); err != nil {
 		return nil, fmt.Errorf("error retrieving user category data: %v", err)
 	}
 
-	return results, nil
+	categoryIds := make([]string, 0, len(results))
+	for _, result := range results {
+		categoryIds = append(categoryIds, result.Category)
+	}
+	return categoryIds, nil
 }
 
 // getAllActivePromotions retrieves all currently active promotions
@@ -499,40 +557,36 @@ func getAllActivePromotions(ctx context.Context) ([]entities.PromoResponse, erro
 	return allPromotions, nil
 }
 
-// getPromotionsForCategories retrieves promotions that match specific product categories
-func getPromotionsForCategories(ctx context.Context, categories []bson.M) ([]entities.PromoResponse, error) {
-	fmt.Printf("categories: %v\n", categories)
+// getPromotionsForCategories retrieves promotions that target products of specific categories
+func getPromotionsForCategories(ctx context.Context, categoryIds []string) ([]entities.PromoResponse, error) {
 	conn := db.GetDatabase()
+	productCollection := conn.Collection("products")
 	promoCollection := conn.Collection("promotions")
 
-	// Create the $or conditions for category matching
-	categoryConditions := make([]bson.M, 0, len(categories))
-	for _, category := range categories {
-		categoryConditions = append(categoryConditions,
-			bson.M{"products.category": bson.M{"$regex": category["category"].(string), "$options": "i"}})
+	// Find the products belonging to the top categories
+	productIds, err := productCollection.Distinct(ctx, "_id", bson.M{"categoryIds": bson.M{"$in": categoryIds}})
+	if err != nil {
+		return nil, fmt.Errorf("error finding category products: %v", err)
 	}
 
-	// Pipeline to find promotions with products in the top categories
-	promoPipeline := []bson.M{
+	// Promotions embed products with their ID as a hex string
+	productHexIds := make([]string, 0, len(productIds))
+	for _, id := range productIds {
+		if objID, ok := id.(primitive.ObjectID); ok {
+			productHexIds = append(productHexIds, objID.Hex())
+		}
+	}
 
-		{"$unwind": "$products"},
+	// Pipeline to find promotions with products in the top categories, or targeting them
+	promoPipeline := []bson.M{
 		{"$match": bson.M{
-			"$or":      categoryConditions,
+			"$or": []bson.M{
+				{"products._id": bson.M{"$in": productHexIds}},
+				{"categoryIds": bson.M{"$in": categoryIds}},
+			},
 			"status":   "active",
 			"end_date": bson.M{"$gte": time.Now().Format(time.RFC3339)},
 		}},
-		{"$group": bson.M{
-			"_id":            "$_id",
-			"name":           bson.M{"$first": "$name"},
-			"description":    bson.M{"$first": "$description"},
-			"discount_type":  bson.M{"$first": "$discount_type"},
-			"discount_value": bson.M{"$first": "$discount_value"},
-			"code":           bson.M{"$first": "$code"},
-			"start_date":     bson.M{"$first": "$start_date"},
-			"end_date":       bson.M{"$first": "$end_date"},
-			"min_purchase":   bson.M{"$first": "$min_purchase"},
-			"products":       bson.M{"$push": "$products"},
-		}},
 	}
 
 	promoCursor, err := promoCollection.Aggregate(ctx, promoPipeline)
@@ -559,11 +613,18 @@ func GetUserRecommendedPromotions(userId string) ([]entities.PromoResponse, erro
 		return nil, fmt.Errorf("invalid user ID format")
 	}
 
-	// Get the user's top categories
-	categories, err := getUserTopCategories(ctx, userObjID)
+	// Use the top categories computed with the recommendations, the user may have ordered
+	// since they were last computed
+	recommendations, err := getUserRecommendations(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
+	categories := recommendations.TopCategories
+	if len(categories) == 0 {
+		if categories, err = getUserTopCategories(ctx, userObjID); err != nil {
+			return nil, err
+		}
+	}
 
 	// If user has no order history, return all active promotions
 	if len(categories) == 0 {
@@ -583,6 +644,7 @@ func GetAllDeals() ([]entities.PromoResponse, error) {
 	allPromosPipeline := []bson.M{
 		{"$match": bson.M{
 			"discount_type": "bogo",
+			"status":        "active",
 			"end_date":      bson.M{"$gte": time.Now().Format(time.RFC3339)},
 		}},
 	}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/imaging"
+	"trinity/backend/items/entities"
+	"trinity/backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxMirroredImageSize = 10 << 20 // 10 MB
+
+var blobStore storage.BlobStore
+
+func SetBlobStore(s storage.BlobStore) {
+	blobStore = s
+}
+
+// productImageURL is the public URL of an uploaded image, versioned so clients can cache it forever
+func productImageURL(productId string, size string, version string) string {
+	return fmt.Sprintf("%s/product/%s/image/%s?v=%s", os.Getenv("PUBLIC_API_URL"), productId, size, version)
+}
+
+// UploadProductImage stores a product photo as S and XL JPEG thumbnails and points the
+// product images at them. The OpenFoodFacts URLs are kept to fall back on.
+func UploadProductImage(productId string, data []byte) (entities.ImagesStruct, error) {
+	ctx := context.TODO()
+
+	if blobStore == nil {
+		return entities.ImagesStruct{}, fmt.Errorf("image storage is not configured")
+	}
+
+	product, err := GetProductById(productId)
+	if err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	small, err := imaging.EncodeJPEG(imaging.Thumbnail(img, imaging.SizeS))
+	if err != nil {
+		return entities.ImagesStruct{}, err
+	}
+	large, err := imaging.EncodeJPEG(imaging.Thumbnail(img, imaging.SizeXL))
+	if err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	images := entities.ImagesStruct{
+		Source:     "upload",
+		Version:    version,
+		SKey:       fmt.Sprintf("products/%s/%s-s.jpg", productId, version),
+		XLKey:      fmt.Sprintf("products/%s/%s-xl.jpg", productId, version),
+		S:          productImageURL(productId, "s", version),
+		XL:         productImageURL(productId, "xl", version),
+		OriginalS:  product.Images.S,
+		OriginalXL: product.Images.XL,
+	}
+	if product.Images.Source == "upload" {
+		images.OriginalS = product.Images.OriginalS
+		images.OriginalXL = product.Images.OriginalXL
+	}
+
+	if err := blobStore.Put(ctx, images.SKey, small, "image/jpeg"); err != nil {
+		return entities.ImagesStruct{}, err
+	}
+	if err := blobStore.Put(ctx, images.XLKey, large, "image/jpeg"); err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	if err := setProductImages(ctx, productId, images); err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	// Old versions are no longer referenced
+	if product.Images.Source == "upload" {
+		deleteImageBlobs(ctx, product.Images)
+	}
+
+	return images, nil
+}
+
+// MirrorProductImage downloads the product's OpenFoodFacts image into our own storage,
+// so the product stays presentable if the image disappears from OpenFoodFacts
+func MirrorProductImage(productId string) (entities.ImagesStruct, error) {
+	product, err := GetProductById(productId)
+	if err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	source := product.Images.XL
+	if product.Images.Source == "upload" {
+		source = product.Images.OriginalXL
+	}
+	if source == "" {
+		return entities.ImagesStruct{}, fmt.Errorf("product %s has no image to mirror", productId)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return entities.ImagesStruct{}, fmt.Errorf("failed to download image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return entities.ImagesStruct{}, fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMirroredImageSize))
+	if err != nil {
+		return entities.ImagesStruct{}, fmt.Errorf("failed to download image: %v", err)
+	}
+
+	return UploadProductImage(productId, data)
+}
+
+// DeleteProductImage removes the uploaded photo and restores the OpenFoodFacts images
+func DeleteProductImage(productId string) (entities.ImagesStruct, error) {
+	ctx := context.TODO()
+
+	product, err := GetProductById(productId)
+	if err != nil {
+		return entities.ImagesStruct{}, err
+	}
+	if product.Images.Source != "upload" {
+		return product.Images, nil
+	}
+
+	images := entities.ImagesStruct{
+		S:      product.Images.OriginalS,
+		XL:     product.Images.OriginalXL,
+		Source: "openfoodfacts",
+	}
+	if err := setProductImages(ctx, productId, images); err != nil {
+		return entities.ImagesStruct{}, err
+	}
+
+	if blobStore != nil {
+		deleteImageBlobs(ctx, product.Images)
+	}
+
+	return images, nil
+}
+
+// GetProductImage returns the uploaded image of the given size ("s" or "xl") along with its version.
+// When the product has no uploaded image, the OpenFoodFacts URL is returned instead.
+func GetProductImage(productId string, size string) (storage.Blob, string, string, error) {
+	ctx := context.TODO()
+
+	product, err := GetProductById(productId)
+	if err != nil {
+		return storage.Blob{}, "", "", err
+	}
+
+	if product.Images.Source != "upload" || blobStore == nil {
+		fallback := product.Images.S
+		if size == "xl" {
+			fallback = product.Images.XL
+		}
+		return storage.Blob{}, "", fallback, storage.ErrNotFound
+	}
+
+	key := product.Images.SKey
+	if size == "xl" {
+		key = product.Images.XLKey
+	}
+
+	blob, err := blobStore.Get(ctx, key)
+	if err != nil {
+		fallback := product.Images.OriginalS
+		if size == "xl" {
+			fallback = product.Images.OriginalXL
+		}
+		return storage.Blob{}, "", fallback, err
+	}
+
+	return blob, product.Images.Version, "", nil
+}
+
+func setProductImages(ctx context.Context, productId string, images entities.ImagesStruct) error {
+	collection := db.GetDatabase().Collection("products")
+
+	objID, err := primitive.ObjectIDFromHex(productId)
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"images": images}})
+	return err
+}
+
+func deleteImageBlobs(ctx context.Context, images entities.ImagesStruct) {
+	for _, key := range []string{images.SKey, images.XLKey} {
+		if key == "" {
+			continue
+		}
+		if err := blobStore.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete image %s: %v", key, err)
+		}
+	}
+}
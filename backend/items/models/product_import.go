@@ -0,0 +1,398 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ProductImportColumns is the column order used by CSV imports and exports, price_not is
+// ignored by imports
+var ProductImportColumns = []string{"reference", "price_vat", "price_not", "stock_quantity", "name", "brand", "category"}
+
+// ParseProductImport decodes a CSV or JSON import file. Rows that cannot be parsed
+// are returned as failed results, keyed by their line number.
+func ParseProductImport(format string, data []byte) ([]entities.ProductImportRow, []entities.ImportRowResult, error) {
+	switch format {
+	case "json":
+		var rows []entities.ProductImportRow
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON import file: %v", err)
+		}
+		for i := range rows {
+			rows[i].Line = i + 1
+		}
+		return rows, nil, nil
+	case "csv":
+		return parseProductImportCSV(data)
+	default:
+		return nil, nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+}
+
+func parseProductImportCSV(data []byte) ([]entities.ProductImportRow, []entities.ImportRowResult, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	// Spreadsheets set to a French locale export "9,60" with ";" separators
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	decimalComma := false
+	if strings.Contains(firstLine, ";") && !strings.Contains(firstLine, ",") {
+		reader.Comma = ';'
+		decimalComma = true
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV import file: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["reference"]; !ok {
+		return nil, nil, fmt.Errorf("invalid CSV import file: missing reference column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	number := func(record []string, name string) (float64, error) {
+		value := field(record, name)
+		if value == "" {
+			return 0, nil
+		}
+		if decimalComma {
+			value = strings.Replace(value, ",", ".", 1)
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %q", name, value)
+		}
+		return parsed, nil
+	}
+	amount := func(record []string, name string) (entities.Money, error) {
+		value := field(record, name)
+		if value == "" {
+			return entities.Money{}, nil
+		}
+		parsed, err := entities.ParseMoney(value, entities.DefaultCurrency)
+		if err != nil {
+			return entities.Money{}, fmt.Errorf("invalid %s: %q", name, value)
+		}
+		return parsed, nil
+	}
+
+	var rows []entities.ProductImportRow
+	var failures []entities.ImportRowResult
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			failures = append(failures, entities.ImportRowResult{Line: line, Action: "failed", Error: err.Error()})
+			continue
+		}
+
+		row := entities.ProductImportRow{
+			Line:      line,
+			Reference: field(record, "reference"),
+			Name:      field(record, "name"),
+			Brand:     field(record, "brand"),
+			Category:  field(record, "category"),
+		}
+
+		var parseErr error
+		if row.PriceVat, err = amount(record, "price_vat"); err != nil {
+			parseErr = err
+		}
+		if row.StockQuantity, err = number(record, "stock_quantity"); err != nil {
+			parseErr = err
+		}
+		if parseErr != nil {
+			failures = append(failures, entities.ImportRowResult{Line: line, Reference: row.Reference, Action: "failed", Error: parseErr.Error()})
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, failures, nil
+}
+
+func validateProductImportRow(row entities.ProductImportRow) error {
+	if row.Reference == "" {
+		return fmt.Errorf("reference is required")
+	}
+	if row.PriceVat.Amount <= 0 {
+		return fmt.Errorf("price_vat must be positive")
+	}
+	if row.StockQuantity < 0 {
+		return fmt.Errorf("stock_quantity cannot be negative")
+	}
+	return nil
+}
+
+func CreateImportJob(job entities.ImportJobStruct) (entities.ImportJobStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("import_jobs")
+
+	job.Id = ""
+	job.Status = "pending"
+	job.CreatedAt = time.Now().Format(time.RFC3339)
+
+	jobInserted, err := collection.InsertOne(ctx, job)
+	if err != nil {
+		return entities.ImportJobStruct{}, err
+	}
+
+	insertedID, ok := jobInserted.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return entities.ImportJobStruct{}, fmt.Errorf("failed to convert inserted ID to ObjectID")
+	}
+
+	job.Id = insertedID.Hex()
+	return job, nil
+}
+
+func GetImportJob(id string) (entities.ImportJobStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("import_jobs")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.ImportJobStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	var job entities.ImportJobStruct
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&job)
+	if err != nil {
+		return entities.ImportJobStruct{}, err
+	}
+	return job, nil
+}
+
+// RunProductImport processes an import job in the background. Every row is upserted by
+// reference: new references go through CreateProduct (and its catalog lookup), existing
+// ones get their prices and stock updated and are un-archived.
+// In dry-run mode rows are only validated and the action that would be taken is reported.
+func RunProductImport(job entities.ImportJobStruct, rows []entities.ProductImportRow, failures []entities.ImportRowResult) {
+	conn := db.GetDatabase()
+	ctx := context.Background()
+	collection := conn.Collection("import_jobs")
+
+	objID, err := primitive.ObjectIDFromHex(job.Id)
+	if err != nil {
+		log.Printf("Invalid import job ID %s: %v", job.Id, err)
+		return
+	}
+
+	results := append([]entities.ImportRowResult{}, failures...)
+	failed := len(failures)
+
+	// A row crashing the import fails the job with the rows done so far, rather than the server
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		log.Printf("Import job %s crashed: %v\n%s", job.Id, recovered, debug.Stack())
+
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+			"status":     "failed",
+			"error":      fmt.Sprintf("import crashed: %v", recovered),
+			"succeeded":  len(results) - failed,
+			"failed":     failed,
+			"rows":       results,
+			"finishedAt": time.Now().Format(time.RFC3339),
+		}})
+		if err != nil {
+			log.Printf("Failed to save import job %s failure: %v", job.Id, err)
+		}
+	}()
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"status": "running"}})
+	if err != nil {
+		log.Printf("Failed to start import job %s: %v", job.Id, err)
+		return
+	}
+
+	for _, row := range rows {
+		result := importProductRow(ctx, row, job.DryRun)
+		result.Line = row.Line
+		if result.Action == "failed" {
+			failed++
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Line < results[j].Line
+	})
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"status":     "completed",
+		"total":      len(results),
+		"succeeded":  len(results) - failed,
+		"failed":     failed,
+		"rows":       results,
+		"finishedAt": time.Now().Format(time.RFC3339),
+	}})
+	if err != nil {
+		log.Printf("Failed to save import job %s results: %v", job.Id, err)
+	}
+}
+
+func importProductRow(ctx context.Context, row entities.ProductImportRow, dryRun bool) entities.ImportRowResult {
+	result := entities.ImportRowResult{Reference: row.Reference}
+
+	if err := validateProductImportRow(row); err != nil {
+		result.Action = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	collection := db.GetDatabase().Collection("products")
+
+	var existing entities.ProductStruct
+	err := collection.FindOne(ctx, bson.M{"reference": row.Reference}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		result.Action = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	if err == mongo.ErrNoDocuments {
+		result.Action = "created"
+		if dryRun {
+			return result
+		}
+
+		_, err := CreateProduct(entities.ProductBasic{
+			Reference:     row.Reference,
+			PriceVat:      row.PriceVat,
+			StockQuantity: row.StockQuantity,
+			Name:          row.Name,
+			Brand:         row.Brand,
+			Category:      row.Category,
+		})
+		if err != nil {
+			result.Action = "failed"
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Action = "updated"
+	if existing.Archived {
+		result.Action = "unarchived"
+	}
+	if dryRun {
+		return result
+	}
+
+	priceNot, err := netPrice(existing, row.PriceVat)
+	if err != nil {
+		result.Action = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(existing.Id)
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"priceVat":      row.PriceVat,
+		"priceNot":      priceNot,
+		"stockQuantity": row.StockQuantity,
+		"archived":      false,
+	}})
+	if err != nil {
+		result.Action = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	if !existing.PriceVat.Equal(row.PriceVat) || !existing.PriceNot.Equal(priceNot) {
+		if err := recordPriceChange(ctx, existing.Id, row.PriceVat, priceNot, "import", ""); err != nil {
+			log.Printf("Failed to record price of product %s: %v", row.Reference, err)
+		}
+	}
+	return result
+}
+
+// ExportProducts returns the non-archived products in the import format
+func ExportProducts() ([]entities.ProductImportRow, error) {
+	products, err := GetProducts(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]entities.ProductImportRow, 0, len(products))
+	for _, product := range products {
+		rows = append(rows, entities.ProductImportRow{
+			Reference:     product.Reference,
+			PriceVat:      product.PriceVat,
+			PriceNot:      product.PriceNot,
+			StockQuantity: product.StockQuantity,
+			Name:          product.Name,
+			Brand:         product.Brand,
+			Category:      product.Category,
+		})
+	}
+
+	return rows, nil
+}
+
+// WriteProductExportCSV writes rows with the same columns accepted by the CSV import
+func WriteProductExportCSV(w io.Writer, rows []entities.ProductImportRow) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ProductImportColumns); err != nil {
+		return err
+	}
+
+	formatNumber := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	for _, row := range rows {
+		record := []string{
+			row.Reference,
+			row.PriceVat.Decimal(),
+			row.PriceNot.Decimal(),
+			formatNumber(row.StockQuantity),
+			row.Name,
+			row.Brand,
+			row.Category,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"trinity/backend/db"
+	"trinity/backend/db/dbtest"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearchAllergenFreeExcludesUnknownAllergens(t *testing.T) {
+	dbtest.Setup(t)
+
+	_, err := db.GetDatabase().Collection("products").InsertMany(context.Background(), []any{
+		bson.M{"reference": "1", "name": "Unknown", "allergens": nil},
+		bson.M{"reference": "2", "name": "Missing"},
+		bson.M{"reference": "3", "name": "None", "allergens": []string{}},
+		bson.M{"reference": "4", "name": "Bread", "allergens": []string{"gluten"}},
+		bson.M{"reference": "5", "name": "Cheese", "allergens": []string{"milk"}},
+		bson.M{"reference": "6", "name": "Pizza", "allergens": []string{"gluten", "milk"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		allergenFree []string
+		want         []string
+	}{
+		{[]string{"gluten"}, []string{"Cheese", "None"}},
+		{[]string{"gluten", "milk"}, []string{"None"}},
+		{[]string{"peanuts"}, []string{"Bread", "Cheese", "None", "Pizza"}},
+	}
+	for _, c := range cases {
+		products, err := GetProductBySearchProducts("", entities.ProductFilterStruct{AllergenFree: c.allergenFree})
+		if err != nil {
+			t.Fatalf("free of %v: %v", c.allergenFree, err)
+		}
+
+		var names []string
+		for _, product := range products {
+			names = append(names, product.Name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, c.want) {
+			t.Errorf("free of %v: got %v, want %v", c.allergenFree, names, c.want)
+		}
+	}
+}
+
+func TestProductSearchFilterWithoutFilters(t *testing.T) {
+	if filter := productSearchFilter(entities.ProductFilterStruct{}); len(filter) != 0 {
+		t.Errorf("got filter %v, want none", filter)
+	}
+}
@@ -0,0 +1,524 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"trinity/backend/pricing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// isPromotionRunning checks the date window of a promotion, an empty date leaves it open
+func isPromotionRunning(promotion entities.PromotStruct, now time.Time) bool {
+	if promotion.StartDate != "" {
+		start, err := time.Parse(time.RFC3339, promotion.StartDate)
+		if err != nil || now.Before(start) {
+			return false
+		}
+	}
+	if promotion.EndDate != "" {
+		end, err := time.Parse(time.RFC3339, promotion.EndDate)
+		if err != nil || now.After(end) {
+			return false
+		}
+	}
+	return true
+}
+
+// getCheckoutPromotions returns the automatic promotions running now along with those of
+// the codes entered, which must all be valid
+func getCheckoutPromotions(codes []string, now time.Time) ([]entities.PromotStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	filter := bson.M{
+		"status": "active",
+		"$or": []bson.M{
+			{"code": ""},
+			{"code": bson.M{"$exists": false}},
+			{"code": bson.M{"$in": codes}},
+		},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error finding promotions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var found []entities.PromotStruct
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("error retrieving promotions: %v", err)
+	}
+
+	byCode := map[string]entities.PromotStruct{}
+	var promotions []entities.PromotStruct
+	for _, promotion := range found {
+		if !isPromotionRunning(promotion, now) {
+			continue
+		}
+		if promotion.Code == "" {
+			promotions = append(promotions, promotion)
+		} else {
+			byCode[promotion.Code] = promotion
+		}
+	}
+
+	for _, code := range codes {
+		promotion, ok := byCode[code]
+		if !ok {
+			return nil, fmt.Errorf("invalid or expired promo code: %s", code)
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, nil
+}
+
+// normalizeCodes upper cases the promo codes entered and drops duplicates
+func normalizeCodes(codes []string) []string {
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+	return normalized
+}
+
+// toPricingPromotion describes a promotion to the pricing engine, fixed amounts being converted
+// from the base currency with rate
+func toPricingPromotion(promotion entities.PromotStruct, rate string) (pricing.Promotion, error) {
+	value := promotion.DiscountValue
+	switch promotion.DiscountType {
+	case pricing.DiscountFixed:
+		converted, err := pricing.Convert(value, rate)
+		if err != nil {
+			return pricing.Promotion{}, err
+		}
+		value = converted
+	case pricing.DiscountBogo:
+		// Second unit free unless the promotion says otherwise
+		if value == 0 {
+			value = 10000
+		}
+	}
+
+	productIds := make([]string, 0, len(promotion.Products))
+	for _, product := range promotion.Products {
+		productIds = append(productIds, product.Id)
+	}
+
+	return pricing.Promotion{
+		Id:          promotion.Id,
+		Type:        promotion.DiscountType,
+		Value:       value,
+		ProductIds:  productIds,
+		CategoryIds: promotion.CategoryIds,
+	}, nil
+}
+
+// applyPromotions evaluates the promotions against the cart. The customer eligibility and the
+// minimum purchase, against the subtotal in the base currency, are checked first, then the
+// discounts are computed both in the base currency and in the currency charged. Codes that
+// bring nothing are refused, automatic promotions that do not apply are dropped.
+func applyPromotions(promotions []entities.PromotStruct, customer customerProfile, baseLines []pricing.CartLine, lines []pricing.CartLine, rate string) ([]entities.PromotStruct, []pricing.AppliedPromotion, []pricing.AppliedPromotion, error) {
+	var baseSubtotal int64
+	for _, line := range baseLines {
+		baseSubtotal += line.UnitPrice * line.Quantity
+	}
+
+	var eligible []entities.PromotStruct
+	var basePromotions []pricing.Promotion
+	for _, promotion := range promotions {
+		if err := checkPromotionEligibility(promotion, customer); err != nil {
+			if promotion.Code != "" {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+		if baseSubtotal < promotion.MinPurchase.Amount {
+			if promotion.Code != "" {
+				return nil, nil, nil, fmt.Errorf("promo code %s requires a minimum purchase of %s", promotion.Code, promotion.MinPurchase.String())
+			}
+			continue
+		}
+		basePromotion, err := toPricingPromotion(promotion, "1")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		eligible = append(eligible, promotion)
+		basePromotions = append(basePromotions, basePromotion)
+	}
+
+	baseApplied := pricing.ApplyPromotions(baseLines, basePromotions)
+	appliedIds := map[string]bool{}
+	for _, result := range baseApplied {
+		if result.Total > 0 {
+			appliedIds[result.Id] = true
+		}
+	}
+
+	var applied []entities.PromotStruct
+	var chargedPromotions []pricing.Promotion
+	for _, promotion := range eligible {
+		if !appliedIds[promotion.Id] {
+			if promotion.Code != "" {
+				return nil, nil, nil, fmt.Errorf("promo code %s does not apply to your cart", promotion.Code)
+			}
+			continue
+		}
+		chargedPromotion, err := toPricingPromotion(promotion, rate)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		applied = append(applied, promotion)
+		chargedPromotions = append(chargedPromotions, chargedPromotion)
+	}
+
+	var keptBase []pricing.AppliedPromotion
+	for _, result := range baseApplied {
+		if appliedIds[result.Id] {
+			keptBase = append(keptBase, result)
+		}
+	}
+
+	return applied, keptBase, pricing.ApplyPromotions(lines, chargedPromotions), nil
+}
+
+var promotionCode = regexp.MustCompile(`^[A-Z0-9_-]+$`)
+
+// promotionStatusAt is the status a promotion should have at now according to its dates
+func promotionStatusAt(startDate string, endDate string, now time.Time) string {
+	now = now.UTC()
+	if endDate != "" && endDate < now.Format(time.RFC3339) {
+		return "expired"
+	}
+	if startDate != "" && startDate > now.Format(time.RFC3339) {
+		return "scheduled"
+	}
+	return "active"
+}
+
+// normalizeDate stores dates in UTC so that they compare as strings
+func normalizeDate(date string) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+	parsed, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", fmt.Errorf("invalid date %s, expected RFC3339", date)
+	}
+	return parsed.UTC().Format(time.RFC3339), nil
+}
+
+// buildPromotion checks that an admin input is consistent and resolves its targets
+func buildPromotion(input entities.PromotionInputStruct) (entities.PromotStruct, error) {
+	value := input.DiscountValue
+	switch input.DiscountType {
+	case pricing.DiscountPercentage:
+		if value <= 0 || value > 10000 {
+			return entities.PromotStruct{}, fmt.Errorf("percentage discounts must be between 0 and 100")
+		}
+	case pricing.DiscountFixed:
+		if value <= 0 {
+			return entities.PromotStruct{}, fmt.Errorf("fixed discounts must be greater than 0")
+		}
+	case pricing.DiscountBogo:
+		if value == 0 {
+			value = 10000
+		}
+		if value < 0 || value > 10000 {
+			return entities.PromotStruct{}, fmt.Errorf("bogo discounts must be between 0 and 100 percent of the second unit")
+		}
+	default:
+		return entities.PromotStruct{}, fmt.Errorf("invalid discount type: %s", input.DiscountType)
+	}
+	if input.UsageLimit < 0 || input.UsageLimitPerUser < 0 {
+		return entities.PromotStruct{}, fmt.Errorf("usage limits cannot be negative")
+	}
+	if input.UsageLimit > 0 && input.UsageLimitPerUser > input.UsageLimit {
+		return entities.PromotStruct{}, fmt.Errorf("the usage limit per user cannot exceed the overall usage limit")
+	}
+	if input.MinPurchase.Amount < 0 {
+		return entities.PromotStruct{}, fmt.Errorf("minimum purchase cannot be negative")
+	}
+	if err := checkBaseCurrency(input.MinPurchase); err != nil {
+		return entities.PromotStruct{}, err
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(input.Code))
+	if code != "" && !promotionCode.MatchString(code) {
+		return entities.PromotStruct{}, fmt.Errorf("promo codes may only contain letters, digits, - and _")
+	}
+
+	startDate, err := normalizeDate(input.StartDate)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+	endDate, err := normalizeDate(input.EndDate)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+	if startDate != "" && endDate != "" && endDate <= startDate {
+		return entities.PromotStruct{}, fmt.Errorf("end date must be after start date")
+	}
+
+	products := []entities.ProductOrder{}
+	for _, id := range input.ProductIds {
+		product, err := GetProductById(id)
+		if err != nil {
+			return entities.PromotStruct{}, fmt.Errorf("no product found with id: %s", id)
+		}
+		products = append(products, entities.ProductOrder{
+			Id:                     product.Id,
+			Reference:              product.Reference,
+			Images:                 product.Images,
+			PriceVat:               product.PriceVat,
+			PriceNot:               product.PriceNot,
+			Name:                   product.Name,
+			Brand:                  product.Brand,
+			Category:               product.Category,
+			CategoryIds:            product.CategoryIds,
+			NutritionalInformation: product.NutritionalInformation,
+		})
+	}
+	for _, id := range input.CategoryIds {
+		if _, err := GetCategoryById(id); err != nil {
+			return entities.PromotStruct{}, fmt.Errorf("no category found with id: %s", id)
+		}
+	}
+
+	return entities.PromotStruct{
+		Name:              input.Name,
+		Description:       input.Description,
+		DiscountType:      input.DiscountType,
+		DiscountValue:     value,
+		Code:              code,
+		StartDate:         startDate,
+		EndDate:           endDate,
+		Products:          products,
+		CategoryIds:       input.CategoryIds,
+		MinPurchase:       entities.NewMoney(input.MinPurchase.Amount, entities.DefaultCurrency),
+		UsageLimit:        input.UsageLimit,
+		UsageLimitPerUser: input.UsageLimitPerUser,
+		FirstOrderOnly:    input.FirstOrderOnly,
+		Segments:          normalizeSegments(input.Segments),
+		MinLoyaltyPoints:  input.MinLoyaltyPoints,
+	}, nil
+}
+
+// GetPromotions lists every promotion, or those with a given status
+func GetPromotions(status string) ([]entities.PromotStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"start_date": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	promotions := []entities.PromotStruct{}
+	if err := cursor.All(ctx, &promotions); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+func GetPromotionById(id string) (entities.PromotStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	var promotion entities.PromotStruct
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&promotion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.PromotStruct{}, fmt.Errorf("no promotion found with id: %s", id)
+		}
+		return entities.PromotStruct{}, err
+	}
+	return promotion, nil
+}
+
+func CreatePromotion(input entities.PromotionInputStruct) (entities.PromotStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	promotion, err := buildPromotion(input)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+
+	now := time.Now()
+	promotion.Id = primitive.NewObjectID().Hex()
+	promotion.Status = promotionStatusAt(promotion.StartDate, promotion.EndDate, now)
+	promotion.CreatedAt = now.Format(time.RFC3339)
+	promotion.UpdatedAt = now.Format(time.RFC3339)
+
+	if _, err := collection.InsertOne(ctx, promotion); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return entities.PromotStruct{}, fmt.Errorf("promotion with code %s already exists", promotion.Code)
+		}
+		return entities.PromotStruct{}, err
+	}
+	return promotion, nil
+}
+
+// UpdatePromotion edits a promotion, a paused promotion stays paused until it expires
+func UpdatePromotion(id string, input entities.PromotionInputStruct) (entities.PromotStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	current, err := GetPromotionById(id)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+
+	promotion, err := buildPromotion(input)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+
+	now := time.Now()
+	promotion.Id = id
+	promotion.Status = promotionStatusAt(promotion.StartDate, promotion.EndDate, now)
+	if current.Status == "inactive" && promotion.Status != "expired" {
+		promotion.Status = "inactive"
+	}
+	promotion.RedemptionCount = current.RedemptionCount
+	promotion.UserRedemptions = current.UserRedemptions
+	promotion.CreatedAt = current.CreatedAt
+	promotion.UpdatedAt = now.Format(time.RFC3339)
+
+	// The counts are copied over, so the promotion is only replaced if no order used it meanwhile
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": id, "redemptionCount": current.RedemptionCount}, promotion)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return entities.PromotStruct{}, fmt.Errorf("promotion with code %s already exists", promotion.Code)
+		}
+		return entities.PromotStruct{}, err
+	}
+	if result.MatchedCount == 0 {
+		return entities.PromotStruct{}, fmt.Errorf("promotion %s was used meanwhile, try again", id)
+	}
+	return promotion, nil
+}
+
+// PausePromotion stops a running or scheduled promotion until it is resumed
+func PausePromotion(id string) (entities.PromotStruct, error) {
+	promotion, err := GetPromotionById(id)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+	if promotion.Status == "expired" {
+		return entities.PromotStruct{}, fmt.Errorf("promotion %s has expired", id)
+	}
+	return setPromotionStatus(promotion, "inactive", "")
+}
+
+// ResumePromotion restarts a paused promotion, or schedules it if it has not started yet
+func ResumePromotion(id string) (entities.PromotStruct, error) {
+	promotion, err := GetPromotionById(id)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+	if promotion.Status != "inactive" {
+		return entities.PromotStruct{}, fmt.Errorf("promotion %s is not paused", id)
+	}
+	return setPromotionStatus(promotion, promotionStatusAt(promotion.StartDate, promotion.EndDate, time.Now()), "")
+}
+
+// ExpirePromotion ends a promotion now
+func ExpirePromotion(id string) (entities.PromotStruct, error) {
+	promotion, err := GetPromotionById(id)
+	if err != nil {
+		return entities.PromotStruct{}, err
+	}
+	if promotion.Status == "expired" {
+		return entities.PromotStruct{}, fmt.Errorf("promotion %s has already expired", id)
+	}
+	return setPromotionStatus(promotion, "expired", time.Now().UTC().Format(time.RFC3339))
+}
+
+func setPromotionStatus(promotion entities.PromotStruct, status string, endDate string) (entities.PromotStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	set := bson.M{"status": status, "updated_at": time.Now().Format(time.RFC3339)}
+	if endDate != "" && (promotion.EndDate == "" || endDate < promotion.EndDate) {
+		set["end_date"] = endDate
+		promotion.EndDate = endDate
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": promotion.Id}, bson.M{"$set": set}); err != nil {
+		return entities.PromotStruct{}, err
+	}
+	promotion.Status = status
+	promotion.UpdatedAt = set["updated_at"].(string)
+	return promotion, nil
+}
+
+func DeletePromotion(id string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("no promotion found with id: %s", id)
+	}
+	return nil
+}
+
+// UpdatePromotionStatuses starts the scheduled promotions whose start date has come and
+// expires those whose end date has passed, paused ones included
+func UpdatePromotionStatuses() error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotions")
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"status": bson.M{"$ne": "expired"}, "end_date": bson.M{"$ne": "", "$lt": now}},
+		bson.M{"$set": bson.M{"status": "expired", "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to expire promotions: %v", err)
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"status": "scheduled", "start_date": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": "active", "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start promotions: %v", err)
+	}
+	return nil
+}
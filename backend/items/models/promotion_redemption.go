@@ -0,0 +1,366 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// customerProfile is what the eligibility to a promotion depends on
+type customerProfile struct {
+	userId        string
+	segments      []string
+	hasPaidOrder  bool
+	loyaltyPoints int64
+	// Promotion uses held by the pending order being replaced, which are given back once the
+	// new order is created
+	replacedRedemptions map[string]int
+}
+
+// unpaidOrderStatuses are those of orders not paid, or no longer paid
+var unpaidOrderStatuses = []string{"", "pending", "cancelled", "expired", "refunded"}
+
+func isOrderPaid(status string) bool {
+	for _, unpaid := range unpaidOrderStatuses {
+		if status == unpaid {
+			return false
+		}
+	}
+	return true
+}
+
+// getCustomerProfile reads what a user is eligible to. The loyalty points and promotion uses
+// held by replaceOrder, if it is still pending, are counted as available.
+func getCustomerProfile(userId string, replaceOrder string) (customerProfile, error) {
+	user, err := getUserById(userId)
+	if err != nil {
+		return customerProfile{}, fmt.Errorf("no user found with id: %s", userId)
+	}
+
+	profile := customerProfile{userId: userId, segments: user.Segments, loyaltyPoints: user.LoyaltyPoints, replacedRedemptions: map[string]int{}}
+	for _, invoice := range user.Invoices {
+		if isOrderPaid(invoice.Order.Status) {
+			profile.hasPaidOrder = true
+		}
+		if replaceOrder != "" && invoice.Id == replaceOrder && invoice.Order.Status == "pending" {
+			profile.loyaltyPoints += invoice.LoyaltyPointsRedeemed
+			for _, discount := range invoice.Discounts {
+				profile.replacedRedemptions[discount.PromotionId]++
+			}
+		}
+	}
+	return profile, nil
+}
+
+// checkPromotionEligibility enforces the usage limits, first order and segment restrictions
+func checkPromotionEligibility(promotion entities.PromotStruct, customer customerProfile) error {
+	name := promotion.Code
+	if name == "" {
+		name = promotion.Name
+	}
+
+	replaced := customer.replacedRedemptions[promotion.Id]
+	if promotion.UsageLimit > 0 && promotion.RedemptionCount-replaced >= promotion.UsageLimit {
+		return fmt.Errorf("promo code %s has reached its usage limit", name)
+	}
+	if promotion.FirstOrderOnly && customer.hasPaidOrder {
+		return fmt.Errorf("promo code %s is only valid on a first order", name)
+	}
+	if len(promotion.Segments) > 0 {
+		inSegment := false
+		for _, segment := range promotion.Segments {
+			for _, userSegment := range customer.segments {
+				if segment == userSegment {
+					inSegment = true
+				}
+			}
+		}
+		if !inSegment {
+			return fmt.Errorf("promo code %s is not available for your account", name)
+		}
+	}
+	if promotion.MinLoyaltyPoints > 0 && customer.loyaltyPoints < promotion.MinLoyaltyPoints {
+		return fmt.Errorf("promo code %s requires %d loyalty points", name, promotion.MinLoyaltyPoints)
+	}
+	if promotion.UsageLimitPerUser > 0 && promotion.UserRedemptions[customer.userId]-replaced >= promotion.UsageLimitPerUser {
+		return fmt.Errorf("promo code %s has already been used the maximum number of times", name)
+	}
+	return nil
+}
+
+// ReservePromotionRedemptions counts the promotions of an order as soon as it is created, so
+// that concurrent orders cannot go over a usage limit. The limits are checked by the update of
+// the promotion itself, an order that would go over one of them fails. The redemptions are
+// released if the order is cancelled or expires before being paid.
+func ReservePromotionRedemptions(userId string, invoice entities.InvoiceStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	redemptions := conn.Collection("promotion_redemptions")
+	promotions := conn.Collection("promotions")
+
+	userField := "userRedemptions." + userId
+	for _, discount := range invoice.Discounts {
+		redemption := entities.PromotionRedemptionStruct{
+			Status:         "reserved",
+			PromotionId:    discount.PromotionId,
+			Code:           discount.Code,
+			UserId:         userId,
+			InvoiceId:      invoice.Id,
+			Discount:       discount.Amount,
+			BaseDiscount:   discount.BaseAmount,
+			BaseOrderTotal: invoice.BaseTotalPrice,
+			RedeemedAt:     time.Now().Format(time.RFC3339),
+		}
+
+		inserted, err := redemptions.InsertOne(ctx, redemption)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return fmt.Errorf("failed to reserve promotion %s: %v", discount.PromotionId, err)
+		}
+
+		result, err := promotions.UpdateOne(ctx,
+			bson.M{"_id": discount.PromotionId, "$expr": bson.M{"$and": bson.A{
+				bson.M{"$or": bson.A{
+					bson.M{"$lte": bson.A{"$usageLimit", 0}},
+					bson.M{"$lt": bson.A{"$redemptionCount", "$usageLimit"}},
+				}},
+				bson.M{"$or": bson.A{
+					bson.M{"$lte": bson.A{"$usageLimitPerUser", 0}},
+					bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$" + userField, 0}}, "$usageLimitPerUser"}},
+				}},
+			}}},
+			bson.M{"$inc": bson.M{"redemptionCount": 1, userField: 1}},
+		)
+		if err == nil && result.MatchedCount == 0 {
+			name := discount.Code
+			if name == "" {
+				name = discount.Name
+			}
+			err = fmt.Errorf("promo code %s has reached its usage limit", name)
+		}
+		if err != nil {
+			if _, undoErr := redemptions.DeleteOne(ctx, bson.M{"_id": inserted.InsertedID}); undoErr != nil {
+				return fmt.Errorf("failed to drop the reservation of promotion %s: %v", discount.PromotionId, undoErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePromotionRedemptions gives back the redemptions reserved by an order that was not paid
+func releasePromotionRedemptions(userId string, invoice entities.InvoiceStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	redemptions := conn.Collection("promotion_redemptions")
+	promotions := conn.Collection("promotions")
+
+	for _, discount := range invoice.Discounts {
+		// Only the reservations still there are counted, so that releasing twice is harmless
+		result, err := redemptions.DeleteOne(ctx, bson.M{"promotionId": discount.PromotionId, "invoiceId": invoice.Id, "status": "reserved"})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+
+		_, err = promotions.UpdateOne(ctx,
+			bson.M{"_id": discount.PromotionId},
+			bson.M{"$inc": bson.M{"redemptionCount": -1, "userRedemptions." + userId: -1}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to release promotion %s: %v", discount.PromotionId, err)
+		}
+	}
+	return nil
+}
+
+// refundPromotionRedemptions takes the promotions of a cancelled or refunded order out of the
+// stats. The usage limits still count them, as the customer did use the promotion.
+func refundPromotionRedemptions(invoice entities.InvoiceStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	redemptions := conn.Collection("promotion_redemptions")
+
+	_, err := redemptions.UpdateMany(ctx,
+		bson.M{"invoiceId": invoice.Id, "status": bson.M{"$ne": "reserved"}},
+		bson.M{"$set": bson.M{"status": "refunded"}},
+	)
+	return err
+}
+
+// RecordPromotionRedemptions marks the promotions reserved by a paid invoice as redeemed. A
+// redemption is unique per promotion and invoice, so recording the same invoice twice counts
+// it once. Orders created before redemptions were reserved are counted now.
+func RecordPromotionRedemptions(userId string, invoice entities.InvoiceStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	redemptions := conn.Collection("promotion_redemptions")
+	promotions := conn.Collection("promotions")
+
+	for _, discount := range invoice.Discounts {
+		now := time.Now().Format(time.RFC3339)
+		result, err := redemptions.UpdateOne(ctx,
+			bson.M{"promotionId": discount.PromotionId, "invoiceId": invoice.Id},
+			bson.M{"$set": bson.M{"status": "redeemed", "redeemedAt": now}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record redemption of promotion %s: %v", discount.PromotionId, err)
+		}
+		if result.MatchedCount > 0 {
+			continue
+		}
+
+		redemption := entities.PromotionRedemptionStruct{
+			Status:         "redeemed",
+			PromotionId:    discount.PromotionId,
+			Code:           discount.Code,
+			UserId:         userId,
+			InvoiceId:      invoice.Id,
+			Discount:       discount.Amount,
+			BaseDiscount:   discount.BaseAmount,
+			BaseOrderTotal: invoice.BaseTotalPrice,
+			RedeemedAt:     now,
+		}
+		if _, err := redemptions.InsertOne(ctx, redemption); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return fmt.Errorf("failed to record redemption of promotion %s: %v", discount.PromotionId, err)
+		}
+
+		_, err = promotions.UpdateOne(ctx,
+			bson.M{"_id": discount.PromotionId},
+			bson.M{"$inc": bson.M{"redemptionCount": 1, "userRedemptions." + userId: 1}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to count redemption of promotion %s: %v", discount.PromotionId, err)
+		}
+	}
+	return nil
+}
+
+// InitializeUserRedemptions counts the redemptions per user of the promotions redeemed before
+// they were kept on the promotion
+func InitializeUserRedemptions() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	promotions := conn.Collection("promotions")
+
+	cursor, err := conn.Collection("promotion_redemptions").Aggregate(ctx, []bson.M{
+		{"$group": bson.M{
+			"_id":   bson.M{"promotionId": "$promotionId", "userId": "$userId"},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$group": bson.M{
+			"_id":   "$_id.promotionId",
+			"users": bson.M{"$push": bson.M{"userId": "$_id.userId", "count": "$count"}},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []struct {
+		PromotionId string `bson:"_id"`
+		Users       []struct {
+			UserId string `bson:"userId"`
+			Count  int    `bson:"count"`
+		} `bson:"users"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, promotion := range counts {
+		users := make(map[string]int, len(promotion.Users))
+		for _, user := range promotion.Users {
+			users[user.UserId] = user.Count
+		}
+
+		result, err := promotions.UpdateOne(ctx,
+			bson.M{"_id": promotion.PromotionId, "userRedemptions": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"userRedemptions": users}},
+		)
+		if err != nil {
+			return updated, err
+		}
+		updated += int(result.ModifiedCount)
+	}
+	return updated, nil
+}
+
+// GetPromotionsStats sums the redemptions of every promotion, or of a single one
+func GetPromotionsStats(promotionId string) ([]entities.PromotionStatsStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("promotion_redemptions")
+
+	// Redemptions of orders not paid yet, or refunded since, are left out
+	match := bson.M{"status": bson.M{"$nin": []string{"reserved", "refunded"}}}
+	if promotionId != "" {
+		match["promotionId"] = promotionId
+	}
+	pipeline := []bson.M{{"$match": match}}
+	pipeline = append(pipeline,
+		bson.M{"$group": bson.M{
+			"_id":           "$promotionId",
+			"redemptions":   bson.M{"$sum": 1},
+			"customers":     bson.M{"$addToSet": "$userId"},
+			"totalDiscount": bson.M{"$sum": "$baseDiscount.amount"},
+			"revenue":       bson.M{"$sum": "$baseOrderTotal.amount"},
+		}},
+		bson.M{"$lookup": bson.M{
+			"from":         "promotions",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "promotion",
+		}},
+		bson.M{"$project": bson.M{
+			"name":          bson.M{"$first": "$promotion.name"},
+			"code":          bson.M{"$first": "$promotion.code"},
+			"redemptions":   1,
+			"customers":     bson.M{"$size": "$customers"},
+			"totalDiscount": 1,
+			"revenue":       1,
+		}},
+		bson.M{"$sort": bson.M{"redemptions": -1}},
+	)
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error computing promotion stats: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("error retrieving promotion stats: %v", err)
+	}
+
+	stats := make([]entities.PromotionStatsStruct, 0, len(results))
+	for _, result := range results {
+		name, _ := result["name"].(string)
+		code, _ := result["code"].(string)
+		stats = append(stats, entities.PromotionStatsStruct{
+			PromotionId:   fmt.Sprint(result["_id"]),
+			Name:          name,
+			Code:          code,
+			Redemptions:   int(toInt64(result["redemptions"])),
+			Customers:     int(toInt64(result["customers"])),
+			TotalDiscount: entities.NewMoney(toInt64(result["totalDiscount"]), entities.DefaultCurrency),
+			Revenue:       entities.NewMoney(toInt64(result["revenue"]), entities.DefaultCurrency),
+		})
+	}
+	return stats, nil
+}
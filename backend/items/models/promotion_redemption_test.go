@@ -0,0 +1,20 @@
+package models
+
+import "testing"
+
+func TestIsOrderPaid(t *testing.T) {
+	cases := map[string]bool{
+		"":          false,
+		"pending":   false,
+		"paid":      true,
+		"ready":     true,
+		"cancelled": false,
+		"expired":   false,
+		"refunded":  false,
+	}
+	for status, want := range cases {
+		if got := isOrderPaid(status); got != want {
+			t.Errorf("isOrderPaid(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	relatedProductsKept     = 10
+	userRecommendationsKept = 20
+	userTopCategoriesKept   = 3
+	// Purchases count half as much every affinityHalfLife
+	affinityHalfLife = 90 * 24 * time.Hour
+	// popularRecommendationsId holds the recommendations of users without order history
+	popularRecommendationsId = "popular"
+)
+
+// RecommendationsInterval is how often recommendations are computed, from
+// RECOMMENDATIONS_INTERVAL (e.g. "1h", the default)
+func RecommendationsInterval() time.Duration {
+	interval := time.Hour
+	if value := os.Getenv("RECOMMENDATIONS_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("Invalid RECOMMENDATIONS_INTERVAL %q, using %v: %v", value, interval, err)
+		} else {
+			interval = parsed
+		}
+	}
+	return interval
+}
+
+// orderBasket is the set of products of a paid order
+type orderBasket struct {
+	userId     string
+	date       time.Time
+	quantities map[string]int64
+}
+
+func getPaidBaskets(ctx context.Context, products map[string]entities.ProductStruct) ([]orderBasket, error) {
+	collection := db.GetDatabase().Collection("users")
+
+	pipeline := []bson.M{
+		{"$unwind": "$invoices"},
+		{"$match": bson.M{"invoices.order.status": bson.M{"$exists": true, "$nin": unpaidOrderStatuses}}},
+		{"$project": bson.M{
+			"date":     "$invoices.date",
+			"products": "$invoices.order.products",
+		}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error reading order history: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var baskets []orderBasket
+	for cursor.Next(ctx) {
+		var order struct {
+			UserId   primitive.ObjectID `bson:"_id"`
+			Date     string             `bson:"date"`
+			Products []struct {
+				ProductId string `bson:"productId"`
+				Quantity  int64  `bson:"quantity"`
+			} `bson:"products"`
+		}
+		if err := cursor.Decode(&order); err != nil {
+			return nil, fmt.Errorf("error decoding order history: %v", err)
+		}
+
+		date, err := time.Parse(time.RFC3339, order.Date)
+		if err != nil {
+			date = time.Now()
+		}
+
+		basket := orderBasket{userId: order.UserId.Hex(), date: date, quantities: map[string]int64{}}
+		for _, line := range order.Products {
+			// Products removed from the catalog are not recommended anymore
+			if _, ok := products[line.ProductId]; ok && line.Quantity > 0 {
+				basket.quantities[line.ProductId] += line.Quantity
+			}
+		}
+		if len(basket.quantities) > 0 {
+			baskets = append(baskets, basket)
+		}
+	}
+	return baskets, cursor.Err()
+}
+
+func getCatalogProducts(ctx context.Context) (map[string]entities.ProductStruct, error) {
+	collection := db.GetDatabase().Collection("products")
+
+	cursor, err := collection.Find(ctx, bson.M{"archived": false}, options.Find().SetProjection(bson.M{"_id": 1, "categoryIds": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var list []entities.ProductStruct
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	products := make(map[string]entities.ProductStruct, len(list))
+	for _, product := range list {
+		products[product.Id] = product
+	}
+	return products, nil
+}
+
+// topScored sorts scores from the highest and keeps the first ones, ties are broken by id so
+// that runs on the same history give the same lists
+func topScored(scores map[string]float64, orders map[string]int, limit int) []entities.ScoredProductStruct {
+	scored := make([]entities.ScoredProductStruct, 0, len(scores))
+	for productId, score := range scores {
+		scored = append(scored, entities.ScoredProductStruct{ProductId: productId, Score: score, Orders: orders[productId]})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score > scored[j].Score
+		}
+		return scored[i].ProductId < scored[j].ProductId
+	})
+	if len(scored) > limit {
+		scored = scored[:limit]
+	}
+	return scored
+}
+
+// ComputeRecommendations rebuilds the recommendations from the paid orders:
+//   - related products, "customers who bought X also bought Y", score pairs of products by the
+//     orders containing both, normalized by how often each one is ordered (cosine similarity)
+//   - every customer gets the products they have not bought yet that are the most related to
+//     what they bought, recent purchases weighing more, along with their top categories
+func ComputeRecommendations() error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	now := time.Now().UTC()
+	updatedAt := now.Format(time.RFC3339)
+
+	products, err := getCatalogProducts(ctx)
+	if err != nil {
+		return err
+	}
+	baskets, err := getPaidBaskets(ctx, products)
+	if err != nil {
+		return err
+	}
+
+	productOrders := map[string]int{}
+	pairOrders := map[string]map[string]int{}
+	affinity := map[string]map[string]float64{}
+	for _, basket := range baskets {
+		weight := math.Pow(0.5, float64(now.Sub(basket.date))/float64(affinityHalfLife))
+		if affinity[basket.userId] == nil {
+			affinity[basket.userId] = map[string]float64{}
+		}
+
+		for productId, quantity := range basket.quantities {
+			productOrders[productId]++
+			affinity[basket.userId][productId] += float64(quantity) * weight
+
+			for otherId := range basket.quantities {
+				if otherId == productId {
+					continue
+				}
+				if pairOrders[productId] == nil {
+					pairOrders[productId] = map[string]int{}
+				}
+				pairOrders[productId][otherId]++
+			}
+		}
+	}
+
+	similarity := map[string]map[string]float64{}
+	var relatedModels []mongo.WriteModel
+	for productId, others := range pairOrders {
+		similarity[productId] = map[string]float64{}
+		for otherId, orders := range others {
+			similarity[productId][otherId] = float64(orders) / math.Sqrt(float64(productOrders[productId]*productOrders[otherId]))
+		}
+
+		related := entities.RelatedProductsStruct{
+			ProductId: productId,
+			Related:   topScored(similarity[productId], others, relatedProductsKept),
+			UpdatedAt: updatedAt,
+		}
+		relatedModels = append(relatedModels, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": productId}).SetReplacement(related).SetUpsert(true))
+	}
+
+	var userModels []mongo.WriteModel
+	for userId, bought := range affinity {
+		scores := map[string]float64{}
+		categoryScores := map[string]float64{}
+		for productId, weight := range bought {
+			for otherId, sim := range similarity[productId] {
+				if _, already := bought[otherId]; !already {
+					scores[otherId] += weight * sim
+				}
+			}
+			for _, categoryId := range products[productId].CategoryIds {
+				categoryScores[categoryId] += weight
+			}
+		}
+
+		topCategories := []string{}
+		for _, category := range topScored(categoryScores, nil, userTopCategoriesKept) {
+			topCategories = append(topCategories, category.ProductId)
+		}
+
+		recommendations := entities.UserRecommendationsStruct{
+			UserId:        userId,
+			Products:      topScored(scores, nil, userRecommendationsKept),
+			TopCategories: topCategories,
+			UpdatedAt:     updatedAt,
+		}
+		userModels = append(userModels, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": userId}).SetReplacement(recommendations).SetUpsert(true))
+	}
+
+	// Users without history get the best sellers
+	popularity := map[string]float64{}
+	for productId, orders := range productOrders {
+		popularity[productId] = float64(orders)
+	}
+	userModels = append(userModels, mongo.NewReplaceOneModel().
+		SetFilter(bson.M{"_id": popularRecommendationsId}).
+		SetReplacement(entities.UserRecommendationsStruct{
+			UserId:        popularRecommendationsId,
+			Products:      topScored(popularity, productOrders, userRecommendationsKept),
+			TopCategories: []string{},
+			UpdatedAt:     updatedAt,
+		}).SetUpsert(true))
+
+	for collectionName, writeModels := range map[string][]mongo.WriteModel{
+		"product_related":      relatedModels,
+		"user_recommendations": userModels,
+	} {
+		collection := conn.Collection(collectionName)
+		if len(writeModels) > 0 {
+			if _, err := collection.BulkWrite(ctx, writeModels, options.BulkWrite().SetOrdered(false)); err != nil {
+				return fmt.Errorf("failed to save %s: %v", collectionName, err)
+			}
+		}
+		// Recommendations not computed by this run are out of date
+		if _, err := collection.DeleteMany(ctx, bson.M{"updatedAt": bson.M{"$lt": updatedAt}}); err != nil {
+			return fmt.Errorf("failed to clean %s: %v", collectionName, err)
+		}
+	}
+	return nil
+}
+
+// getScoredProducts loads the products of a recommendation list, in its order, leaving out
+// those removed from the catalog since it was computed
+func getScoredProducts(ctx context.Context, scored []entities.ScoredProductStruct, exclude map[string]bool, limit int) ([]entities.ProductStruct, error) {
+	collection := db.GetDatabase().Collection("products")
+
+	var objIDs []primitive.ObjectID
+	for _, product := range scored {
+		if objID, err := primitive.ObjectIDFromHex(product.ProductId); err == nil && !exclude[product.ProductId] {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) == 0 {
+		return []entities.ProductStruct{}, nil
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": objIDs}, "archived": false})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []entities.ProductStruct
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	byId := make(map[string]entities.ProductStruct, len(found))
+	for _, product := range found {
+		byId[product.Id] = product
+	}
+
+	products := []entities.ProductStruct{}
+	for _, product := range scored {
+		if found, ok := byId[product.ProductId]; ok && !exclude[product.ProductId] && len(products) < limit {
+			products = append(products, found)
+			exclude[product.ProductId] = true
+		}
+	}
+	return products, nil
+}
+
+// GetRelatedProducts returns the products most often bought along with a product
+func GetRelatedProducts(productId string, limit int) ([]entities.ProductStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("product_related")
+
+	var related entities.RelatedProductsStruct
+	err := collection.FindOne(ctx, bson.M{"_id": productId}).Decode(&related)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return []entities.ProductStruct{}, nil
+		}
+		return nil, err
+	}
+
+	return getScoredProducts(ctx, related.Related, map[string]bool{productId: true}, limit)
+}
+
+func getUserRecommendations(ctx context.Context, id string) (entities.UserRecommendationsStruct, error) {
+	collection := db.GetDatabase().Collection("user_recommendations")
+
+	var recommendations entities.UserRecommendationsStruct
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&recommendations)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return entities.UserRecommendationsStruct{}, err
+	}
+	return recommendations, nil
+}
+
+// GetUserRecommendedProducts returns the products recommended to a user, completed with the
+// best sellers when their history does not give enough of them
+func GetUserRecommendedProducts(userId string, limit int) ([]entities.ProductStruct, error) {
+	ctx := context.TODO()
+
+	recommendations, err := getUserRecommendations(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	exclude := map[string]bool{}
+	products, err := getScoredProducts(ctx, recommendations.Products, exclude, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) >= limit {
+		return products, nil
+	}
+
+	popular, err := getUserRecommendations(ctx, popularRecommendationsId)
+	if err != nil {
+		return nil, err
+	}
+	more, err := getScoredProducts(ctx, popular.Products, exclude, limit-len(products))
+	if err != nil {
+		return nil, err
+	}
+	return append(products, more...), nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func CreateRole(r entities.RoleStruct) (entities.RoleStruct, error) {
@@ -59,3 +60,30 @@ func GetRoleById(id string) (entities.RoleStruct, error) {
 	}
 	return role, nil
 }
+
+// GrantRolePermissions adds permissions to a role and to the users holding it, who keep a
+// copy of their roles' permissions. Permissions already granted are left as they are.
+func GrantRolePermissions(roleName string, permissions ...entities.PermissionStruct) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+
+	_, err := conn.Collection("roles").UpdateOne(ctx,
+		bson.M{"name": roleName},
+		bson.M{"$addToSet": bson.M{"permissions": bson.M{"$each": permissions}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update role %s: %v", roleName, err)
+	}
+
+	_, err = conn.Collection("users").UpdateMany(ctx,
+		bson.M{"roles.name": roleName},
+		bson.M{"$addToSet": bson.M{"roles.$[role].permissions": bson.M{"$each": permissions}}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []any{bson.M{"role.name": roleName}},
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update users with role %s: %v", roleName, err)
+	}
+	return nil
+}
@@ -266,41 +266,39 @@ func UpdatePassword(userId string, password string) error {
 	return nil
 }
 
-func UpdateDeviceToken(userId string, token string) error {
+// SetUserSegments replaces the segments of a user, used to target promotions
+func SetUserSegments(userId string, segments []string) ([]string, error) {
 	conn := db.GetDatabase()
 	ctx := context.TODO()
 	collection := conn.Collection("users")
-	// Convert string ID to ObjectID
+
 	objID, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
-		return fmt.Errorf("invalid ID format")
-	}
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"deviceToken": token}})
-	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid ID format")
 	}
-	return nil
-}
-
-func GetDeviceTokens() ([]string, error) {
-	conn := db.GetDatabase()
-	ctx := context.TODO()
-	collection := conn.Collection("users")
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	normalized := normalizeSegments(segments)
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"segments": normalized}})
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("no user found with id: %s", userId)
+	}
+	return normalized, nil
+}
 
-	var tokens []string
-	for cursor.Next(ctx) {
-		var user entities.UserStruct
-		if err := cursor.Decode(&user); err != nil {
-			return nil, err
+// normalizeSegments lower cases segment names and drops duplicates
+func normalizeSegments(segments []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, segment := range segments {
+		segment = strings.ToLower(strings.TrimSpace(segment))
+		if segment == "" || seen[segment] {
+			continue
 		}
-		tokens = append(tokens, user.DeviceToken)
+		seen[segment] = true
+		normalized = append(normalized, segment)
 	}
-
-	return tokens, nil
+	return normalized
 }
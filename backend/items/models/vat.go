@@ -0,0 +1,397 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"trinity/backend/db"
+	"trinity/backend/items/entities"
+	"trinity/backend/pricing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetVatRates() ([]entities.VatRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("vat_rates")
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"rate": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rates := []entities.VatRateStruct{}
+	if err := cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func GetVatRateById(id string) (entities.VatRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("vat_rates")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.VatRateStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	var rate entities.VatRateStruct
+	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&rate)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.VatRateStruct{}, fmt.Errorf("no VAT rate found with id: %s", id)
+		}
+		return entities.VatRateStruct{}, err
+	}
+	return rate, nil
+}
+
+func getDefaultVatRate(ctx context.Context) (entities.VatRateStruct, error) {
+	collection := db.GetDatabase().Collection("vat_rates")
+
+	var rate entities.VatRateStruct
+	err := collection.FindOne(ctx, bson.M{"default": true}).Decode(&rate)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return entities.VatRateStruct{}, fmt.Errorf("no default VAT rate configured")
+		}
+		return entities.VatRateStruct{}, err
+	}
+	return rate, nil
+}
+
+// clearDefaultVatRate makes sure only one rate is flagged as default
+func clearDefaultVatRate(ctx context.Context, exceptId primitive.ObjectID) error {
+	collection := db.GetDatabase().Collection("vat_rates")
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$ne": exceptId}, "default": true},
+		bson.M{"$set": bson.M{"default": false}},
+	)
+	return err
+}
+
+func CreateVatRate(rate entities.VatRateStruct) (entities.VatRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("vat_rates")
+
+	rate.Id = ""
+	inserted, err := collection.InsertOne(ctx, rate)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return entities.VatRateStruct{}, fmt.Errorf("VAT rate with code %s already exists", rate.Code)
+		}
+		return entities.VatRateStruct{}, err
+	}
+
+	insertedID, ok := inserted.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return entities.VatRateStruct{}, fmt.Errorf("failed to convert inserted ID to ObjectID")
+	}
+	rate.Id = insertedID.Hex()
+
+	if rate.Default {
+		if err := clearDefaultVatRate(ctx, insertedID); err != nil {
+			return entities.VatRateStruct{}, err
+		}
+		if _, err := SyncNetPrices(); err != nil {
+			return rate, fmt.Errorf("VAT rate created but failed to update net prices: %v", err)
+		}
+	}
+
+	return rate, nil
+}
+
+// UpdateVatRate changes a rate for future orders, past invoices keep the rate they were issued with
+func UpdateVatRate(id string, rate entities.VatRateStruct) (entities.VatRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("vat_rates")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return entities.VatRateStruct{}, fmt.Errorf("invalid ID format")
+	}
+
+	rate.Id = ""
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": rate})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return entities.VatRateStruct{}, fmt.Errorf("VAT rate with code %s already exists", rate.Code)
+		}
+		return entities.VatRateStruct{}, err
+	}
+	if result.MatchedCount == 0 {
+		return entities.VatRateStruct{}, fmt.Errorf("no VAT rate found with id: %s", id)
+	}
+
+	if rate.Default {
+		if err := clearDefaultVatRate(ctx, objID); err != nil {
+			return entities.VatRateStruct{}, err
+		}
+	}
+
+	rate.Id = id
+	if _, err := SyncNetPrices(); err != nil {
+		return rate, fmt.Errorf("VAT rate updated but failed to update net prices: %v", err)
+	}
+	return rate, nil
+}
+
+// assignVatRate sets (or removes, with an empty rate id) the VAT rate of a product or a category
+func assignVatRate(collectionName string, id string, vatRateId string) error {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection(collectionName)
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid ID format")
+	}
+
+	update := bson.M{"$unset": bson.M{"vatRateId": ""}}
+	if vatRateId != "" {
+		if _, err := GetVatRateById(vatRateId); err != nil {
+			return err
+		}
+		update = bson.M{"$set": bson.M{"vatRateId": vatRateId}}
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no document found with id: %s", id)
+	}
+
+	if _, err := SyncNetPrices(); err != nil {
+		return fmt.Errorf("VAT rate assigned but failed to update net prices: %v", err)
+	}
+	return nil
+}
+
+func SetProductVatRate(productId string, vatRateId string) error {
+	return assignVatRate("products", productId, vatRateId)
+}
+
+func SetCategoryVatRate(categoryId string, vatRateId string) error {
+	return assignVatRate("categories", categoryId, vatRateId)
+}
+
+// ResolveProductVatRate finds the VAT rate applying to a product: its own rate, else the
+// rate of its most specific category (or of that category's ancestors), else the default one
+func ResolveProductVatRate(product entities.ProductStruct) (entities.VatRateStruct, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+
+	if product.VatRateId != "" {
+		return GetVatRateById(product.VatRateId)
+	}
+
+	// Category ids are stored from the most general to the most specific, and so are ancestors
+	for i := len(product.CategoryIds) - 1; i >= 0; i-- {
+		category, err := GetCategoryById(product.CategoryIds[i])
+		if err != nil {
+			continue
+		}
+		if category.VatRateId != "" {
+			return GetVatRateById(category.VatRateId)
+		}
+
+		var ancestorIDs []primitive.ObjectID
+		for _, id := range category.Ancestors {
+			if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+				ancestorIDs = append(ancestorIDs, objID)
+			}
+		}
+		if len(ancestorIDs) == 0 {
+			continue
+		}
+
+		cursor, err := conn.Collection("categories").Find(ctx, bson.M{
+			"_id":       bson.M{"$in": ancestorIDs},
+			"vatRateId": bson.M{"$exists": true},
+		})
+		if err != nil {
+			return entities.VatRateStruct{}, err
+		}
+		var ancestors []entities.CategoryStruct
+		if err := cursor.All(ctx, &ancestors); err != nil {
+			return entities.VatRateStruct{}, err
+		}
+
+		rateByCategory := make(map[string]string, len(ancestors))
+		for _, ancestor := range ancestors {
+			rateByCategory[ancestor.Id] = ancestor.VatRateId
+		}
+		for j := len(category.Ancestors) - 1; j >= 0; j-- {
+			if vatRateId := rateByCategory[category.Ancestors[j]]; vatRateId != "" {
+				return GetVatRateById(vatRateId)
+			}
+		}
+	}
+
+	return getDefaultVatRate(ctx)
+}
+
+// netPrice derives the price without VAT of a product from its price with VAT, at the rate
+// resolved for the product. Prices are always set with VAT, the net price follows from them.
+func netPrice(product entities.ProductStruct, priceVat entities.Money) (entities.Money, error) {
+	rate, err := ResolveProductVatRate(product)
+	if err != nil {
+		return entities.Money{}, fmt.Errorf("failed to resolve the VAT rate of product %s: %v", product.Reference, err)
+	}
+	return entities.NewMoney(pricing.SplitGross(priceVat.Amount, rate.Rate).Net, priceVat.Currency), nil
+}
+
+// SyncNetPrices recomputes the price without VAT of the products whose VAT rate changed, e.g.
+// after a rate or its assignment to a category was edited. It returns how many were updated.
+func SyncNetPrices() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("products")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	var products []entities.ProductStruct
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, product := range products {
+		priceNot, err := netPrice(product, product.PriceVat)
+		if err != nil {
+			return updated, err
+		}
+		if priceNot.Equal(product.PriceNot) {
+			continue
+		}
+
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": mustObjectID(product.Id), "priceVat": product.PriceVat},
+			bson.M{"$set": bson.M{"priceNot": priceNot}},
+		)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// basisPointsExpr converts a VAT rate stored as a fraction, e.g. 0.2, to basis points
+func basisPointsExpr(field string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": field}, "double"}},
+		bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{field, 10000}}, 0}}},
+		field,
+	}}
+}
+
+// vatRatesExpr converts the VAT rate held by field in each element of an array
+func vatRatesExpr(array string, field string) bson.M {
+	return bson.M{"$map": bson.M{
+		"input": bson.M{"$ifNull": bson.A{array, bson.A{}}},
+		"as":    "element",
+		"in":    bson.M{"$mergeObjects": bson.A{"$$element", bson.M{field: basisPointsExpr("$$element." + field)}}},
+	}}
+}
+
+// MigrateVatRatesToBasisPoints converts the VAT rates invoices and credit notes stored as
+// fractions to basis points, like the VAT rates themselves
+func MigrateVatRatesToBasisPoints() (int64, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+
+	result, err := conn.Collection("users").UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"invoices.order.products.vatRate": bson.M{"$type": "double"}},
+			bson.M{"invoices.taxBreakdown.rate": bson.M{"$type": "double"}},
+		}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"invoices": bson.M{"$map": bson.M{
+			"input": "$invoices",
+			"as":    "invoice",
+			"in": bson.M{"$mergeObjects": bson.A{
+				"$$invoice",
+				bson.M{
+					"order":        bson.M{"$mergeObjects": bson.A{"$$invoice.order", bson.M{"products": vatRatesExpr("$$invoice.order.products", "vatRate")}}},
+					"taxBreakdown": vatRatesExpr("$$invoice.taxBreakdown", "rate"),
+				},
+			}},
+		}}}}}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate invoice VAT rates: %v", err)
+	}
+	migrated := result.ModifiedCount
+
+	result, err = conn.Collection("credit_notes").UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"lines.vatRate": bson.M{"$type": "double"}},
+			bson.M{"taxBreakdown.rate": bson.M{"$type": "double"}},
+		}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"lines":        vatRatesExpr("$lines", "vatRate"),
+			"taxBreakdown": vatRatesExpr("$taxBreakdown", "rate"),
+		}}}},
+	)
+	if err != nil {
+		return migrated, fmt.Errorf("failed to migrate credit note VAT rates: %v", err)
+	}
+	return migrated + result.ModifiedCount, nil
+}
+
+// InitializeVatRates seeds the French VAT rates when none are configured.
+// Most of the catalog is food, so the reduced rate is the default one.
+func InitializeVatRates() (int, error) {
+	conn := db.GetDatabase()
+	ctx := context.TODO()
+	collection := conn.Collection("vat_rates")
+
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, nil
+	}
+
+	rates := []any{
+		entities.VatRateStruct{Code: "standard", Name: "Taux normal", Rate: 2000},
+		entities.VatRateStruct{Code: "intermediate", Name: "Taux intermédiaire", Rate: 1000},
+		entities.VatRateStruct{Code: "reduced", Name: "Taux réduit", Rate: 550, Default: true},
+		entities.VatRateStruct{Code: "super_reduced", Name: "Taux particulier", Rate: 210},
+	}
+	result, err := collection.InsertMany(ctx, rates)
+	if err != nil {
+		return 0, err
+	}
+
+	// Alcoholic beverages are not eligible to the reduced rate. The category is created
+	// ahead of the products so that they get the standard rate when they are seeded.
+	var standard entities.VatRateStruct
+	if err := collection.FindOne(ctx, bson.M{"code": "standard"}).Decode(&standard); err == nil {
+		if _, err := upsertCategory(ctx, "Alcoholic beverages", nil); err != nil {
+			return len(result.InsertedIDs), err
+		}
+		_, err = conn.Collection("categories").UpdateMany(ctx,
+			bson.M{"slug": "alcoholic-beverages"},
+			bson.M{"$set": bson.M{"vatRateId": standard.Id}},
+		)
+		if err != nil {
+			return len(result.InsertedIDs), err
+		}
+	}
+
+	return len(result.InsertedIDs), nil
+}
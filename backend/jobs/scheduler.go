@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// Every runs task in the background once per interval, starting immediately.
+// Errors are logged and the task keeps being scheduled.
+func Every(interval time.Duration, name string, task func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			run(name, task)
+			<-ticker.C
+		}
+	}()
+	log.Printf("Background job '%s' scheduled every %v", name, interval)
+}
+
+func run(name string, task func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Background job '%s' panicked: %v", name, r)
+		}
+	}()
+
+	if err := task(); err != nil {
+		log.Printf("Background job '%s' failed: %v", name, err)
+	}
+}
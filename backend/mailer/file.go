@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileTransport writes every message as an .eml file under Dir, to read them in development
+// without a mail server
+type FileTransport struct {
+	Dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory %s: %v", dir, err)
+	}
+	return &FileTransport{Dir: dir}, nil
+}
+
+func (f *FileTransport) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := Encode(msg, now)
+	if err != nil {
+		return err
+	}
+
+	recipient := strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, msg.To)
+	path := filepath.Join(f.Dir, fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), recipient))
+
+	// Write to a temporary file first so readers never see a partial email
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write email: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message is an email ready to be sent, with a plain text and an HTML version of its body
+type Message struct {
+	From        string
+	To          string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Transport delivers messages
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Sender is the From address of the emails, from MAIL_FROM
+func Sender() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "Trinity <no-reply@trinity.example>"
+}
+
+// NewTransportFromEnv builds the transport selected by MAIL_TRANSPORT: "file" (by default)
+// writes the emails to MAIL_FILE_DIR, "smtp" sends them to SMTP_HOST
+func NewTransportFromEnv() (Transport, error) {
+	switch strings.ToLower(os.Getenv("MAIL_TRANSPORT")) {
+	case "", "file":
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = "mails"
+		}
+		return NewFileTransport(dir)
+	case "smtp":
+		return NewSMTPTransport(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", os.Getenv("MAIL_TRANSPORT"))
+	}
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Encode writes msg in the Internet Message Format: the text and HTML bodies as alternatives,
+// followed by the attachments
+func Encode(msg Message, date time.Time) ([]byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", msg.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %v", msg.To, err)
+	}
+
+	var buf bytes.Buffer
+	mixed := multipart.NewWriter(&buf)
+
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + date.Format(time.RFC1123Z),
+		"Message-ID: <" + randomId() + "@" + domain + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mixed.Boundary(),
+	}
+	header := strings.Join(headers, "\r\n") + "\r\n\r\n"
+
+	// The bodies are alternatives of each other, nested in the first part
+	var alternatives bytes.Buffer
+	alternative := multipart.NewWriter(&alternatives)
+	if err := writeText(alternative, "text/plain; charset=utf-8", msg.Text); err != nil {
+		return nil, err
+	}
+	if msg.HTML != "" {
+		if err := writeText(alternative, "text/html; charset=utf-8", msg.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, err
+	}
+
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(alternatives.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range msg.Attachments {
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(header), buf.Bytes()...), nil
+}
+
+func writeText(writer *multipart.Writer, contentType string, text string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := quotedprintable.NewWriter(part)
+	if _, err := encoder.Write([]byte(text)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// writeBase64 encodes data in lines of 76 characters, as MIME requires
+func writeBase64(part io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		line := encoded[:min(76, len(encoded))]
+		encoded = encoded[len(line):]
+		if _, err := part.Write([]byte(line + "\r\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func randomId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string // 25 by default
+	Username string // No authentication when empty, e.g. with a local catch-all server
+	Password string
+}
+
+// SMTPTransport sends messages through an SMTP server, STARTTLS is used when it offers it
+type SMTPTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+func NewSMTPTransport(config SMTPConfig) (*SMTPTransport, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required")
+	}
+	if config.Port == "" {
+		config.Port = "25"
+	}
+
+	transport := &SMTPTransport{addr: net.JoinHostPort(config.Host, config.Port)}
+	if config.Username != "" {
+		transport.auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return transport, nil
+}
+
+func (s *SMTPTransport) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %v", msg.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %v", msg.To, err)
+	}
+
+	data, err := Encode(msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, from.Address, []string{to.Address}, data); err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", to.Address, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates of the emails sent to customers. Each one is a .txt file defining the subject and
+// the plain text body, and a .html file defining the content of layout.html.
+const (
+	OrderConfirmation = "order_confirmation"
+	PaymentReceipt    = "payment_receipt"
+	RefundNotice      = "refund_notice"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Render fills the template name with data
+func Render(name string, data any) (subject string, text string, html string, err error) {
+	textTemplate, err := texttemplate.ParseFS(templateFiles, "templates/"+name+".txt")
+	if err != nil {
+		return "", "", "", err
+	}
+	htmlTemplate, err := htmltemplate.ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var buf bytes.Buffer
+	if err := textTemplate.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := textTemplate.ExecuteTemplate(&buf, name+".txt", data); err != nil {
+		return "", "", "", err
+	}
+	text = strings.TrimSpace(buf.String()) + "\n"
+
+	buf.Reset()
+	if err := htmlTemplate.ExecuteTemplate(&buf, "layout.html", data); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, buf.String(), nil
+}
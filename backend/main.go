@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 	"trinity/backend/auth/middlewares"
 	"trinity/backend/db"
 	seed "trinity/backend/db/seeds"
+	"trinity/backend/items/entities"
 	"trinity/backend/items/models"
+	"trinity/backend/jobs"
+	"trinity/backend/mailer"
+	"trinity/backend/pricing"
+	"trinity/backend/push"
 	"trinity/backend/routes"
+	"trinity/backend/storage"
 	"trinity/backend/validators"
 
-	validator "github.com/go-playground/validator/v10"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	echo "github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -32,17 +39,38 @@ func main() {
 	}
 	log.Println("Connection to the database successful")
 
+	entities.DefaultCurrency = pricing.BaseCurrency()
+	log.Printf("Base currency: %s", entities.DefaultCurrency)
+
 	err_seed := seed.SeedAll(conn)
 	if err_seed != nil {
 		log.Fatal("Error seeding the database", err_seed)
 	}
 
+	jobs.Every(time.Minute, "scheduled price changes", models.ApplyScheduledPriceChanges)
+	jobs.Every(time.Minute, "promotion status", models.UpdatePromotionStatuses)
+	jobs.Every(time.Minute, "pending order expiry", models.ExpirePendingInvoices)
+	jobs.Every(models.RecommendationsInterval(), "product recommendations", models.ComputeRecommendations)
+
+	blobStore, err := storage.NewBlobStoreFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize blob store ", err)
+	}
+	models.SetBlobStore(blobStore)
+
+	mailTransport, err := mailer.NewTransportFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize mail transport ", err)
+	}
+	models.SetMailTransport(mailTransport)
+	jobs.Every(time.Minute, "mail outbox", models.SendPendingMails)
+
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
 	// Register validator
-	e.Validator = &validators.CustomValidator{Validator: validator.New()}
+	e.Validator = &validators.CustomValidator{Validator: validators.NewValidator()}
 
 	// Public Routes
 	routes.PublicRoutes(e)
@@ -58,24 +86,43 @@ func main() {
 	routes.UserRoutes(protectedGroup)
 	routes.InvoiceRoutes(protectedGroup)
 	routes.ProductRoutes(protectedGroup)
+	routes.CategoryRoutes(protectedGroup)
+	routes.VatRoutes(protectedGroup)
+	routes.CurrencyRoutes(protectedGroup)
+	routes.PromotionRoutes(protectedGroup)
+	routes.LoyaltyRoutes(protectedGroup)
 	routes.ReportGroup(protectedGroup)
 	routes.StatsRoutes(protectedGroup)
+	routes.CartRoutes(protectedGroup)
 	routes.PaymentRoutes(protectedGroup)
+	routes.MailRoutes(protectedGroup)
 	routes.PushNotificationRoutes(protectedGroup)
+	routes.CampaignRoutes(protectedGroup)
 
-	_, err := os.Stat("com-baptistegrimaldi-trinity-firebase.json")
-	if err == nil {
-		log.Println("Firebase authentication file found")
-		fcmService, err := models.InitFCMService("com-baptistegrimaldi-trinity-firebase.json")
-		if err != nil {
-			log.Fatal("Failed to initialize FCM service ", err)
-		} else {
+	if push.FCMEnabled() {
+		credentials := push.FCMCredentialsFile()
+		if _, err := os.Stat(credentials); err == nil {
+			log.Println("Firebase authentication file found")
+			fcmService, err := models.InitFCMService(credentials)
+			if err != nil {
+				log.Fatal("Failed to initialize FCM service ", err)
+			}
 			log.Println("FCM service initialized")
+			models.SetFCMService(fcmService)
+		} else {
+			log.Println("Firebase authentication file not found")
 		}
-		models.SetFCMService(fcmService)
 	} else {
-		log.Println("Firebase authentication file not found")
+		pushSender, err := push.NewSenderFromEnv(context.Background())
+		if err != nil {
+			log.Fatal("Failed to initialize push sender ", err)
+		}
+		log.Println("Push sender initialized")
+		models.SetPushSender(pushSender)
 	}
+	models.StartNotificationWorkers(context.Background())
+	models.OnOrderStatusChange(models.NotifyOrderStatus)
+	jobs.Every(time.Minute, "notification campaigns", models.DispatchDueCampaigns)
 
 	e.Logger.Fatal(e.Start(":" + port))
 	log.Println("Server started ! Listening on port", port)
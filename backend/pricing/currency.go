@@ -0,0 +1,86 @@
+package pricing
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const defaultBaseCurrency = "EUR"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// BaseCurrency is the currency of catalog prices and of the stats, from BASE_CURRENCY
+func BaseCurrency() string {
+	currency := strings.ToUpper(strings.TrimSpace(os.Getenv("BASE_CURRENCY")))
+	if !currencyCode.MatchString(currency) {
+		return defaultBaseCurrency
+	}
+	return currency
+}
+
+// StoreCurrencies lists the currencies customers may be charged in, from the comma-separated
+// STORE_CURRENCIES. The base currency is always accepted.
+// Amounts are kept in cents, so only currencies with two decimals are supported.
+func StoreCurrencies() []string {
+	base := BaseCurrency()
+	currencies := []string{base}
+	for _, currency := range strings.Split(os.Getenv("STORE_CURRENCIES"), ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currencyCode.MatchString(currency) && currency != base {
+			currencies = append(currencies, currency)
+		}
+	}
+	return currencies
+}
+
+func IsStoreCurrency(currency string) bool {
+	for _, accepted := range StoreCurrencies() {
+		if accepted == currency {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidCurrencyCode(currency string) bool {
+	return currencyCode.MatchString(currency)
+}
+
+// ParseRate reads an exchange rate such as "1.0835" exactly, rates must be positive
+func ParseRate(rate string) (*big.Rat, error) {
+	parsed, ok := new(big.Rat).SetString(strings.TrimSpace(rate))
+	if !ok || parsed.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", rate)
+	}
+	return parsed, nil
+}
+
+// Convert multiplies an amount in minor units by an exchange rate, rounding half away from zero
+func Convert(amount int64, rate string) (int64, error) {
+	parsed, err := ParseRate(rate)
+	if err != nil {
+		return 0, err
+	}
+
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(amount), parsed)
+	num, den := product.Num(), product.Denom()
+
+	// (2 * num + den) / (2 * den) rounds half up for positive amounts
+	negative := num.Sign() < 0
+	num = new(big.Int).Abs(num)
+	rounded := new(big.Int).Quo(
+		new(big.Int).Add(new(big.Int).Mul(num, big.NewInt(2)), den),
+		new(big.Int).Mul(den, big.NewInt(2)),
+	)
+	if !rounded.IsInt64() {
+		return 0, fmt.Errorf("converted amount overflows")
+	}
+
+	if negative {
+		return -rounded.Int64(), nil
+	}
+	return rounded.Int64(), nil
+}
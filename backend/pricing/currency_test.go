@@ -0,0 +1,37 @@
+package pricing
+
+import "testing"
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		amount int64
+		rate   string
+		want   int64
+	}{
+		{1000, "1.0835", 1084},
+		{999, "1.1", 1099},
+		{5, "0.5", 3},   // half rounds up
+		{-5, "0.5", -3}, // and away from zero for refunds
+		{1, "0.4", 0},
+		{0, "1.5", 0},
+		{1999, "1", 1999},
+	}
+	for _, c := range cases {
+		got, err := Convert(c.amount, c.rate)
+		if err != nil {
+			t.Errorf("Convert(%d, %q): %v", c.amount, c.rate, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Convert(%d, %q) = %d, want %d", c.amount, c.rate, got, c.want)
+		}
+	}
+}
+
+func TestConvertRejectsInvalidRates(t *testing.T) {
+	for _, rate := range []string{"", "0", "-1.2", "abc"} {
+		if _, err := Convert(100, rate); err == nil {
+			t.Errorf("Convert(100, %q) succeeded, want an error", rate)
+		}
+	}
+}
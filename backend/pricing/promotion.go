@@ -0,0 +1,162 @@
+package pricing
+
+import (
+	"sort"
+)
+
+// Discount types of promotions
+const (
+	DiscountPercentage = "percentage"
+	DiscountFixed      = "fixed"
+	DiscountBogo       = "bogo"
+)
+
+// CartLine is a cart line before any discount, in minor units
+type CartLine struct {
+	ProductId   string
+	CategoryIds []string // The product categories along with their ancestors
+	Quantity    int64
+	UnitPrice   int64
+}
+
+// Promotion is a discount to apply to a cart. Value is in basis points for percentage
+// discounts and for bogo ones, where it is the discount on every second unit (10000 makes
+// it free), and in minor units for fixed discounts. Without ProductIds nor CategoryIds, every
+// line is targeted.
+type Promotion struct {
+	Id          string
+	Type        string
+	Value       int64
+	ProductIds  []string
+	CategoryIds []string
+}
+
+// AppliedPromotion is what a promotion took off each cart line
+type AppliedPromotion struct {
+	Id    string
+	Lines []int64 // In the order of the cart lines
+	Total int64
+}
+
+// promotionOrder applies the per unit discounts before the amounts taken off the cart
+var promotionOrder = map[string]int{DiscountBogo: 0, DiscountPercentage: 1, DiscountFixed: 2}
+
+func (p Promotion) targets(line CartLine) bool {
+	if len(p.ProductIds) == 0 && len(p.CategoryIds) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIds {
+		if id == line.ProductId {
+			return true
+		}
+	}
+	for _, id := range p.CategoryIds {
+		for _, categoryId := range line.CategoryIds {
+			if id == categoryId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ApplyPromotions applies every promotion to what the previous ones left of the lines, bogo
+// first, then percentages, then fixed amounts. A line never goes below zero.
+func ApplyPromotions(lines []CartLine, promotions []Promotion) []AppliedPromotion {
+	remaining := make([]int64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.UnitPrice * line.Quantity
+	}
+
+	sorted := make([]Promotion, len(promotions))
+	copy(sorted, promotions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return promotionOrder[sorted[i].Type] < promotionOrder[sorted[j].Type]
+	})
+
+	applied := make([]AppliedPromotion, 0, len(sorted))
+	for _, promotion := range sorted {
+		discounts := make([]int64, len(lines))
+
+		switch promotion.Type {
+		case DiscountPercentage:
+			for i, line := range lines {
+				if promotion.targets(line) {
+					discounts[i] = divRound(remaining[i]*promotion.Value, basisPoints)
+				}
+			}
+		case DiscountBogo:
+			for i, line := range lines {
+				if promotion.targets(line) {
+					free := line.Quantity / 2
+					discounts[i] = divRound(free*line.UnitPrice*promotion.Value, basisPoints)
+				}
+			}
+		case DiscountFixed:
+			discounts = spreadAmount(lines, remaining, promotion)
+		}
+
+		result := AppliedPromotion{Id: promotion.Id, Lines: discounts}
+		for i := range discounts {
+			if discounts[i] > remaining[i] {
+				discounts[i] = remaining[i]
+			}
+			if discounts[i] < 0 {
+				discounts[i] = 0
+			}
+			remaining[i] -= discounts[i]
+			result.Total += discounts[i]
+		}
+		applied = append(applied, result)
+	}
+
+	return applied
+}
+
+// spreadAmount splits a fixed discount over the targeted lines in proportion to what is
+// left of them, so that every line keeps its own VAT rate
+func spreadAmount(lines []CartLine, remaining []int64, promotion Promotion) []int64 {
+	targeted := make([]int64, len(lines))
+	for i, line := range lines {
+		if promotion.targets(line) {
+			targeted[i] = remaining[i]
+		}
+	}
+	if promotion.Value <= 0 {
+		return make([]int64, len(lines))
+	}
+	return SpreadDiscount(targeted, promotion.Value)
+}
+
+// SpreadDiscount splits amount over lines in proportion to their amounts, without taking
+// more than the lines are worth
+func SpreadDiscount(amounts []int64, amount int64) []int64 {
+	discounts := make([]int64, len(amounts))
+
+	var total int64
+	for _, lineAmount := range amounts {
+		total += lineAmount
+	}
+	if total <= 0 || amount <= 0 {
+		return discounts
+	}
+
+	amount = min(amount, total)
+	spread := int64(0)
+	for i, lineAmount := range amounts {
+		discounts[i] = amount * lineAmount / total
+		spread += discounts[i]
+	}
+
+	// Hand out the cents lost by rounding down, one per line
+	for i := range amounts {
+		if spread == amount {
+			break
+		}
+		if discounts[i] < amounts[i] {
+			discounts[i]++
+			spread++
+		}
+	}
+	return discounts
+}
@@ -0,0 +1,93 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpreadDiscount(t *testing.T) {
+	cases := []struct {
+		name    string
+		amounts []int64
+		amount  int64
+		want    []int64
+	}{
+		{"even", []int64{100, 300}, 100, []int64{25, 75}},
+		{"remainder to the first lines", []int64{100, 100, 100}, 100, []int64{34, 33, 33}},
+		{"remainder over several lines", []int64{1, 1, 1}, 2, []int64{1, 1, 0}},
+		{"rounded shares", []int64{50, 150}, 101, []int64{26, 75}},
+		{"capped at the lines", []int64{300, 100}, 1000, []int64{300, 100}},
+		{"empty line", []int64{1000, 0}, 500, []int64{500, 0}},
+		{"nothing to spread", []int64{100, 200}, 0, []int64{0, 0}},
+		{"negative amount", []int64{100, 200}, -5, []int64{0, 0}},
+		{"nothing to discount", []int64{0, 0}, 100, []int64{0, 0}},
+	}
+	for _, c := range cases {
+		if got := SpreadDiscount(c.amounts, c.amount); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: SpreadDiscount(%v, %d) = %v, want %v", c.name, c.amounts, c.amount, got, c.want)
+		}
+	}
+}
+
+func TestApplyPromotions(t *testing.T) {
+	cases := []struct {
+		name       string
+		lines      []CartLine
+		promotions []Promotion
+		want       []AppliedPromotion
+	}{
+		{
+			name:  "bogo, then percentage, then fixed",
+			lines: []CartLine{{ProductId: "a", Quantity: 2, UnitPrice: 1000}},
+			promotions: []Promotion{
+				{Id: "fixed", Type: DiscountFixed, Value: 300},
+				{Id: "percentage", Type: DiscountPercentage, Value: 1000},
+				{Id: "bogo", Type: DiscountBogo, Value: 10000},
+			},
+			want: []AppliedPromotion{
+				{Id: "bogo", Lines: []int64{1000}, Total: 1000},
+				{Id: "percentage", Lines: []int64{100}, Total: 100},
+				{Id: "fixed", Lines: []int64{300}, Total: 300},
+			},
+		},
+		{
+			name:       "percentage rounding",
+			lines:      []CartLine{{ProductId: "a", Quantity: 1, UnitPrice: 333}},
+			promotions: []Promotion{{Id: "percentage", Type: DiscountPercentage, Value: 1500}},
+			want:       []AppliedPromotion{{Id: "percentage", Lines: []int64{50}, Total: 50}},
+		},
+		{
+			name:       "half price on the second unit of an odd quantity",
+			lines:      []CartLine{{ProductId: "a", Quantity: 3, UnitPrice: 400}},
+			promotions: []Promotion{{Id: "bogo", Type: DiscountBogo, Value: 5000}},
+			want:       []AppliedPromotion{{Id: "bogo", Lines: []int64{200}, Total: 200}},
+		},
+		{
+			name:       "fixed discount never below zero",
+			lines:      []CartLine{{ProductId: "a", Quantity: 1, UnitPrice: 500}},
+			promotions: []Promotion{{Id: "fixed", Type: DiscountFixed, Value: 800}},
+			want:       []AppliedPromotion{{Id: "fixed", Lines: []int64{500}, Total: 500}},
+		},
+		{
+			name: "targeted products and categories",
+			lines: []CartLine{
+				{ProductId: "a", CategoryIds: []string{"food", "bread"}, Quantity: 1, UnitPrice: 1000},
+				{ProductId: "b", CategoryIds: []string{"food", "dairy"}, Quantity: 1, UnitPrice: 1000},
+				{ProductId: "c", CategoryIds: []string{"drinks"}, Quantity: 1, UnitPrice: 1000},
+			},
+			promotions: []Promotion{
+				{Id: "product", Type: DiscountPercentage, Value: 1000, ProductIds: []string{"c"}},
+				{Id: "category", Type: DiscountFixed, Value: 300, CategoryIds: []string{"food"}},
+			},
+			want: []AppliedPromotion{
+				{Id: "product", Lines: []int64{0, 0, 100}, Total: 100},
+				{Id: "category", Lines: []int64{150, 150, 0}, Total: 300},
+			},
+		},
+	}
+	for _, c := range cases {
+		if got := ApplyPromotions(c.lines, c.promotions); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pricing
+
+import (
+	"sort"
+)
+
+// Amounts are handled in integer minor units (cents) and VAT rates in basis points
+// (2000 = 20%, 550 = 5.5%), so that totals never suffer from float rounding.
+const basisPoints = 10000
+
+// divRound divides a by b (b > 0) rounding half away from zero
+func divRound(a int64, b int64) int64 {
+	if a < 0 {
+		return -divRound(-a, b)
+	}
+	return (2*a + b) / (2 * b)
+}
+
+// Amounts of an order line or an invoice, in minor units
+type Amounts struct {
+	Net   int64
+	Tax   int64
+	Gross int64
+}
+
+func (a Amounts) Add(b Amounts) Amounts {
+	return Amounts{Net: a.Net + b.Net, Tax: a.Tax + b.Tax, Gross: a.Gross + b.Gross}
+}
+
+// SplitGross splits an amount including VAT, such as a discounted line, into net and tax.
+// The tax is derived from the rounded net so that net + tax always equals gross.
+func SplitGross(gross int64, rate int64) Amounts {
+	net := divRound(gross*basisPoints, basisPoints+rate)
+	return Amounts{Net: net, Tax: gross - net, Gross: gross}
+}
+
+// TaxedLine is an order line amount along with the VAT rate it was taxed at
+type TaxedLine struct {
+	RateId  string
+	Rate    int64
+	Amounts Amounts
+}
+
+// TaxGroup sums the lines sharing a VAT rate
+type TaxGroup struct {
+	RateId  string
+	Rate    int64
+	Amounts Amounts
+}
+
+// Summarize groups lines per VAT rate, highest rate first, and returns the invoice totals
+func Summarize(lines []TaxedLine) ([]TaxGroup, Amounts) {
+	groups := map[string]*TaxGroup{}
+	var order []string
+	var total Amounts
+
+	for _, line := range lines {
+		group, ok := groups[line.RateId]
+		if !ok {
+			group = &TaxGroup{RateId: line.RateId, Rate: line.Rate}
+			groups[line.RateId] = group
+			order = append(order, line.RateId)
+		}
+		group.Amounts = group.Amounts.Add(line.Amounts)
+		total = total.Add(line.Amounts)
+	}
+
+	breakdown := make([]TaxGroup, 0, len(order))
+	for _, id := range order {
+		breakdown = append(breakdown, *groups[id])
+	}
+	sort.SliceStable(breakdown, func(i, j int) bool {
+		return breakdown[i].Rate > breakdown[j].Rate
+	})
+
+	return breakdown, total
+}
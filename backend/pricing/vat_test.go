@@ -0,0 +1,47 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitGross(t *testing.T) {
+	cases := []struct {
+		gross int64
+		rate  int64
+		want  Amounts
+	}{
+		{1200, 2000, Amounts{Net: 1000, Tax: 200, Gross: 1200}},
+		{1055, 550, Amounts{Net: 1000, Tax: 55, Gross: 1055}},
+		{999, 2000, Amounts{Net: 833, Tax: 166, Gross: 999}}, // 832.5 rounds up
+		{500, 0, Amounts{Net: 500, Tax: 0, Gross: 500}},
+		{0, 2000, Amounts{}},
+		{-1200, 2000, Amounts{Net: -1000, Tax: -200, Gross: -1200}},
+	}
+	for _, c := range cases {
+		if got := SplitGross(c.gross, c.rate); got != c.want {
+			t.Errorf("SplitGross(%d, %d) = %+v, want %+v", c.gross, c.rate, got, c.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	lines := []TaxedLine{
+		{RateId: "reduced", Rate: 550, Amounts: SplitGross(1055, 550)},
+		{RateId: "standard", Rate: 2000, Amounts: SplitGross(1200, 2000)},
+		{RateId: "standard", Rate: 2000, Amounts: SplitGross(999, 2000)},
+	}
+
+	breakdown, total := Summarize(lines)
+
+	want := []TaxGroup{
+		{RateId: "standard", Rate: 2000, Amounts: Amounts{Net: 1833, Tax: 366, Gross: 2199}},
+		{RateId: "reduced", Rate: 550, Amounts: Amounts{Net: 1000, Tax: 55, Gross: 1055}},
+	}
+	if !reflect.DeepEqual(breakdown, want) {
+		t.Errorf("got breakdown %+v, want %+v", breakdown, want)
+	}
+	if want := (Amounts{Net: 2833, Tax: 421, Gross: 3254}); total != want {
+		t.Errorf("got total %+v, want %+v", total, want)
+	}
+}
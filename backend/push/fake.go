@@ -0,0 +1,37 @@
+package push
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeSender keeps the messages it is given instead of sending them, for development and
+// tests. Tokens listed in Errors fail with the given error, e.g. ErrUnregistered.
+type FakeSender struct {
+	mu     sync.Mutex
+	sent   []Message
+	Errors map[string]error
+}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{Errors: map[string]error{}}
+}
+
+func (f *FakeSender) Send(ctx context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err, ok := f.Errors[msg.Token]; ok {
+		return err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far
+func (f *FakeSender) Sent() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Message(nil), f.sent...)
+}
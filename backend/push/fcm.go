@@ -0,0 +1,101 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	fcm "google.golang.org/api/fcm/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// FCMSender sends notifications through the Firebase Cloud Messaging v1 API
+type FCMSender struct {
+	service *fcm.Service
+	parent  string // projects/<project ID>
+}
+
+// NewFCMService connects to FCM with the service account in credentialsFile
+func NewFCMService(ctx context.Context, credentialsFile string) (*fcm.Service, error) {
+	return fcm.NewService(ctx, option.WithCredentialsFile(credentialsFile), option.WithScopes(fcm.FirebaseMessagingScope))
+}
+
+func NewFCMSender(ctx context.Context, credentialsFile string, projectId string) (*FCMSender, error) {
+	service, err := NewFCMService(ctx, credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewFCMSenderFromService(service, projectId), nil
+}
+
+// NewFCMSenderFromService sends through an FCM service that is already set up
+func NewFCMSenderFromService(service *fcm.Service, projectId string) *FCMSender {
+	return &FCMSender{service: service, parent: "projects/" + projectId}
+}
+
+func (f *FCMSender) Send(ctx context.Context, msg Message) error {
+	sendReq := &fcm.SendMessageRequest{
+		Message: &fcm.Message{
+			Token: msg.Token,
+			Notification: &fcm.Notification{
+				Title: msg.Title,
+				Body:  msg.Body,
+			},
+			Data: msg.Data,
+		},
+	}
+
+	_, err := f.service.Projects.Messages.Send(f.parent, sendReq).Context(ctx).Do()
+	if err != nil && isUnregistered(err) {
+		return fmt.Errorf("%w: %v", ErrUnregistered, err)
+	}
+	return err
+}
+
+// fcmError is the body of an FCM error response, the FcmError detail holds the errorCode and
+// the BadRequest one the fields that were rejected
+type fcmError struct {
+	Error struct {
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode       string `json:"errorCode"`
+			FieldViolations []struct {
+				Field string `json:"field"`
+			} `json:"fieldViolations"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// isUnregistered tells apart the tokens FCM rejects for good from other failures: the token
+// is no longer registered, or it is the invalid argument of the request. Any other invalid
+// argument is an error of the message itself, which the token has nothing to do with.
+func isUnregistered(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	var body fcmError
+	if json.Unmarshal([]byte(apiErr.Body), &body) != nil {
+		return false
+	}
+
+	invalidArgument := body.Error.Status == "INVALID_ARGUMENT"
+	invalidToken := false
+	for _, detail := range body.Error.Details {
+		switch detail.ErrorCode {
+		case "UNREGISTERED":
+			return true
+		case "INVALID_ARGUMENT":
+			invalidArgument = true
+		}
+		for _, violation := range detail.FieldViolations {
+			if violation.Field == "message.token" {
+				invalidToken = true
+			}
+		}
+	}
+	return invalidArgument && invalidToken
+}
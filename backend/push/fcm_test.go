@@ -0,0 +1,94 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	fcm "google.golang.org/api/fcm/v1"
+	"google.golang.org/api/option"
+)
+
+// newTestFCMSender sends to a server answering every message with status and body
+func newTestFCMSender(t *testing.T, status int, body string) *FCMSender {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := fcm.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &FCMSender{service: service, parent: "projects/test"}
+}
+
+func TestFCMSenderErrors(t *testing.T) {
+	cases := []struct {
+		name         string
+		status       int
+		body         string
+		unregistered bool
+	}{
+		{
+			name:   "unregistered token",
+			status: http.StatusNotFound,
+			body: `{"error": {"code": 404, "message": "Requested entity was not found.", "status": "NOT_FOUND", "details": [
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`,
+			unregistered: true,
+		},
+		{
+			name:   "invalid token",
+			status: http.StatusBadRequest,
+			body: `{"error": {"code": 400, "message": "The registration token is not a valid FCM registration token", "status": "INVALID_ARGUMENT", "details": [
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "INVALID_ARGUMENT"},
+				{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "message.token", "description": "Invalid registration token"}]}]}}`,
+			unregistered: true,
+		},
+		{
+			name:   "invalid message",
+			status: http.StatusBadRequest,
+			body: `{"error": {"code": 400, "message": "Invalid value at 'message.data[0].value'", "status": "INVALID_ARGUMENT", "details": [
+				{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "message.data[0].value", "description": "Invalid value"}]}]}}`,
+		},
+		{
+			name:   "unknown project",
+			status: http.StatusNotFound,
+			body:   `{"error": {"code": 404, "message": "Requested entity was not found.", "status": "NOT_FOUND"}}`,
+		},
+		{
+			name:   "quota exceeded",
+			status: http.StatusTooManyRequests,
+			body: `{"error": {"code": 429, "message": "Quota exceeded.", "status": "RESOURCE_EXHAUSTED", "details": [
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"}]}}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sender := newTestFCMSender(t, c.status, c.body)
+
+			err := sender.Send(context.Background(), Message{Token: "token", Title: "Title", Body: "Body"})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := errors.Is(err, ErrUnregistered); got != c.unregistered {
+				t.Errorf("got unregistered %v, want %v: %v", got, c.unregistered, err)
+			}
+		})
+	}
+}
+
+func TestFCMSenderSends(t *testing.T) {
+	sender := newTestFCMSender(t, http.StatusOK, `{"name": "projects/test/messages/1"}`)
+
+	if err := sender.Send(context.Background(), Message{Token: "token", Title: "Title", Body: "Body"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,68 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrUnregistered is returned for a token that will never be accepted again, because the
+// app was uninstalled or the token is not a valid one. The device should be forgotten.
+var ErrUnregistered = errors.New("device token is no longer registered")
+
+// Message is a notification for one device
+type Message struct {
+	Token string
+	Title string
+	Body  string
+	Data  map[string]string // Passed on to the app
+}
+
+// Sender delivers notifications to devices
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSenderFromEnv builds the sender selected by PUSH_SENDER: "fcm" (by default) sends through
+// Firebase Cloud Messaging with the service account in FCM_CREDENTIALS_FILE, "fake" only keeps
+// the messages in memory. No sender is returned when the FCM credentials file is missing.
+func NewSenderFromEnv(ctx context.Context) (Sender, error) {
+	if FCMEnabled() {
+		credentials := FCMCredentialsFile()
+		if _, err := os.Stat(credentials); err != nil {
+			return nil, nil
+		}
+		return NewFCMSender(ctx, credentials, FCMProjectId())
+	}
+
+	switch strings.ToLower(os.Getenv("PUSH_SENDER")) {
+	case "fake":
+		return NewFakeSender(), nil
+	default:
+		return nil, fmt.Errorf("unknown PUSH_SENDER %q", os.Getenv("PUSH_SENDER"))
+	}
+}
+
+// FCMEnabled tells whether PUSH_SENDER selects Firebase Cloud Messaging, the default
+func FCMEnabled() bool {
+	sender := strings.ToLower(os.Getenv("PUSH_SENDER"))
+	return sender == "" || sender == "fcm"
+}
+
+// FCMCredentialsFile is the service account FCM is used with, FCM_CREDENTIALS_FILE
+func FCMCredentialsFile() string {
+	if credentials := os.Getenv("FCM_CREDENTIALS_FILE"); credentials != "" {
+		return credentials
+	}
+	return "com-baptistegrimaldi-trinity-firebase.json"
+}
+
+// FCMProjectId is the Firebase project the notifications are sent from, FCM_PROJECT_ID
+func FCMProjectId() string {
+	if projectId := os.Getenv("FCM_PROJECT_ID"); projectId != "" {
+		return projectId
+	}
+	return "com-baptistegrimaldi-trinity"
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"trinity/backend/auth/middlewares"
 	"trinity/backend/controllers"
 
 	echo "github.com/labstack/echo/v4"
@@ -13,8 +14,18 @@ func PublicRoutes(e *echo.Echo) {
 	e.POST("/user/login", controllers.LoginUser)
 
 	e.GET("/product/barcode/:barcode", controllers.GetProductsByBarcode)
+	e.GET("/product/search", controllers.GetProductsBySearch)
+	e.GET("/product/:id/image/:size", controllers.GetProductImage)
+	e.GET("/product/:id/related", controllers.GetRelatedProducts)
 	e.GET("/product/search/:name", controllers.GetProductsBySearch)
 
+	e.GET("/currency", controllers.GetCurrencies)
+	e.GET("/category", controllers.GetCategories)
+	e.GET("/category/slug/:slug", controllers.GetCategoryBySlug)
+	e.GET("/category/:id", controllers.GetCategory)
+	e.GET("/category/:id/children", controllers.GetCategoryChildren)
+	e.GET("/category/:id/products", controllers.GetCategoryProducts)
+
 	e.GET("/promo/deals", controllers.GetDeals)
 
 	e.GET("/payment/return", controllers.ReturnPayment)
@@ -28,12 +39,14 @@ func UserRoutes(e *echo.Group) {
 	userGroup.GET("", controllers.GetUsers) // Get all users
 	userGroup.GET("/self", controllers.GetSelfUserBasic)
 	userGroup.GET("/:id", controllers.GetUserBasic)
+	userGroup.GET("/self/loyalty", controllers.GetSelfLoyalty)
 	userGroup.GET("/details/self", controllers.GetSelfDetails)
 	userGroup.GET("/details/:id", controllers.GetUserDetails)
 	// userGroup.POST("", controllers.CreateUser) // Create a new user
 	userGroup.PUT("/self", controllers.UpdateSelfUser)
 	userGroup.PUT("/self/password", controllers.UpdateSelfPassword)
 	userGroup.PUT("/:id", controllers.UpdateOtherUser)
+	userGroup.PUT("/:id/segments", controllers.SetUserSegments)
 	userGroup.DELETE("/self", controllers.ArchiveSelfUser)
 	userGroup.DELETE("/:id", controllers.ArchiveUser)
 
@@ -47,8 +60,16 @@ func InvoiceRoutes(e *echo.Group) {
 	invoiceGroup.GET("", controllers.GetInvoices)
 	invoiceGroup.GET("/self", controllers.GetSelfInvoices)
 	invoiceGroup.GET("/self/:id", controllers.GetSelfInvoicesById)
+	invoiceGroup.POST("/self/:id/cancel", controllers.CancelSelfInvoice)
+	invoiceGroup.GET("/self/:id/pdf", controllers.GetSelfInvoicePDF)
+	invoiceGroup.GET("/self/credit-notes", controllers.GetSelfCreditNotes)
+	invoiceGroup.GET("/self/credit-notes/:id/pdf", controllers.GetSelfCreditNotePDF)
+	invoiceGroup.GET("/credit-notes", controllers.GetCreditNotes)
 	invoiceGroup.GET("/history/self", controllers.GetHistorySelfInvoices)
 	invoiceGroup.POST("", controllers.CreateInvoice)
+	invoiceGroup.POST("/:id/refund", controllers.RefundInvoice)
+	invoiceGroup.POST("/:id/cancel", controllers.CancelInvoice)
+	invoiceGroup.POST("/:id/ready", controllers.MarkOrderReady)
 	// invoiceGroup.PUT("/:id", controllers.UpdateInvoice)
 	invoiceGroup.DELETE("/:id", controllers.ArchiveInvoice)
 }
@@ -60,10 +81,75 @@ func ProductRoutes(e *echo.Group) {
 	productGroup.GET("", controllers.GetProducts)
 	// productGroup.GET("/barcode/:barcode", controllers.GetProductsByBarcode)
 	productGroup.POST("", controllers.AddProduct)
+	productGroup.POST("/import", controllers.ImportProducts)
+	productGroup.GET("/import/:id", controllers.GetImportJob)
+	productGroup.GET("/export", controllers.ExportProducts)
 	productGroup.PUT("/:id", controllers.UpdateProduct)
+	productGroup.POST("/:id/sync", controllers.SyncProduct)
+	productGroup.PUT("/:id/vat", controllers.SetProductVatRate)
+	productGroup.GET("/:id/prices", controllers.GetProductPrices)
+	productGroup.POST("/:id/prices", controllers.ChangeProductPrice)
+	productGroup.DELETE("/:id/prices/:changeId", controllers.CancelProductPriceChange)
+	productGroup.POST("/:id/image", controllers.UploadProductImage)
+	productGroup.POST("/:id/image/mirror", controllers.MirrorProductImage)
+	productGroup.DELETE("/:id/image", controllers.DeleteProductImage)
 	productGroup.DELETE("/:id", controllers.ArchiveProduct)
 
 	productGroup.GET("/promo/self", controllers.GetSelfPromo)
+	productGroup.GET("/recommendations/self", controllers.GetSelfRecommendations)
+}
+
+func CategoryRoutes(e *echo.Group) {
+
+	categoryGroup := e.Group("/category")
+
+	categoryGroup.POST("/import", controllers.ImportCategories)
+	categoryGroup.POST("/sync", controllers.SyncCategories)
+	categoryGroup.PUT("/:id/vat", controllers.SetCategoryVatRate)
+	categoryGroup.PUT("/:id/parent", controllers.MoveCategory)
+}
+
+func CurrencyRoutes(e *echo.Group) {
+
+	currencyGroup := e.Group("/currency")
+
+	currencyGroup.PUT("/:code", controllers.SetExchangeRate)
+	currencyGroup.POST("/reload", controllers.ReloadExchangeRates)
+}
+
+func VatRoutes(e *echo.Group) {
+
+	vatGroup := e.Group("/vat")
+
+	vatGroup.GET("", controllers.GetVatRates)
+	vatGroup.POST("", controllers.AddVatRate)
+	vatGroup.PUT("/:id", controllers.UpdateVatRate)
+}
+
+func PromotionRoutes(e *echo.Group) {
+
+	promoGroup := e.Group("/promo")
+
+	promoGroup.GET("", controllers.GetPromotions)
+	promoGroup.GET("/stats", controllers.GetPromotionsStats)
+	promoGroup.GET("/:id", controllers.GetPromotion)
+	promoGroup.GET("/:id/stats", controllers.GetPromotionStats)
+	promoGroup.POST("", controllers.AddPromotion)
+	promoGroup.PUT("/:id", controllers.UpdatePromotion)
+	promoGroup.POST("/:id/pause", controllers.PausePromotion)
+	promoGroup.POST("/:id/resume", controllers.ResumePromotion)
+	promoGroup.POST("/:id/expire", controllers.ExpirePromotion)
+	promoGroup.DELETE("/:id", controllers.DeletePromotion)
+}
+
+func LoyaltyRoutes(e *echo.Group) {
+
+	loyaltyGroup := e.Group("/loyalty")
+
+	loyaltyGroup.GET("/rules", controllers.GetLoyaltyRules)
+	loyaltyGroup.POST("/rules", controllers.AddLoyaltyRule)
+	loyaltyGroup.PUT("/rules/:id", controllers.UpdateLoyaltyRule)
+	loyaltyGroup.DELETE("/rules/:id", controllers.DeleteLoyaltyRule)
 }
 
 func StatsRoutes(e *echo.Group) {
@@ -89,16 +175,56 @@ func ReportGroup(e *echo.Group) {
 
 }
 
+func CartRoutes(e *echo.Group) {
+	cartGroup := e.Group("/cart")
+
+	cartGroup.GET("/self", controllers.GetSelfCart)
+	cartGroup.PUT("/self", controllers.UpdateSelfCartOptions)
+	cartGroup.DELETE("/self", controllers.ClearSelfCart)
+	cartGroup.POST("/self/items", controllers.AddSelfCartItem)
+	cartGroup.PUT("/self/items/:productId", controllers.UpdateSelfCartItem)
+	cartGroup.DELETE("/self/items/:productId", controllers.RemoveSelfCartItem)
+	cartGroup.POST("/self/checkout", controllers.CheckoutSelfCart, middlewares.Idempotency())
+}
+
 func PaymentRoutes(e *echo.Group) {
 	paymentGroup := e.Group("/payment")
 
-	paymentGroup.POST("/create", controllers.CreatePayment)
-	paymentGroup.POST("/capture", controllers.CapturePayment)
+	paymentGroup.POST("/quote", controllers.QuotePayment)
+	// Retried requests, e.g. on a flaky connection, can send an Idempotency-Key header
+	paymentGroup.POST("/create", controllers.CreatePayment, middlewares.Idempotency())
+	paymentGroup.POST("/capture", controllers.CapturePayment, middlewares.Idempotency())
+}
+
+func MailRoutes(e *echo.Group) {
+	mailGroup := e.Group("/mail")
+
+	mailGroup.GET("", controllers.GetMails)
+	mailGroup.POST("/:id/retry", controllers.RetryMail)
 }
 
 func PushNotificationRoutes(e *echo.Group) {
 	pushNotificationGroup := e.Group("/push-notification")
 
 	pushNotificationGroup.POST("/register-token", controllers.RegisterToken)
+	pushNotificationGroup.POST("/unregister-token", controllers.UnregisterToken)
+	pushNotificationGroup.GET("/self/devices", controllers.GetSelfDevices)
 	pushNotificationGroup.POST("/notify", controllers.NotifyHandler)
+	pushNotificationGroup.GET("/dispatches/:id", controllers.GetNotificationDispatch)
+	pushNotificationGroup.GET("/self/preferences", controllers.GetSelfNotificationPreferences)
+	pushNotificationGroup.PUT("/self/preferences", controllers.UpdateSelfNotificationPreferences)
+	pushNotificationGroup.GET("/self/history", controllers.GetSelfNotifications)
+	pushNotificationGroup.POST("/self/history/:id/open", controllers.MarkSelfNotificationOpened)
+}
+
+func CampaignRoutes(e *echo.Group) {
+	campaignGroup := e.Group("/campaign")
+
+	campaignGroup.GET("", controllers.GetCampaigns)
+	campaignGroup.POST("", controllers.CreateCampaign)
+	campaignGroup.GET("/:id", controllers.GetCampaign)
+	campaignGroup.PUT("/:id", controllers.UpdateCampaign)
+	campaignGroup.POST("/:id/send", controllers.SendCampaign)
+	campaignGroup.POST("/:id/cancel", controllers.CancelCampaign)
+	campaignGroup.DELETE("/:id", controllers.DeleteCampaign)
 }
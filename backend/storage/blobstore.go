@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+type Blob struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore stores binary files (product photos, documents) under slash-separated keys
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (Blob, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewBlobStoreFromEnv builds the store selected by BLOB_STORE ("local" by default or "s3")
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	switch strings.ToLower(os.Getenv("BLOB_STORE")) {
+	case "", "local":
+		root := os.Getenv("BLOB_LOCAL_DIR")
+		if root == "" {
+			root = "uploads"
+		}
+		return NewLocalStore(root)
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", os.Getenv("BLOB_STORE"))
+	}
+}
+
+// validKey rejects keys that could escape the store root
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "..") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore keeps blobs as plain files under Root, the content type is derived from the key extension
+type LocalStore struct {
+	Root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory %s: %v", root, err)
+	}
+	return &LocalStore{Root: root}, nil
+}
+
+func (l *LocalStore) path(key string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(key))
+}
+
+func (l *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	path := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial blob
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write blob %s: %v", key, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write blob %s: %v", key, err)
+	}
+
+	return nil
+}
+
+func (l *LocalStore) Get(ctx context.Context, key string) (Blob, error) {
+	if err := validKey(key); err != nil {
+		return Blob{}, err
+	}
+
+	path := l.path(key)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to read blob %s: %v", key, err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return Blob{Data: data, ContentType: contentType, ModTime: info.ModTime()}, nil
+}
+
+func (l *LocalStore) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(l.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob %s: %v", key, err)
+	}
+	return nil
+}
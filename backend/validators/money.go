@@ -0,0 +1,21 @@
+package validators
+
+import (
+	"reflect"
+	"trinity/backend/items/entities"
+
+	validator "github.com/go-playground/validator/v10"
+)
+
+// NewValidator returns a validator where Money fields are validated on their amount in cents,
+// so tags such as required or gt=0 keep working on prices
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterCustomTypeFunc(func(field reflect.Value) any {
+		if money, ok := field.Interface().(entities.Money); ok {
+			return money.Amount
+		}
+		return nil
+	}, entities.Money{})
+	return v
+}
@@ -31,7 +31,8 @@ class Promo {
       name: json['name'] ?? '',
       description: json['description'] ?? '',
       discountType: json['discount_type'] ?? '',
-      discountValue: (json['discount_value'] ?? 0).toDouble(),
+      // Sent in basis points (2000 = 20%) or cents for fixed discounts
+      discountValue: (json['discount_value'] ?? 0) / 100,
       code: json['code'] ?? '',
       startDate: json['start_date'] ?? '',
       endDate: json['end_date'] ?? '',